@@ -0,0 +1,216 @@
+// Package aclfs provides a vfs.Filesystem wrapper which enforces
+// path based access control rules for a principal.
+package aclfs
+
+import (
+	"context"
+	"os"
+	filepath "path"
+
+	"github.com/alexsnet/vfs"
+)
+
+// NewContext returns a context carrying the principal, see vfs.WithPrincipal.
+func NewContext(ctx context.Context, principal string) context.Context {
+	return vfs.WithPrincipal(ctx, principal)
+}
+
+// FromContext returns the principal carried by ctx, see vfs.PrincipalFromContext.
+func FromContext(ctx context.Context) (string, bool) {
+	return vfs.PrincipalFromContext(ctx)
+}
+
+// A FS that checks every operation against a Policy.
+//
+// Denied operations return a *os.PathError wrapping os.ErrPermission,
+// so errors.Is(err, fs.ErrPermission) holds.
+// ReadDir only returns the entries visible to the principal.
+type FS struct {
+	vfs.Filesystem
+
+	policy    *Policy
+	principal string
+}
+
+// Create returns a file system forwarding to root on behalf of principal.
+func Create(root vfs.Filesystem, policy *Policy, principal string) *FS {
+	return &FS{Filesystem: root, policy: policy, principal: principal}
+}
+
+// As returns a file system sharing root and policy acting on behalf of principal.
+func (fs *FS) As(principal string) *FS {
+	return &FS{Filesystem: fs.Filesystem, policy: fs.policy, principal: principal}
+}
+
+// WithContext returns a file system acting on behalf of the principal carried by ctx.
+// Without a principal in ctx the anonymous principal "" is used.
+func (fs *FS) WithContext(ctx context.Context) *FS {
+	p, _ := FromContext(ctx)
+	return fs.As(p)
+}
+
+// Principal returns the principal the file system acts on behalf of.
+func (fs *FS) Principal() string {
+	return fs.principal
+}
+
+func (fs *FS) check(op, name, pathOp string) error {
+	if fs.policy.Allowed(fs.principal, op, name) {
+		return nil
+	}
+	return &os.PathError{Op: pathOp, Path: name, Err: os.ErrPermission}
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return fs.Filesystem.PathSeparator() }
+
+// OpenFile implements vfs.Filesystem.
+// Reading requires OpRead, writing an existing file OpWrite
+// and creating a new file OpCreate.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) != os.O_WRONLY {
+		if err := fs.check(OpRead, name, "open"); err != nil {
+			return nil, err
+		}
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		op := OpWrite
+		if _, err := fs.Filesystem.Stat(name); os.IsNotExist(err) && flag&os.O_CREATE != 0 {
+			op = OpCreate
+		}
+		if err := fs.check(op, name, "open"); err != nil {
+			return nil, err
+		}
+	}
+	return fs.Filesystem.OpenFile(name, flag, perm)
+}
+
+// Remove implements vfs.Filesystem.
+// It requires OpDelete, for a directory also on every entry below it,
+// as some backends remove directories with their content.
+func (fs *FS) Remove(name string) error {
+	if err := fs.check(OpDelete, name, "remove"); err != nil {
+		return err
+	}
+	if fi, err := fs.Filesystem.Lstat(name); err == nil && fi.IsDir() {
+		if err := fs.checkRemove(name); err != nil {
+			return err
+		}
+	}
+	return fs.Filesystem.Remove(name)
+}
+
+// checkRemove checks the entries below the directory name for being removed.
+func (fs *FS) checkRemove(name string) error {
+	fis, err := fs.Filesystem.ReadDir(name)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		p := filepath.Join(name, fi.Name())
+		if err := fs.check(OpDelete, p, "remove"); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if err := fs.checkRemove(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Rename implements vfs.Filesystem.
+// It requires OpDelete on oldpath and OpCreate on newpath,
+// or OpWrite if newpath exists.
+// Renaming a directory additionally requires OpDelete on every entry below
+// oldpath and OpCreate on the path it is moved to.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	if err := fs.check(OpDelete, oldpath, "rename"); err != nil {
+		return err
+	}
+	op := OpCreate
+	if _, err := fs.Filesystem.Lstat(newpath); err == nil {
+		op = OpWrite
+	}
+	if err := fs.check(op, newpath, "rename"); err != nil {
+		return err
+	}
+	if fi, err := fs.Filesystem.Lstat(oldpath); err == nil && fi.IsDir() {
+		if err := fs.checkMove(oldpath, newpath); err != nil {
+			return err
+		}
+	}
+	return fs.Filesystem.Rename(oldpath, newpath)
+}
+
+// checkMove checks the entries below the directory oldpath for being moved below newpath.
+func (fs *FS) checkMove(oldpath, newpath string) error {
+	fis, err := fs.Filesystem.ReadDir(oldpath)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		oldp := filepath.Join(oldpath, fi.Name())
+		newp := filepath.Join(newpath, fi.Name())
+		if err := fs.check(OpDelete, oldp, "rename"); err != nil {
+			return err
+		}
+		if err := fs.check(OpCreate, newp, "rename"); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if err := fs.checkMove(oldp, newp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Mkdir implements vfs.Filesystem.
+// It requires OpCreate.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	if err := fs.check(OpCreate, name, "mkdir"); err != nil {
+		return err
+	}
+	return fs.Filesystem.Mkdir(name, perm)
+}
+
+// Stat implements vfs.Filesystem.
+// It requires name to be visible.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	if !fs.policy.Visible(fs.principal, name) {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrPermission}
+	}
+	return fs.Filesystem.Stat(name)
+}
+
+// Lstat implements vfs.Filesystem.
+// It requires name to be visible.
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	if !fs.policy.Visible(fs.principal, name) {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrPermission}
+	}
+	return fs.Filesystem.Lstat(name)
+}
+
+// ReadDir implements vfs.Filesystem.
+// It requires OpList, or a rule allowing access below path,
+// and only returns the visible entries.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	if !fs.policy.Allowed(fs.principal, OpList, path) && !fs.policy.below(fs.principal, filepath.Clean("/"+path)) {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: os.ErrPermission}
+	}
+	fis, err := fs.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	res := fis[:0]
+	for _, fi := range fis {
+		if fs.policy.Visible(fs.principal, filepath.Join("/", path, fi.Name())) {
+			res = append(res, fi)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,186 @@
+package aclfs
+
+import (
+	"encoding/json"
+	"fmt"
+	filepath "path"
+	"strings"
+
+	"github.com/alexsnet/vfs"
+	"github.com/alexsnet/vfs/internal/glob"
+	"gopkg.in/yaml.v3"
+)
+
+// Operations controlled by rules.
+const (
+	OpRead   = "read"
+	OpWrite  = "write"
+	OpCreate = "create"
+	OpDelete = "delete"
+	OpList   = "list"
+)
+
+// Effects of a rule.
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// Anyone matches every principal, including the anonymous one.
+const Anyone = "*"
+
+// Rule allows or denies operations on paths to principals.
+type Rule struct {
+	// Effect is either Allow or Deny.
+	Effect string `json:"effect" yaml:"effect"`
+	// Principals the rule applies to, Anyone matches all.
+	Principals []string `json:"principals" yaml:"principals"`
+	// Paths are slash separated glob patterns as of path.Match,
+	// a "**" segment matches any number of segments.
+	Paths []string `json:"paths" yaml:"paths"`
+	// Ops are the operations the rule applies to, all if empty.
+	Ops []string `json:"ops,omitempty" yaml:"ops,omitempty"`
+}
+
+// Policy is a list of rules.
+// A deny rule takes precedence over allow rules,
+// operations not allowed by any rule are denied.
+type Policy struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// LoadPolicy reads a policy file from fs.
+// Files ending in .yaml or .yml are parsed as YAML, all others as JSON.
+func LoadPolicy(fs vfs.Filesystem, name string) (*Policy, error) {
+	b, err := vfs.ReadFile(fs, name)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, p)
+	default:
+		err = json.Unmarshal(b, p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks the effects, operations and patterns of all rules.
+func (p *Policy) Validate() error {
+	for i, r := range p.Rules {
+		if r.Effect != Allow && r.Effect != Deny {
+			return fmt.Errorf("rule %d: invalid effect %q", i, r.Effect)
+		}
+		for _, op := range r.Ops {
+			switch op {
+			case OpRead, OpWrite, OpCreate, OpDelete, OpList:
+			default:
+				return fmt.Errorf("rule %d: invalid operation %q", i, op)
+			}
+		}
+		for _, pattern := range r.Paths {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rule %d: invalid path %q: %v", i, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Allowed reports whether principal may perform op on name.
+func (p *Policy) Allowed(principal, op, name string) bool {
+	name = filepath.Clean("/" + name)
+	allowed := false
+	for _, r := range p.Rules {
+		if !r.applies(principal, op) || !r.matches(name) {
+			continue
+		}
+		if r.Effect == Deny {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+// Visible reports whether principal may see name,
+// i.e. may perform any operation on it or on a path below it.
+// Names denied OpList are never visible.
+func (p *Policy) Visible(principal, name string) bool {
+	name = filepath.Clean("/" + name)
+	for _, r := range p.Rules {
+		if r.Effect == Deny && r.applies(principal, OpList) && r.matches(name) {
+			return false
+		}
+	}
+	for _, op := range []string{OpRead, OpWrite, OpDelete, OpList} {
+		if p.Allowed(principal, op, name) {
+			return true
+		}
+	}
+	return p.below(principal, name)
+}
+
+// below reports whether an allow rule for principal may match a path below name.
+func (p *Policy) below(principal, name string) bool {
+	for _, r := range p.Rules {
+		if r.Effect != Allow || !r.applies(principal, "") {
+			continue
+		}
+		for _, pattern := range r.Paths {
+			if glob.MatchPrefix(splitPattern(pattern), splitPattern(name)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applies reports whether the rule applies to principal and op.
+// An empty op matches every rule.
+func (r *Rule) applies(principal, op string) bool {
+	found := false
+	for _, p := range r.Principals {
+		if p == Anyone || p == principal {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	if op == "" || len(r.Ops) == 0 {
+		return true
+	}
+	for _, o := range r.Ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matches(name string) bool {
+	segs := splitPattern(name)
+	for _, pattern := range r.Paths {
+		if glob.Match(splitPattern(pattern), segs) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitPattern(p string) []string {
+	p = strings.Trim(filepath.Clean("/"+p), "/")
+	if p == "" {
+		return []string{}
+	}
+	return strings.Split(p, "/")
+}
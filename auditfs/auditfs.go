@@ -0,0 +1,267 @@
+// Package auditfs provides a vfs.Filesystem wrapper which writes a
+// tamper-evident audit log of all mutating operations.
+package auditfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alexsnet/vfs"
+)
+
+// Options of an auditing filesystem.
+type Options struct {
+	// Principal is recorded as the actor of all operations.
+	Principal string
+	// Reads enables recording of file reads and directory listings.
+	Reads bool
+	// Key makes the record hashes HMAC-SHA256 with this key,
+	// so the log can not be rewritten without it. See VerifyOptions.Key.
+	Key []byte
+}
+
+// chain holds the state of the hash chain, shared by all views of a FS.
+type chain struct {
+	mutex sync.Mutex
+	sink  Sink
+	key   []byte
+	seq   uint64
+	prev  string
+}
+
+// A FS that appends a Record to a Sink for every mutation.
+//
+// Records are hash chained: every record contains the hash of its predecessor,
+// so removed, reordered or modified records are detected by Verify.
+// Removing the last records is only detected against a head hash
+// saved from Head, the chain can only be rewritten without Options.Key.
+// Writes to a file are recorded once on Close with the number of bytes written.
+// If the sink fails, the operation returns the sink error.
+type FS struct {
+	vfs.Filesystem
+
+	opts  Options
+	chain *chain
+}
+
+// Create returns an auditing file system forwarding to root.
+// If sink implements Resumer, the hash chain continues after its last record,
+// which must match Options.Key.
+func Create(root vfs.Filesystem, sink Sink, opts Options) (*FS, error) {
+	c := &chain{sink: sink, key: opts.Key}
+	if r, ok := sink.(Resumer); ok {
+		last, err := r.Last()
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			if last.Hash != last.sum(c.key) {
+				return nil, fmt.Errorf("record %d: hash mismatch: %w", last.Seq, ErrChainBroken)
+			}
+			c.seq, c.prev = last.Seq, last.Hash
+		}
+	}
+	return &FS{Filesystem: root, opts: opts, chain: c}, nil
+}
+
+// As returns a file system sharing root and log recording principal as actor.
+func (fs *FS) As(principal string) *FS {
+	opts := fs.opts
+	opts.Principal = principal
+	return &FS{Filesystem: fs.Filesystem, opts: opts, chain: fs.chain}
+}
+
+// WithContext returns a file system recording the principal carried by ctx,
+// see vfs.WithPrincipal.
+func (fs *FS) WithContext(ctx context.Context) *FS {
+	p, _ := vfs.PrincipalFromContext(ctx)
+	return fs.As(p)
+}
+
+// Head returns the sequence number and hash of the last record.
+// Storing them apart from the log allows Verify to detect removed records at its end.
+func (fs *FS) Head() (uint64, string) {
+	c := fs.chain
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.seq, c.prev
+}
+
+// audit records the operation and returns err, or the sink error if err is nil.
+func (fs *FS) audit(op, path, newPath string, n int64, err error) error {
+	if err1 := fs.record(op, path, newPath, n, err); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// record appends a record and returns the sink error.
+func (fs *FS) record(op, path, newPath string, n int64, err error) error {
+	r := &Record{
+		Time:      time.Now().UTC(),
+		Principal: fs.opts.Principal,
+		Op:        op,
+		Path:      path,
+		NewPath:   newPath,
+		Bytes:     n,
+		Result:    ResultOK,
+	}
+	if err != nil {
+		r.Result = err.Error()
+	}
+
+	c := fs.chain
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	r.Seq = c.seq + 1
+	r.Prev = c.prev
+	r.Hash = r.sum(c.key)
+	if err := c.sink.Append(r); err != nil {
+		return err
+	}
+	c.seq, c.prev = r.Seq, r.Hash
+	return nil
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return fs.Filesystem.PathSeparator() }
+
+// OpenFile implements vfs.Filesystem.
+// Creating or truncating a file is recorded immediately,
+// writes and reads are recorded when the file is closed.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	writable := flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_TRUNC) != 0
+	op := ""
+	if flag&os.O_CREATE != 0 {
+		if _, err := fs.Filesystem.Lstat(name); os.IsNotExist(err) {
+			op = OpCreate
+		}
+	}
+	if op == "" && flag&os.O_TRUNC != 0 {
+		op = OpTruncate
+	}
+
+	f, err := fs.Filesystem.OpenFile(name, flag, perm)
+	if op != "" || (err != nil && writable) {
+		if op == "" {
+			op = OpWrite
+		}
+		if err := fs.audit(op, name, "", 0, err); err != nil {
+			if f != nil {
+				f.Close()
+			}
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !writable && !fs.opts.Reads {
+		return f, nil
+	}
+	return &file{File: f, fs: fs, writable: writable}, nil
+}
+
+// Remove implements vfs.Filesystem.
+func (fs *FS) Remove(name string) error {
+	return fs.audit(OpRemove, name, "", 0, fs.Filesystem.Remove(name))
+}
+
+// Rename implements vfs.Filesystem.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	return fs.audit(OpRename, oldpath, newpath, 0, fs.Filesystem.Rename(oldpath, newpath))
+}
+
+// Mkdir implements vfs.Filesystem.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	return fs.audit(OpMkdir, name, "", 0, fs.Filesystem.Mkdir(name, perm))
+}
+
+// ReadDir implements vfs.Filesystem.
+// It is recorded if Options.Reads is set.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	fis, err := fs.Filesystem.ReadDir(path)
+	if fs.opts.Reads {
+		if err := fs.audit(OpReadDir, path, "", 0, err); err != nil {
+			return nil, err
+		}
+	}
+	return fis, err
+}
+
+// file counts the transferred bytes and records them on Close.
+type file struct {
+	vfs.File
+	fs       *FS
+	writable bool
+
+	mutex   sync.Mutex
+	read    int64
+	written int64
+	err     error
+	closed  bool
+}
+
+func (f *file) count(read, written int, err error) {
+	f.mutex.Lock()
+	f.read += int64(read)
+	f.written += int64(written)
+	if f.err == nil && err != nil && read == 0 {
+		f.err = err
+	}
+	f.mutex.Unlock()
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.count(n, 0, nil)
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	f.count(n, 0, nil)
+	return n, err
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.count(0, n, err)
+	return n, err
+}
+
+// Truncate is recorded immediately.
+func (f *file) Truncate(size int64) error {
+	return f.fs.audit(OpTruncate, f.Name(), "", size, f.File.Truncate(size))
+}
+
+// Close records the bytes written, and read if Options.Reads is set.
+// The first write error or the close error is recorded as result.
+func (f *file) Close() error {
+	err := f.File.Close()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return err
+	}
+	f.closed = true
+
+	result := f.err
+	if result == nil {
+		result = err
+	}
+	if f.writable {
+		if err1 := f.fs.record(OpWrite, f.Name(), "", f.written, result); err == nil {
+			err = err1
+		}
+	}
+	if f.fs.opts.Reads && (f.read > 0 || !f.writable) {
+		if err1 := f.fs.record(OpRead, f.Name(), "", f.read, err); err == nil {
+			err = err1
+		}
+	}
+	return err
+}
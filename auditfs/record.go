@@ -0,0 +1,118 @@
+package auditfs
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Operations recorded in the audit log.
+const (
+	OpCreate   = "create"
+	OpWrite    = "write"
+	OpTruncate = "truncate"
+	OpRemove   = "remove"
+	OpRename   = "rename"
+	OpMkdir    = "mkdir"
+	OpRead     = "read"
+	OpReadDir  = "readdir"
+)
+
+// ResultOK is the result of a successful operation.
+const ResultOK = "ok"
+
+// ErrChainBroken is returned by Verify if a record is missing or has been modified.
+var ErrChainBroken = errors.New("Audit log hash chain is broken")
+
+// Record describes an audited operation.
+type Record struct {
+	// Seq numbers the records of a log, starting at 1.
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal,omitempty"`
+	Op        string    `json:"op"`
+	Path      string    `json:"path"`
+	NewPath   string    `json:"new_path,omitempty"`
+	// Bytes is the number of bytes written, read for OpRead or the new size for OpTruncate.
+	Bytes int64 `json:"bytes,omitempty"`
+	// Result is ResultOK or the error message.
+	Result string `json:"result"`
+	// Prev is the hash of the previous record, empty for the first one.
+	Prev string `json:"prev"`
+	// Hash is the SHA-256, or the HMAC-SHA256 with Options.Key, of the record with an empty Hash.
+	Hash string `json:"hash"`
+}
+
+// sum returns the hash of the record, which does not cover the Hash field.
+// It is keyed with key if not empty.
+func (r Record) sum(key []byte) string {
+	r.Hash = ""
+	b, _ := json.Marshal(&r)
+	if len(key) > 0 {
+		m := hmac.New(sha256.New, key)
+		m.Write(b)
+		return hex.EncodeToString(m.Sum(nil))
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// VerifyOptions of VerifyWith.
+type VerifyOptions struct {
+	// Key is the Options.Key the log has been written with.
+	Key []byte
+	// Head is a hash returned by FS.Head, which must be part of the log.
+	// Without it, records removed from the end of the log are not detected.
+	Head string
+}
+
+// Verify reads JSON lines records from r and checks the sequence numbers and the hash chain.
+// It returns the number of valid records and, if the chain is broken,
+// an error wrapping ErrChainBroken.
+func Verify(r io.Reader) (int, error) {
+	return VerifyWith(r, VerifyOptions{})
+}
+
+// VerifyWith is like Verify and also checks the key and head in opts.
+func VerifyWith(r io.Reader, opts VerifyOptions) (int, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var prev Record
+	n := 0
+	head := opts.Head == ""
+	for s.Scan() {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			return n, fmt.Errorf("record %d: %v", n+1, err)
+		}
+		switch {
+		case rec.Seq != prev.Seq+1:
+			return n, fmt.Errorf("record %d: sequence %d follows %d: %w", n+1, rec.Seq, prev.Seq, ErrChainBroken)
+		case rec.Prev != prev.Hash:
+			return n, fmt.Errorf("record %d: previous hash mismatch: %w", n+1, ErrChainBroken)
+		case rec.Hash != rec.sum(opts.Key):
+			return n, fmt.Errorf("record %d: hash mismatch: %w", n+1, ErrChainBroken)
+		}
+		if rec.Hash == opts.Head {
+			head = true
+		}
+		prev = rec
+		n++
+	}
+	if err := s.Err(); err != nil {
+		return n, err
+	}
+	if !head {
+		return n, fmt.Errorf("head %s not found: %w", opts.Head, ErrChainBroken)
+	}
+	return n, nil
+}
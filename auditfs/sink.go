@@ -0,0 +1,123 @@
+package auditfs
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/alexsnet/vfs"
+)
+
+// Sink receives the audit records in order.
+type Sink interface {
+	Append(r *Record) error
+}
+
+// Resumer is implemented by sinks which already hold records.
+// Last returns the last record, or nil if there are none,
+// so a new FS continues the hash chain.
+type Resumer interface {
+	Last() (*Record, error)
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(r *Record) error
+
+// Append implements Sink.
+func (f SinkFunc) Append(r *Record) error { return f(r) }
+
+// WriterSink writes records as JSON lines to an io.Writer.
+type WriterSink struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewWriterSink returns a sink writing to w.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// Append implements Sink.
+func (s *WriterSink) Append(r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.w.Write(append(b, '\n'))
+	return err
+}
+
+// FileSink appends records as JSON lines to a file of a vfs.Filesystem.
+// The file is only ever opened for appending.
+type FileSink struct {
+	fs   vfs.Filesystem
+	name string
+
+	mutex sync.Mutex
+	f     vfs.File
+}
+
+// NewFileSink returns a sink appending to name on fs.
+// The file is created if it does not exist.
+func NewFileSink(fs vfs.Filesystem, name string) (*FileSink, error) {
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{fs: fs, name: name, f: f}, nil
+}
+
+// Append implements Sink.
+// Every record is synced to the file.
+func (s *FileSink) Append(r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, err := s.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// Last implements Resumer.
+func (s *FileSink) Last() (*Record, error) {
+	f, err := s.fs.OpenFile(s.name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var last []byte
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if len(sc.Bytes()) > 0 {
+			last = append(last[:0], sc.Bytes()...)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, nil
+	}
+	r := &Record{}
+	if err := json.Unmarshal(last, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close closes the log file.
+func (s *FileSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,223 @@
+// Package casfs provides a content-addressable vfs.Filesystem which
+// deduplicates file contents.
+//
+// File contents are split into chunks which are stored once per SHA-256 hash
+// in a backing filesystem. Every file is a manifest listing its chunks.
+// Removing or overwriting files leaves chunks behind, which are removed by GC.
+package casfs
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	filepath "path"
+	"sync"
+	"time"
+
+	"github.com/alexsnet/vfs"
+)
+
+const (
+	chunkDir = "/chunks"
+	fileDir  = "/files"
+)
+
+// ErrNotSupported is returned if an existing file is opened for writing without truncating it.
+var ErrNotSupported = errors.New("Operation not supported on content-addressed file")
+
+// ChunkRef references a chunk of a file.
+type ChunkRef struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// Manifest describes the contents of a file.
+type Manifest struct {
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"modTime"`
+	Mode    os.FileMode `json:"mode"`
+	Chunks  []ChunkRef  `json:"chunks"`
+}
+
+// Options of a content-addressable filesystem.
+type Options struct {
+	// Chunker splits the contents, DefaultChunker is used if nil.
+	Chunker Chunker
+}
+
+// A FS that stores file contents as shared chunks in a backing filesystem.
+// Chunks are stored below /chunks and manifests below /files.
+type FS struct {
+	backing vfs.Filesystem
+	chunker Chunker
+
+	// gc is held shared while chunks and manifests are stored and exclusively by GC.
+	gc sync.RWMutex
+
+	mutex sync.Mutex
+	// live counts the references of open writers to chunks,
+	// which GC keeps although no manifest references them yet.
+	live map[string]int
+}
+
+// Create returns a content-addressable file system storing its data in backing.
+func Create(backing vfs.Filesystem, opts Options) (*FS, error) {
+	fs := &FS{backing: backing, chunker: opts.Chunker, live: map[string]int{}}
+	if fs.chunker == nil {
+		fs.chunker = DefaultChunker
+	}
+	for _, dir := range []string{chunkDir, fileDir} {
+		if err := vfs.MkdirAll(backing, dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// filePath returns the path of the manifest of name inside the backing filesystem.
+func filePath(name string) string {
+	return filepath.Join(fileDir, filepath.Clean("/"+name))
+}
+
+// chunkPath returns the path of the chunk with the given hex encoded hash.
+func chunkPath(hash string) string {
+	return filepath.Join(chunkDir, hash[:2], hash)
+}
+
+// Manifest returns the manifest of the named file.
+func (fs *FS) Manifest(name string) (*Manifest, error) {
+	b, err := vfs.ReadFile(fs.backing, filePath(name))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return m, nil
+}
+
+func (fs *FS) writeManifest(name string, m *Manifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return vfs.WriteFile(fs.backing, filePath(name), b, 0644)
+}
+
+// fileInfo describes a file by its manifest.
+type fileInfo struct {
+	name string
+	m    *Manifest
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.m.Size }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.m.Mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.m.ModTime }
+func (fi *fileInfo) IsDir() bool        { return false }
+func (fi *fileInfo) Sys() interface{}   { return fi.m }
+
+// dirInfo reports the name inside the FS for directories of the backing filesystem.
+type dirInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *dirInfo) Name() string { return fi.name }
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return '/' }
+
+// OpenFile implements vfs.Filesystem.
+// Files opened for writing must not exist or be truncated with os.O_TRUNC,
+// the contents are committed on Close.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	fi, err := fs.backing.Stat(filePath(name))
+	exists := err == nil
+	if exists && fi.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: vfs.ErrIsDirectory}
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND) == 0 {
+		if !exists {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		m, err := fs.Manifest(name)
+		if err != nil {
+			return nil, err
+		}
+		return newReader(fs, name, m), nil
+	}
+
+	switch {
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case exists && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case exists && flag&os.O_TRUNC == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrNotSupported}
+	}
+	if _, err := fs.backing.Stat(filepath.Dir(filePath(name))); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return newWriter(fs, name, perm), nil
+}
+
+// Remove implements vfs.Filesystem.
+// The chunks of a removed file are kept until GC.
+func (fs *FS) Remove(name string) error {
+	return fs.backing.Remove(filePath(name))
+}
+
+// Rename implements vfs.Filesystem.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	return fs.backing.Rename(filePath(oldpath), filePath(newpath))
+}
+
+// Mkdir implements vfs.Filesystem.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	return fs.backing.Mkdir(filePath(name), perm)
+}
+
+// Stat implements vfs.Filesystem.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	fi, err := fs.backing.Stat(filePath(name))
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &dirInfo{FileInfo: fi, name: filepath.Base(filepath.Clean("/" + name))}, nil
+	}
+	m, err := fs.Manifest(name)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: fi.Name(), m: m}, nil
+}
+
+// Lstat implements vfs.Filesystem.
+// Alias for fs.Stat(name)
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// ReadDir implements vfs.Filesystem.
+// The manifest of every file is read to report its size.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	fis, err := fs.backing.ReadDir(filePath(path))
+	if err != nil {
+		return nil, err
+	}
+	for i, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+		m, err := fs.Manifest(filepath.Join(path, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		fis[i] = &fileInfo{name: fi.Name(), m: m}
+	}
+	return fis, nil
+}
@@ -0,0 +1,88 @@
+package casfs
+
+// Chunker finds chunk boundaries in a stream of file contents.
+type Chunker interface {
+	// Next returns the length of the chunk starting at buf[0].
+	// It returns 0 if more data is needed to find a boundary and final is false.
+	// If final is true, buf holds the remaining contents of the file.
+	Next(buf []byte, final bool) int
+}
+
+// Fixed splits contents into chunks of a fixed size.
+type Fixed int
+
+// Next implements Chunker.
+func (c Fixed) Next(buf []byte, final bool) int {
+	if len(buf) >= int(c) {
+		return int(c)
+	}
+	if final {
+		return len(buf)
+	}
+	return 0
+}
+
+// ContentDefined splits contents at positions selected by a rolling gear hash,
+// so an insertion only changes the chunks around it.
+type ContentDefined struct {
+	// Min, Avg and Max are the minimum, average and maximum chunk sizes.
+	// Avg is rounded down to a power of two.
+	// Max defaults to 4*Avg, or DefaultChunker.Max if that is not above Min.
+	Min, Avg, Max int
+}
+
+// DefaultChunker is the content defined chunker used if Options.Chunker is nil.
+var DefaultChunker = ContentDefined{Min: 16 * 1024, Avg: 64 * 1024, Max: 256 * 1024}
+
+// gear holds pseudo-random values for the rolling hash, generated with splitmix64.
+var gear [256]uint64
+
+func init() {
+	x := uint64(0x9e3779b97f4a7c15)
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// Next implements Chunker.
+func (c ContentDefined) Next(buf []byte, final bool) int {
+	if len(buf) <= c.Min {
+		if final {
+			return len(buf)
+		}
+		return 0
+	}
+	var mask uint64 = 1
+	for mask<<1 <= uint64(c.Avg) {
+		mask <<= 1
+	}
+	mask--
+
+	max := c.Max
+	if max <= 0 {
+		// Chunks must be bounded, the writer buffers them completely.
+		max = 4 * c.Avg
+		if max <= c.Min {
+			max = c.Min + DefaultChunker.Max
+		}
+	}
+	end := len(buf)
+	if end > max {
+		end = max
+	}
+	var h uint64
+	for i := c.Min; i < end; i++ {
+		h = h<<1 + gear[buf[i]]
+		if h&mask == 0 {
+			return i + 1
+		}
+	}
+	if end == max || final {
+		return end
+	}
+	return 0
+}
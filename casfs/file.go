@@ -0,0 +1,290 @@
+package casfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	filepath "path"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/alexsnet/vfs"
+)
+
+// scanSize is the amount of buffered data after which chunk boundaries are searched.
+const scanSize = 1024 * 1024
+
+// ErrCorrupt is returned if a chunk does not match its hash.
+var ErrCorrupt = errors.New("Chunk does not match its hash")
+
+// writer splits the written contents into chunks and stores new ones.
+// The manifest is written on Close.
+type writer struct {
+	fs     *FS
+	name   string
+	buf    []byte
+	m      Manifest
+	err    error
+	closed bool
+}
+
+func newWriter(fs *FS, name string, perm os.FileMode) *writer {
+	return &writer{
+		fs:   fs,
+		name: name,
+		m:    Manifest{Mode: perm, Chunks: []ChunkRef{}},
+	}
+}
+
+// tmpSeq numbers the temporary files of chunks being stored.
+var tmpSeq uint64
+
+// store writes the chunk unless a chunk with the same hash exists.
+// The fs.gc read lock must be held.
+func (w *writer) store(chunk []byte) error {
+	sum := sha256.Sum256(chunk)
+	hash := hex.EncodeToString(sum[:])
+	w.m.Chunks = append(w.m.Chunks, ChunkRef{Hash: hash, Size: int64(len(chunk))})
+	w.m.Size += int64(len(chunk))
+	w.fs.mutex.Lock()
+	w.fs.live[hash]++
+	w.fs.mutex.Unlock()
+
+	p := chunkPath(hash)
+	if fi, err := w.fs.backing.Stat(p); err == nil {
+		if fi.Size() == int64(len(chunk)) {
+			return nil
+		}
+		// Left incomplete by an earlier version.
+		if err := w.fs.backing.Remove(p); err != nil {
+			return err
+		}
+	}
+	if err := vfs.MkdirAll(w.fs.backing, filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// Chunks are renamed into place once complete, so a crash can not leave
+	// a partial chunk behind which would be taken for the complete one.
+	tmp := fmt.Sprintf("%s.%d-%d.tmp", p, time.Now().UnixNano(), atomic.AddUint64(&tmpSeq, 1))
+	if err := vfs.WriteFile(w.fs.backing, tmp, chunk, 0644); err != nil {
+		w.fs.backing.Remove(tmp)
+		return err
+	}
+	if err := w.fs.backing.Rename(tmp, p); err != nil {
+		w.fs.backing.Remove(tmp)
+		// Stored by a concurrent writer.
+		if fi, err1 := w.fs.backing.Stat(p); err1 == nil && fi.Size() == int64(len(chunk)) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// release drops the references of the writer to its chunks.
+func (w *writer) release() {
+	w.fs.mutex.Lock()
+	defer w.fs.mutex.Unlock()
+	for _, c := range w.m.Chunks {
+		if w.fs.live[c.Hash]--; w.fs.live[c.Hash] <= 0 {
+			delete(w.fs.live, c.Hash)
+		}
+	}
+}
+
+// split stores all complete chunks of the buffer, or everything if final.
+func (w *writer) split(final bool) error {
+	for len(w.buf) > 0 {
+		n := w.fs.chunker.Next(w.buf, final)
+		if n <= 0 {
+			break
+		}
+		if err := w.store(w.buf[:n]); err != nil {
+			return err
+		}
+		w.buf = w.buf[n:]
+	}
+	if len(w.buf) == 0 {
+		w.buf = nil
+	}
+	return nil
+}
+
+func (w *writer) Name() string { return w.name }
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= scanSize {
+		w.fs.gc.RLock()
+		w.err = w.split(false)
+		w.fs.gc.RUnlock()
+		if w.err != nil {
+			return len(p), w.err
+		}
+	}
+	return len(p), nil
+}
+
+// Close stores the remaining chunks and commits the manifest.
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.release()
+	if w.err != nil {
+		return w.err
+	}
+
+	w.fs.gc.RLock()
+	defer w.fs.gc.RUnlock()
+	if err := w.split(true); err != nil {
+		return err
+	}
+	w.m.ModTime = time.Now()
+	return w.fs.writeManifest(w.name, &w.m)
+}
+
+func (w *writer) Stat() (os.FileInfo, error) {
+	m := w.m
+	m.Size += int64(len(w.buf))
+	return &fileInfo{name: filepath.Base(w.name), m: &m}, nil
+}
+
+func (w *writer) Sync() error { return nil }
+
+func (w *writer) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: w.name, Err: ErrNotSupported}
+}
+
+func (w *writer) ReadAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: w.name, Err: ErrNotSupported}
+}
+
+// Seek only reports the current offset, as files are written sequentially.
+func (w *writer) Seek(offset int64, whence int) (int64, error) {
+	size := w.m.Size + int64(len(w.buf))
+	if (whence == io.SeekCurrent && offset == 0) || (whence != io.SeekCurrent && offset == size) {
+		return size, nil
+	}
+	return 0, &os.PathError{Op: "seek", Path: w.name, Err: ErrNotSupported}
+}
+
+func (w *writer) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: w.name, Err: ErrNotSupported}
+}
+
+// reader reads the chunks of a manifest.
+type reader struct {
+	fs      *FS
+	name    string
+	m       *Manifest
+	offsets []int64
+	pos     int64
+
+	chunk    []byte
+	chunkIdx int
+}
+
+func newReader(fs *FS, name string, m *Manifest) *reader {
+	offsets := make([]int64, len(m.Chunks)+1)
+	for i, c := range m.Chunks {
+		offsets[i+1] = offsets[i] + c.Size
+	}
+	return &reader{fs: fs, name: name, m: m, offsets: offsets, chunkIdx: -1}
+}
+
+// load reads and verifies chunk idx.
+func (r *reader) load(idx int) error {
+	if r.chunkIdx == idx {
+		return nil
+	}
+	ref := r.m.Chunks[idx]
+	b, err := vfs.ReadFile(r.fs.backing, chunkPath(ref.Hash))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	if hex.EncodeToString(sum[:]) != ref.Hash {
+		return &os.PathError{Op: "read", Path: r.name, Err: ErrCorrupt}
+	}
+	r.chunk = b
+	r.chunkIdx = idx
+	return nil
+}
+
+func (r *reader) Name() string { return r.name }
+
+func (r *reader) Stat() (os.FileInfo, error) {
+	return &fileInfo{name: filepath.Base(r.name), m: r.m}, nil
+}
+
+func (r *reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("ReadAt: negative offset")
+	}
+	var n int
+	for n < len(p) {
+		if off >= r.m.Size {
+			return n, io.EOF
+		}
+		idx := sort.Search(len(r.m.Chunks), func(i int) bool { return r.offsets[i+1] > off })
+		if err := r.load(idx); err != nil {
+			return n, err
+		}
+		c := copy(p[n:], r.chunk[off-r.offsets[idx]:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.pos + offset
+	case io.SeekEnd:
+		abs = r.m.Size + offset
+	default:
+		return 0, errors.New("Seek: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("Seek: negative position")
+	}
+	r.pos = abs
+	return abs, nil
+}
+
+func (r *reader) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: r.name, Err: ErrNotSupported}
+}
+
+func (r *reader) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: r.name, Err: ErrNotSupported}
+}
+
+func (r *reader) Sync() error { return nil }
+
+func (r *reader) Close() error { return nil }
@@ -0,0 +1,78 @@
+package casfs
+
+import (
+	"os"
+	filepath "path"
+)
+
+// GCStats reports the result of a garbage collection.
+type GCStats struct {
+	// Referenced is the number of distinct chunks referenced by manifests.
+	Referenced int
+	// Removed is the number of removed chunks.
+	Removed int
+	// RemovedBytes is the size of the removed chunks.
+	RemovedBytes int64
+}
+
+// GC removes all chunks which are neither referenced by a manifest nor
+// by a file being written, as well as partially stored chunks left by crashes.
+// Writers storing chunks wait while it is running.
+func (fs *FS) GC() (GCStats, error) {
+	fs.gc.Lock()
+	defer fs.gc.Unlock()
+
+	var stats GCStats
+	refs := make(map[string]bool)
+	fs.mutex.Lock()
+	for hash := range fs.live {
+		refs[hash] = true
+	}
+	fs.mutex.Unlock()
+	if err := fs.walk(fileDir, func(p string, fi os.FileInfo) error {
+		m, err := fs.Manifest(p[len(fileDir):])
+		if err != nil {
+			return err
+		}
+		for _, c := range m.Chunks {
+			refs[c.Hash] = true
+		}
+		return nil
+	}); err != nil {
+		return stats, err
+	}
+	stats.Referenced = len(refs)
+
+	err := fs.walk(chunkDir, func(p string, fi os.FileInfo) error {
+		if refs[fi.Name()] {
+			return nil
+		}
+		if err := fs.backing.Remove(p); err != nil {
+			return err
+		}
+		stats.Removed++
+		stats.RemovedBytes += fi.Size()
+		return nil
+	})
+	return stats, err
+}
+
+// walk calls fn for every file below dir of the backing filesystem.
+func (fs *FS) walk(dir string, fn func(p string, fi os.FileInfo) error) error {
+	fis, err := fs.backing.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		p := filepath.Join(dir, fi.Name())
+		if fi.IsDir() {
+			err = fs.walk(p, fn)
+		} else {
+			err = fn(p, fi)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package compressfs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Codec compresses and decompresses single frames.
+type Codec interface {
+	// Name identifies the codec inside compressed files, at most 255 bytes.
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	codecsMutex sync.RWMutex
+	codecs      = map[string]Codec{}
+)
+
+// RegisterCodec makes a codec available for reading files compressed with it.
+func RegisterCodec(c Codec) {
+	codecsMutex.Lock()
+	codecs[c.Name()] = c
+	codecsMutex.Unlock()
+}
+
+func codec(name string) (Codec, error) {
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown codec %q", name)
+	}
+	return c, nil
+}
+
+// Gzip is a Codec using compress/gzip with the given compression level.
+type Gzip struct {
+	Level int
+}
+
+// Name implements Codec.
+func (Gzip) Name() string { return "gzip" }
+
+// NewWriter implements Codec.
+func (g Gzip) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	level := g.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// NewReader implements Codec.
+func (Gzip) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func init() {
+	RegisterCodec(Gzip{})
+}
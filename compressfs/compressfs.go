@@ -0,0 +1,253 @@
+// Package compressfs provides a vfs.Filesystem wrapper which transparently
+// compresses file contents.
+//
+// Files are compressed in independent frames with an index at the end,
+// so ReadAt and Seek only decompress the frames involved.
+// Compressed files are recognized by their header and footer,
+// so they stay readable when renamed.
+// Compressed files are written sequentially: they can only be created
+// or truncated when opened for writing, random writes are not supported.
+package compressfs
+
+import (
+	"os"
+	filepath "path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexsnet/vfs"
+	"github.com/alexsnet/vfs/s3fs"
+)
+
+// DefaultFrameSize is the default uncompressed size of a frame.
+const DefaultFrameSize = 256 * 1024
+
+// Options of a compressing filesystem.
+type Options struct {
+	// Codec compresses new files, Gzip is used if nil.
+	// Files are always read with the codec they were written with.
+	Codec Codec
+	// Extensions selects the files to compress when written by extension, e.g. ".log".
+	// All files are selected if empty.
+	// Files with already compressed content types are never compressed.
+	Extensions []string
+	// FrameSize is the uncompressed size of a frame, DefaultFrameSize is used if 0.
+	FrameSize int
+}
+
+// A FS that compresses the contents of selected files.
+type FS struct {
+	vfs.Filesystem
+
+	codec      Codec
+	extensions map[string]bool
+	frameSize  int
+
+	mutex sync.Mutex
+	// sizes caches the uncompressed sizes of files by path.
+	sizes map[string]sizeEntry
+}
+
+// sizeEntry is the uncompressed size of a file of innerSize bytes modified at modTime,
+// or -1 if the file is not compressed.
+type sizeEntry struct {
+	innerSize int64
+	modTime   time.Time
+	size      int64
+}
+
+// maxSizes bounds the number of cached sizes.
+const maxSizes = 4096
+
+// Create returns a compressing file system forwarding to root.
+func Create(root vfs.Filesystem, opts Options) *FS {
+	fs := &FS{
+		Filesystem: root,
+		codec:      opts.Codec,
+		frameSize:  opts.FrameSize,
+		sizes:      make(map[string]sizeEntry),
+	}
+	if fs.codec == nil {
+		fs.codec = Gzip{}
+	}
+	if fs.frameSize <= 0 {
+		fs.frameSize = DefaultFrameSize
+	}
+	if len(opts.Extensions) > 0 {
+		fs.extensions = make(map[string]bool, len(opts.Extensions))
+		for _, ext := range opts.Extensions {
+			fs.extensions[strings.ToLower(ext)] = true
+		}
+	}
+	return fs
+}
+
+// IsCompressedType reports whether files with the extension ext
+// usually contain already compressed data, e.g. archives, images or videos.
+// The content type is looked up in the MIME table of s3fs.
+func IsCompressedType(ext string) bool {
+	t := s3fs.TypeByExtension(ext)
+	switch {
+	case t == "":
+		return false
+	case strings.HasPrefix(t, "video/"), strings.HasPrefix(t, "audio/"):
+		return true
+	case strings.HasPrefix(t, "image/"):
+		return t != "image/bmp" && t != "image/svg+xml" && t != "image/x-portable-pixmap" && t != "image/tiff"
+	}
+	for _, s := range []string{"zip", "compressed", "gzip", "bzip", "rar", "xz", "lzma", "java-archive", "package-archive"} {
+		if strings.Contains(t, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Selected reports whether the named file is compressed when written.
+func (fs *FS) Selected(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if IsCompressedType(ext) {
+		return false
+	}
+	return fs.extensions == nil || fs.extensions[ext]
+}
+
+// fileInfo reports the uncompressed size.
+type fileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (fi *fileInfo) Size() int64 { return fi.size }
+
+// logical returns fi with the uncompressed size of the named file if it is compressed.
+func (fs *FS) logical(name string, fi os.FileInfo) os.FileInfo {
+	if fi.IsDir() || fi.Mode()&os.ModeSymlink != 0 {
+		return fi
+	}
+	if size := fs.size(name, fi); size >= 0 {
+		return &fileInfo{FileInfo: fi, size: size}
+	}
+	return fi
+}
+
+// size returns the uncompressed size of the named file,
+// or -1 if it is not compressed or its header or footer is invalid.
+// Sizes are cached until the size or modification time of the file changes.
+func (fs *FS) size(name string, fi os.FileInfo) int64 {
+	key := filepath.Clean("/" + name)
+	fs.mutex.Lock()
+	e, ok := fs.sizes[key]
+	fs.mutex.Unlock()
+	if ok && e.innerSize == fi.Size() && e.modTime.Equal(fi.ModTime()) {
+		return e.size
+	}
+
+	f, err := fs.Filesystem.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+	e = sizeEntry{innerSize: fi.Size(), modTime: fi.ModTime(), size: -1}
+	if ft, ok := readFooter(f, fi.Size()); ok {
+		// A plain file may start and end with the magic by chance.
+		if _, headerSize, err := readHeader(f); err == nil && ft.valid(fi.Size(), headerSize) {
+			e.size = ft.size
+		}
+	}
+	fs.mutex.Lock()
+	if len(fs.sizes) >= maxSizes {
+		fs.sizes = make(map[string]sizeEntry)
+	}
+	fs.sizes[key] = e
+	fs.mutex.Unlock()
+	return e.size
+}
+
+// forget drops the cached size of the named file.
+func (fs *FS) forget(name string) {
+	fs.mutex.Lock()
+	delete(fs.sizes, filepath.Clean("/"+name))
+	fs.mutex.Unlock()
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return fs.Filesystem.PathSeparator() }
+
+// OpenFile implements vfs.Filesystem.
+// Selected files are written compressed and must not exist or be truncated with os.O_TRUNC,
+// as must compressed files opened for writing.
+// Files which have not been written compressed are opened unmodified.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	fi, statErr := fs.Filesystem.Stat(name)
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND) != 0 {
+		selected := fs.Selected(name)
+		if statErr == nil && !fi.IsDir() && fi.Size() > 0 && flag&os.O_TRUNC != os.O_TRUNC &&
+			(selected || fs.size(name, fi) >= 0) {
+			return nil, &os.PathError{Op: "open", Path: name, Err: ErrNotSupported}
+		}
+		fs.forget(name)
+		if !selected {
+			return fs.Filesystem.OpenFile(name, flag, perm)
+		}
+		f, err := fs.Filesystem.OpenFile(name, flag&^os.O_APPEND|os.O_TRUNC, perm)
+		if err != nil {
+			return nil, err
+		}
+		w, err := newWriter(f, name, fs.codec, fs.frameSize)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return w, nil
+	}
+
+	f, err := fs.Filesystem.OpenFile(name, flag, perm)
+	if err != nil || statErr != nil || fi.IsDir() {
+		return f, err
+	}
+	r, ok, err := newReader(f, name, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if !ok {
+		return f, nil
+	}
+	return r, nil
+}
+
+// Stat implements vfs.Filesystem.
+// The size of compressed files is their uncompressed size.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	fi, err := fs.Filesystem.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.logical(name, fi), nil
+}
+
+// Lstat implements vfs.Filesystem.
+// The size of compressed files is their uncompressed size.
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	fi, err := fs.Filesystem.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.logical(name, fi), nil
+}
+
+// ReadDir implements vfs.Filesystem.
+// The size of compressed files is their uncompressed size,
+// which requires reading the footer of every file not cached yet.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	fis, err := fs.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, fi := range fis {
+		fis[i] = fs.logical(filepath.Join(path, fi.Name()), fi)
+	}
+	return fis, nil
+}
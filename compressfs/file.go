@@ -0,0 +1,394 @@
+package compressfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/alexsnet/vfs"
+)
+
+// File layout:
+//
+//	header: magic, version, codec name length, codec name
+//	frames: independently compressed frames of FrameSize uncompressed bytes
+//	index:  uint64 offset of every frame
+//	footer: uint64 size, uint32 frame size, uint32 frame count, uint64 index offset, magic
+const (
+	magic      = "VFSZ"
+	version    = 1
+	footerSize = 8 + 4 + 4 + 8 + len(magic)
+)
+
+var (
+	// ErrNotSupported is returned for random writes, which compressed files do not support.
+	ErrNotSupported = errors.New("Operation not supported on compressed file")
+	// ErrInvalidFormat is returned if a compressed file is corrupt.
+	ErrInvalidFormat = errors.New("Invalid compressed file")
+)
+
+type footer struct {
+	size        int64
+	frameSize   int64
+	frames      int
+	indexOffset int64
+}
+
+// readFooter reads the footer of a compressed file of innerSize bytes.
+// It returns false if the file has not been written by compressfs.
+func readFooter(f io.ReaderAt, innerSize int64) (footer, bool) {
+	if innerSize < int64(len(magic)+2+footerSize) {
+		return footer{}, false
+	}
+	b := make([]byte, footerSize)
+	if _, err := f.ReadAt(b, innerSize-int64(footerSize)); err != nil {
+		return footer{}, false
+	}
+	if string(b[footerSize-len(magic):]) != magic {
+		return footer{}, false
+	}
+	h := make([]byte, len(magic))
+	if _, err := f.ReadAt(h, 0); err != nil || string(h) != magic {
+		return footer{}, false
+	}
+	return footer{
+		size:        int64(binary.BigEndian.Uint64(b[0:8])),
+		frameSize:   int64(binary.BigEndian.Uint32(b[8:12])),
+		frames:      int(binary.BigEndian.Uint32(b[12:16])),
+		indexOffset: int64(binary.BigEndian.Uint64(b[16:24])),
+	}, true
+}
+
+// valid checks the footer of a file of innerSize bytes with a header of headerSize bytes.
+func (ft footer) valid(innerSize, headerSize int64) bool {
+	if ft.frameSize <= 0 || ft.size < 0 || ft.indexOffset < headerSize {
+		return false
+	}
+	if ft.indexOffset > innerSize || ft.indexOffset+int64(ft.frames)*8+int64(footerSize) != innerSize {
+		return false
+	}
+	if ft.size == 0 {
+		return ft.frames == 0
+	}
+	return int64(ft.frames) == (ft.size-1)/ft.frameSize+1
+}
+
+func writeHeader(w io.Writer, c Codec) error {
+	name := c.Name()
+	b := append([]byte(magic), version, byte(len(name)))
+	_, err := w.Write(append(b, name...))
+	return err
+}
+
+// readHeader returns the codec and the size of the header.
+func readHeader(f io.ReaderAt) (Codec, int64, error) {
+	b := make([]byte, len(magic)+2+255)
+	n, err := f.ReadAt(b, 0)
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	b = b[:n]
+	if len(b) < len(magic)+2 || string(b[:len(magic)]) != magic || b[len(magic)] != version {
+		return nil, 0, ErrInvalidFormat
+	}
+	l := len(magic) + 2 + int(b[len(magic)+1])
+	if len(b) < l {
+		return nil, 0, ErrInvalidFormat
+	}
+	c, err := codec(string(b[len(magic)+2 : l]))
+	return c, int64(l), err
+}
+
+// writer compresses frames sequentially and writes index and footer on Close.
+type writer struct {
+	inner     vfs.File
+	name      string
+	codec     Codec
+	frameSize int
+	buf       []byte
+	offsets   []uint64
+	offset    int64
+	size      int64
+	closed    bool
+}
+
+func newWriter(inner vfs.File, name string, c Codec, frameSize int) (*writer, error) {
+	w := &writer{inner: inner, name: name, codec: c, frameSize: frameSize}
+	if err := writeHeader(inner, c); err != nil {
+		return nil, err
+	}
+	w.offset = int64(len(magic) + 2 + len(c.Name()))
+	return w, nil
+}
+
+func (w *writer) Name() string { return w.name }
+
+func (w *writer) Stat() (os.FileInfo, error) {
+	fi, err := w.inner.Stat()
+	if err != nil || fi == nil {
+		return fi, err
+	}
+	return &fileInfo{FileInfo: fi, size: w.size}, nil
+}
+
+func (w *writer) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		c := w.frameSize - len(w.buf)
+		if c > len(p) {
+			c = len(p)
+		}
+		w.buf = append(w.buf, p[:c]...)
+		p = p[c:]
+		n += c
+		w.size += int64(c)
+		if len(w.buf) == w.frameSize {
+			if err = w.frame(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// frame compresses and writes the buffered frame.
+func (w *writer) frame() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var b bytes.Buffer
+	cw, err := w.codec.NewWriter(&b)
+	if err != nil {
+		return err
+	}
+	if _, err := cw.Write(w.buf); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	if _, err := w.inner.Write(b.Bytes()); err != nil {
+		return err
+	}
+	w.offsets = append(w.offsets, uint64(w.offset))
+	w.offset += int64(b.Len())
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *writer) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: w.name, Err: ErrNotSupported}
+}
+
+func (w *writer) ReadAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: w.name, Err: ErrNotSupported}
+}
+
+// Seek only reports the current offset, as compressed files are written sequentially.
+func (w *writer) Seek(offset int64, whence int) (int64, error) {
+	if (whence == io.SeekCurrent && offset == 0) || (whence != io.SeekCurrent && offset == w.size) {
+		return w.size, nil
+	}
+	return 0, &os.PathError{Op: "seek", Path: w.name, Err: ErrNotSupported}
+}
+
+func (w *writer) Truncate(size int64) error {
+	if size == w.size {
+		return nil
+	}
+	return &os.PathError{Op: "truncate", Path: w.name, Err: ErrNotSupported}
+}
+
+// Sync only syncs the frames written so far, the file is readable after Close.
+func (w *writer) Sync() error {
+	return w.inner.Sync()
+}
+
+// Close writes the last frame, the index and the footer and closes the file.
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	err := w.frame()
+	if err == nil {
+		b := make([]byte, 0, len(w.offsets)*8+footerSize)
+		for _, o := range w.offsets {
+			b = binary.BigEndian.AppendUint64(b, o)
+		}
+		b = binary.BigEndian.AppendUint64(b, uint64(w.size))
+		b = binary.BigEndian.AppendUint32(b, uint32(w.frameSize))
+		b = binary.BigEndian.AppendUint32(b, uint32(len(w.offsets)))
+		b = binary.BigEndian.AppendUint64(b, uint64(w.offset))
+		b = append(b, magic...)
+		_, err = w.inner.Write(b)
+	}
+	if err1 := w.inner.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// reader decompresses the frames needed for Read and ReadAt.
+type reader struct {
+	inner   vfs.File
+	name    string
+	codec   Codec
+	footer  footer
+	offsets []int64
+	pos     int64
+
+	frame    []byte
+	frameIdx int
+}
+
+// newReader returns false if inner has not been written by compressfs
+// and ErrInvalidFormat if it is corrupt.
+func newReader(inner vfs.File, name string, innerSize int64) (*reader, bool, error) {
+	ft, ok := readFooter(inner, innerSize)
+	if !ok {
+		return nil, false, nil
+	}
+	c, headerSize, err := readHeader(inner)
+	if err != nil {
+		return nil, true, err
+	}
+	if !ft.valid(innerSize, headerSize) {
+		return nil, true, ErrInvalidFormat
+	}
+	b := make([]byte, ft.frames*8)
+	if _, err := inner.ReadAt(b, ft.indexOffset); err != nil && err != io.EOF {
+		return nil, true, err
+	}
+	offsets := make([]int64, ft.frames+1)
+	prev := headerSize
+	for i := 0; i < ft.frames; i++ {
+		o := binary.BigEndian.Uint64(b[i*8:])
+		if o < uint64(prev) || o > uint64(ft.indexOffset) {
+			return nil, true, ErrInvalidFormat
+		}
+		offsets[i] = int64(o)
+		prev = offsets[i]
+	}
+	offsets[ft.frames] = ft.indexOffset
+	return &reader{
+		inner:    inner,
+		name:     name,
+		codec:    c,
+		footer:   ft,
+		offsets:  offsets,
+		frameIdx: -1,
+	}, true, nil
+}
+
+func (r *reader) Name() string { return r.name }
+
+func (r *reader) Stat() (os.FileInfo, error) {
+	fi, err := r.inner.Stat()
+	if err != nil || fi == nil {
+		return fi, err
+	}
+	return &fileInfo{FileInfo: fi, size: r.footer.size}, nil
+}
+
+// load decompresses frame idx into the cache.
+func (r *reader) load(idx int) error {
+	if r.frameIdx == idx {
+		return nil
+	}
+	if idx >= r.footer.frames {
+		return ErrInvalidFormat
+	}
+	b := make([]byte, r.offsets[idx+1]-r.offsets[idx])
+	if _, err := r.inner.ReadAt(b, r.offsets[idx]); err != nil && err != io.EOF {
+		return err
+	}
+	cr, err := r.codec.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer cr.Close()
+	buf := bytes.NewBuffer(r.frame[:0])
+	if _, err := buf.ReadFrom(io.LimitReader(cr, r.footer.frameSize+1)); err != nil {
+		return err
+	}
+	if int64(buf.Len()) > r.footer.frameSize {
+		return ErrInvalidFormat
+	}
+	r.frame = buf.Bytes()
+	r.frameIdx = idx
+	return nil
+}
+
+func (r *reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("ReadAt: negative offset")
+	}
+	var n int
+	for n < len(p) {
+		if off >= r.footer.size {
+			return n, io.EOF
+		}
+		idx := int(off / r.footer.frameSize)
+		if err := r.load(idx); err != nil {
+			return n, err
+		}
+		inOff := off - int64(idx)*r.footer.frameSize
+		if inOff >= int64(len(r.frame)) {
+			return n, ErrInvalidFormat
+		}
+		c := copy(p[n:], r.frame[inOff:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.pos + offset
+	case io.SeekEnd:
+		abs = r.footer.size + offset
+	default:
+		return 0, errors.New("Seek: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("Seek: negative position")
+	}
+	r.pos = abs
+	return abs, nil
+}
+
+func (r *reader) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: r.name, Err: ErrNotSupported}
+}
+
+func (r *reader) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: r.name, Err: ErrNotSupported}
+}
+
+func (r *reader) Sync() error {
+	return nil
+}
+
+func (r *reader) Close() error {
+	return r.inner.Close()
+}
@@ -0,0 +1,17 @@
+package vfs
+
+import "context"
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the principal on whose behalf
+// file systems act, e.g. the authenticated user of a server.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal carried by ctx.
+func PrincipalFromContext(ctx context.Context) (string, bool) {
+	p, ok := ctx.Value(principalKey{}).(string)
+	return p, ok
+}
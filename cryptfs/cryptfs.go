@@ -0,0 +1,232 @@
+// Package cryptfs provides a vfs.Filesystem wrapper which transparently
+// encrypts file contents and optionally file and directory names.
+//
+// Contents are split into chunks of ChunkSize bytes, each sealed with AES-GCM
+// and a random nonce, so ReadAt and Seek only decrypt the chunks involved.
+// Every file starts with a header holding the id of the key it was encrypted with.
+package cryptfs
+
+import (
+	"os"
+	filepath "path"
+
+	"github.com/alexsnet/vfs"
+)
+
+// Options of an encrypted filesystem.
+type Options struct {
+	// Keys provides the keys for file contents.
+	Keys KeyProvider
+	// NameKey enables deterministic encryption of file and directory names if set.
+	// The key must not change during the lifetime of the encrypted tree.
+	NameKey []byte
+}
+
+// A FS that encrypts the contents of every file written through it.
+type FS struct {
+	vfs.Filesystem
+
+	keys  KeyProvider
+	names *nameCipher
+}
+
+// Create returns an encrypting file system forwarding to root.
+func Create(root vfs.Filesystem, opts Options) (*FS, error) {
+	fs := &FS{Filesystem: root, keys: opts.Keys}
+	if len(opts.NameKey) > 0 {
+		names, err := newNameCipher(opts.NameKey)
+		if err != nil {
+			return nil, err
+		}
+		fs.names = names
+	}
+	return fs, nil
+}
+
+// EncryptPath returns the path inside the wrapped filesystem.
+// It returns ErrNameTooLong if an encrypted name exceeds 255 bytes.
+func (fs *FS) EncryptPath(name string) (string, error) {
+	if fs.names == nil {
+		return name, nil
+	}
+	return fs.names.path(name, string(fs.PathSeparator()))
+}
+
+// encryptPath is EncryptPath returning a *os.PathError for op.
+func (fs *FS) encryptPath(op, name string) (string, error) {
+	p, err := fs.EncryptPath(name)
+	if err != nil {
+		return "", &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return p, nil
+}
+
+// fileInfo reports the plaintext name and size.
+type fileInfo struct {
+	os.FileInfo
+	name string
+	size int64
+}
+
+func (fi *fileInfo) Name() string { return fi.name }
+func (fi *fileInfo) Size() int64  { return fi.size }
+
+func (fs *FS) fileInfo(fi os.FileInfo, name string) os.FileInfo {
+	size := fi.Size()
+	if !fi.IsDir() {
+		size = plainSize(size)
+	}
+	return &fileInfo{FileInfo: fi, name: name, size: size}
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return fs.Filesystem.PathSeparator() }
+
+// OpenFile implements vfs.Filesystem.
+// Files are always opened readable in the wrapped filesystem,
+// as partial writes need to read and decrypt the surrounding chunk.
+// Directories can not be opened.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	inner, err := fs.encryptPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	if fi, err := fs.Filesystem.Stat(inner); err == nil {
+		if fi.IsDir() {
+			return nil, &os.PathError{Op: "open", Path: name, Err: vfs.ErrIsDirectory}
+		}
+		if flag&os.O_TRUNC != os.O_TRUNC {
+			size = fi.Size()
+		}
+	}
+
+	innerFlag := flag &^ os.O_APPEND
+	if innerFlag&os.O_WRONLY == os.O_WRONLY {
+		innerFlag = innerFlag&^os.O_WRONLY | os.O_RDWR
+	}
+	f, err := fs.Filesystem.OpenFile(inner, innerFlag, perm)
+	if err != nil {
+		return nil, err
+	}
+	cf, err := newFile(fs, f, name, flag, size)
+	if err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return cf, nil
+}
+
+// Remove implements vfs.Filesystem.
+func (fs *FS) Remove(name string) error {
+	inner, err := fs.encryptPath("remove", name)
+	if err != nil {
+		return err
+	}
+	return fs.Filesystem.Remove(inner)
+}
+
+// Rename implements vfs.Filesystem.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	oldInner, err := fs.encryptPath("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	newInner, err := fs.encryptPath("rename", newpath)
+	if err != nil {
+		return err
+	}
+	return fs.Filesystem.Rename(oldInner, newInner)
+}
+
+// Mkdir implements vfs.Filesystem.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	inner, err := fs.encryptPath("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return fs.Filesystem.Mkdir(inner, perm)
+}
+
+// Stat implements vfs.Filesystem.
+// The size of files is their plaintext size.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	inner, err := fs.encryptPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fs.Filesystem.Stat(inner)
+	if err != nil {
+		return nil, err
+	}
+	return fs.fileInfo(fi, fs.baseName(name, fi)), nil
+}
+
+// Lstat implements vfs.Filesystem.
+// The size of files is their plaintext size.
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	inner, err := fs.encryptPath("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fs.Filesystem.Lstat(inner)
+	if err != nil {
+		return nil, err
+	}
+	return fs.fileInfo(fi, fs.baseName(name, fi)), nil
+}
+
+// baseName returns the plaintext name for the FileInfo of the encrypted path of name.
+func (fs *FS) baseName(name string, fi os.FileInfo) string {
+	if fs.names == nil {
+		return fi.Name()
+	}
+	if base := filepath.Base(name); base != "/" && base != "." {
+		return base
+	}
+	return fi.Name()
+}
+
+// ReadDir implements vfs.Filesystem.
+// Names are decrypted and sizes are plaintext sizes.
+// Entries with names which can not be decrypted are skipped.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	inner, err := fs.encryptPath("readdir", path)
+	if err != nil {
+		return nil, err
+	}
+	fis, err := fs.Filesystem.ReadDir(inner)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]os.FileInfo, 0, len(fis))
+	for _, fi := range fis {
+		name := fi.Name()
+		if fs.names != nil {
+			var ok bool
+			if name, ok = fs.names.decrypt(name); !ok {
+				continue
+			}
+		}
+		res = append(res, fs.fileInfo(fi, name))
+	}
+	return res, nil
+}
+
+// Rekey re-encrypts the file with the current key of the KeyProvider.
+// The whole file is held in memory and rewritten in place.
+func (fs *FS) Rekey(name string) error {
+	data, err := vfs.ReadFile(fs, name)
+	if err != nil {
+		return err
+	}
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
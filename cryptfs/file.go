@@ -0,0 +1,369 @@
+package cryptfs
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/alexsnet/vfs"
+)
+
+// file decrypts and encrypts chunks of the wrapped file on demand.
+// The chunk under operation is cached in plaintext and written back
+// when another chunk is accessed or on Sync and Close.
+type file struct {
+	fs     *FS
+	inner  vfs.File
+	name   string
+	flag   int
+	header *header
+	cipher *chunkCipher
+
+	// headerWritten is false for new files until the first chunk is written.
+	headerWritten bool
+	// innerPos is the offset of the wrapped file, to avoid seeking on sequential writes.
+	innerPos int64
+	// size is the plaintext size including the cached chunk.
+	size int64
+	pos  int64
+	// growTo is the size the file grows to during a write, chunks before it are not the last one.
+	growTo int64
+	// lastSealed is the index of the chunk stored as last chunk, -1 if none.
+	lastSealed int64
+
+	chunk      []byte
+	chunkIdx   int64
+	chunkDirty bool
+}
+
+func newFile(fs *FS, inner vfs.File, name string, flag int, innerSize int64) (*file, error) {
+	f := &file{
+		fs:         fs,
+		inner:      inner,
+		name:       name,
+		flag:       flag,
+		chunkIdx:   -1,
+		lastSealed: -1,
+	}
+
+	if innerSize == 0 {
+		id, key, err := fs.keys.CurrentKey()
+		if err != nil {
+			return nil, err
+		}
+		if f.header, err = newHeader(id); err != nil {
+			return nil, err
+		}
+		if f.cipher, err = newChunkCipher(key, f.header); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+
+	b := make([]byte, headerSize)
+	if _, err := inner.ReadAt(b, 0); err != nil {
+		if err == io.EOF {
+			err = ErrInvalidHeader
+		}
+		return nil, err
+	}
+	f.header = &header{}
+	if err := f.header.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	key, err := fs.keys.Key(f.header.keyID)
+	if err != nil {
+		return nil, err
+	}
+	if f.cipher, err = newChunkCipher(key, f.header); err != nil {
+		return nil, err
+	}
+	f.headerWritten = true
+	f.innerPos = -1
+	f.size = plainSize(innerSize)
+	if f.size == 0 {
+		// Files are never stored with a header only.
+		return nil, io.ErrUnexpectedEOF
+	}
+	f.lastSealed = (f.size - 1) / ChunkSize
+	return f, nil
+}
+
+func (f *file) writable() bool {
+	return f.flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	if err := f.flush(); err != nil {
+		return nil, err
+	}
+	return f.fs.Stat(f.name)
+}
+
+// writeInner writes b at off into the wrapped file, seeking only if necessary.
+func (f *file) writeInner(b []byte, off int64) error {
+	if f.innerPos != off {
+		if _, err := f.inner.Seek(off, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	n, err := f.inner.Write(b)
+	f.innerPos = off + int64(n)
+	if err != nil {
+		f.innerPos = -1
+	}
+	return err
+}
+
+// flush encrypts and writes the cached chunk if it was modified.
+func (f *file) flush() error {
+	if !f.chunkDirty {
+		return nil
+	}
+	if !f.headerWritten {
+		b, _ := f.header.MarshalBinary()
+		if err := f.writeInner(b, 0); err != nil {
+			return err
+		}
+		f.headerWritten = true
+	}
+	end := f.size
+	if f.growTo > end {
+		end = f.growTo
+	}
+	last := (f.chunkIdx+1)*ChunkSize >= end
+	sealed, err := f.cipher.seal(f.chunkIdx, last, f.chunk)
+	if err != nil {
+		return err
+	}
+	if err := f.writeInner(sealed, int64(headerSize)+f.chunkIdx*storedSize); err != nil {
+		return err
+	}
+	f.chunkDirty = false
+	if last {
+		f.lastSealed = f.chunkIdx
+	} else if f.lastSealed == f.chunkIdx {
+		f.lastSealed = -1
+	}
+	return nil
+}
+
+// load makes chunk idx the cached chunk.
+// Chunks beyond the end of the file are empty.
+func (f *file) load(idx int64) error {
+	if f.chunkIdx == idx {
+		return nil
+	}
+	if err := f.flush(); err != nil {
+		return err
+	}
+	f.chunkIdx = idx
+	f.chunk = f.chunk[:0]
+	if idx*ChunkSize >= f.size {
+		return nil
+	}
+
+	sealed := make([]byte, storedSize)
+	n, err := f.inner.ReadAt(sealed, int64(headerSize)+idx*storedSize)
+	if err != nil && err != io.EOF {
+		f.chunkIdx = -1
+		return err
+	}
+	plain, err := f.cipher.open(idx, idx == f.lastSealed, sealed[:n])
+	if err != nil {
+		f.chunkIdx = -1
+		return err
+	}
+	f.chunk = append(f.chunk, plain...)
+	return nil
+}
+
+func (f *file) readAt(p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		if off >= f.size {
+			return n, io.EOF
+		}
+		idx := off / ChunkSize
+		if err := f.load(idx); err != nil {
+			return n, err
+		}
+		c := copy(p[n:], f.chunk[off-idx*ChunkSize:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+func (f *file) writeAt(p []byte, off int64) (int, error) {
+	if !f.writable() {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+	if end := off + int64(len(p)); end > f.size {
+		f.growTo = end
+		defer func() { f.growTo = 0 }()
+		// The stored last chunk has to be sealed again as a middle chunk.
+		if f.lastSealed >= 0 && end > (f.lastSealed+1)*ChunkSize {
+			if err := f.load(f.lastSealed); err != nil {
+				return 0, err
+			}
+			f.chunkDirty = true
+		}
+	}
+	// Fill a hole with zeros.
+	for f.size < off {
+		gap := off - f.size
+		if gap > ChunkSize {
+			gap = ChunkSize
+		}
+		if _, err := f.write(make([]byte, gap), f.size); err != nil {
+			return 0, err
+		}
+	}
+	return f.write(p, off)
+}
+
+// write copies p into the chunks from off, which must not be beyond the end of the file.
+func (f *file) write(p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		idx := off / ChunkSize
+		if err := f.load(idx); err != nil {
+			return n, err
+		}
+		inOff := int(off - idx*ChunkSize)
+		end := inOff + len(p) - n
+		if end > ChunkSize {
+			end = ChunkSize
+		}
+		if end > len(f.chunk) {
+			f.chunk = append(f.chunk, make([]byte, end-len(f.chunk))...)
+		}
+		c := copy(f.chunk[inOff:end], p[n:])
+		f.chunkDirty = true
+		n += c
+		off += int64(c)
+		if off > f.size {
+			f.size = off
+		}
+	}
+	return n, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := f.readAt(p, f.pos)
+	f.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("ReadAt: negative offset")
+	}
+	return f.readAt(p, off)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.flag&os.O_APPEND == os.O_APPEND {
+		f.pos = f.size
+	}
+	n, err := f.writeAt(p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+// Seek allows seeking beyond the end of the file, a following write fills the hole with zeros.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.pos + offset
+	case io.SeekEnd:
+		abs = f.size + offset
+	default:
+		return 0, errors.New("Seek: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("Seek: negative position")
+	}
+	f.pos = abs
+	return abs, nil
+}
+
+func (f *file) Truncate(size int64) error {
+	if !f.writable() {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: os.ErrPermission}
+	}
+	if size < 0 {
+		return errors.New("Truncate: size must be non-negative")
+	}
+	if size >= f.size {
+		_, err := f.writeAt(nil, size)
+		return err
+	}
+
+	idx := size / ChunkSize
+	rem := size - idx*ChunkSize
+	if rem == 0 && idx > 0 {
+		// The new last chunk has to be sealed again as last chunk.
+		idx--
+		rem = ChunkSize
+	}
+	if rem > 0 {
+		if err := f.load(idx); err != nil {
+			return err
+		}
+		f.chunk = f.chunk[:rem]
+		f.chunkDirty = true
+	} else if f.chunkIdx >= idx {
+		f.chunkIdx = -1
+		f.chunkDirty = false
+	}
+	f.size = size
+	if err := f.flush(); err != nil {
+		return err
+	}
+
+	innerSize := int64(headerSize) + idx*storedSize
+	if rem > 0 {
+		innerSize += rem + overhead
+	}
+	if size == 0 {
+		innerSize = 0
+		f.headerWritten = false
+		f.lastSealed = -1
+	}
+	if err := f.inner.Truncate(innerSize); err != nil {
+		return err
+	}
+	f.innerPos = -1
+	f.size = size
+	return nil
+}
+
+func (f *file) Sync() error {
+	if err := f.flush(); err != nil {
+		return err
+	}
+	return f.inner.Sync()
+}
+
+func (f *file) Close() error {
+	err := f.flush()
+	if err1 := f.inner.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
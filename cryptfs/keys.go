@@ -0,0 +1,173 @@
+package cryptfs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ChunkSize is the size of the plaintext chunks encrypted separately.
+const ChunkSize = 64 * 1024
+
+const (
+	magic      = "VFSC"
+	version    = 2
+	maxKeyID   = 32
+	fileIDSize = 16
+	// headerSize is magic, version, chunk size, key id length, key id and file id.
+	headerSize = len(magic) + 1 + 4 + 1 + maxKeyID + fileIDSize
+	nonceSize  = 12
+	tagSize    = 16
+	overhead   = nonceSize + tagSize
+	storedSize = ChunkSize + overhead
+)
+
+var (
+	// ErrInvalidHeader is returned if a file has not been encrypted by cryptfs.
+	ErrInvalidHeader = errors.New("Invalid encryption header")
+	// ErrUnknownKey is returned if a KeyProvider has no key for an id.
+	ErrUnknownKey = errors.New("Unknown key")
+)
+
+// KeyProvider provides the AES keys (16, 24 or 32 bytes) of a cryptfs.FS.
+// New files are encrypted with the current key, the key id is stored in every
+// file, so older keys stay usable for reading after a rotation.
+type KeyProvider interface {
+	// CurrentKey returns the id and the key used to encrypt new files.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given id.
+	Key(id string) ([]byte, error)
+}
+
+// Keyring is a KeyProvider with static keys.
+// Rotate keys by adding a new key and changing Current.
+type Keyring struct {
+	// Current is the id of the key used for new files.
+	Current string
+	// Keys maps ids to keys.
+	Keys map[string][]byte
+}
+
+// CurrentKey implements KeyProvider.
+func (k *Keyring) CurrentKey() (string, []byte, error) {
+	key, err := k.Key(k.Current)
+	return k.Current, key, err
+}
+
+// Key implements KeyProvider.
+func (k *Keyring) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// header is stored at the start of every encrypted file.
+type header struct {
+	keyID  string
+	fileID [fileIDSize]byte
+}
+
+func newHeader(keyID string) (*header, error) {
+	if len(keyID) > maxKeyID {
+		return nil, fmt.Errorf("Key id %q longer than %d bytes", keyID, maxKeyID)
+	}
+	h := &header{keyID: keyID}
+	if _, err := io.ReadFull(rand.Reader, h.fileID[:]); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *header) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, headerSize)
+	b = append(b, magic...)
+	b = append(b, version)
+	b = binary.BigEndian.AppendUint32(b, ChunkSize)
+	b = append(b, byte(len(h.keyID)))
+	id := make([]byte, maxKeyID)
+	copy(id, h.keyID)
+	b = append(b, id...)
+	b = append(b, h.fileID[:]...)
+	return b, nil
+}
+
+func (h *header) UnmarshalBinary(b []byte) error {
+	if len(b) != headerSize || !bytes.HasPrefix(b, []byte(magic)) {
+		return ErrInvalidHeader
+	}
+	b = b[len(magic):]
+	if b[0] != version || binary.BigEndian.Uint32(b[1:5]) != ChunkSize {
+		return ErrInvalidHeader
+	}
+	l := int(b[5])
+	if l > maxKeyID {
+		return ErrInvalidHeader
+	}
+	h.keyID = string(b[6 : 6+l])
+	copy(h.fileID[:], b[6+maxKeyID:])
+	return nil
+}
+
+// plainSize returns the plaintext size of an encrypted file of size n.
+func plainSize(n int64) int64 {
+	n -= int64(headerSize)
+	if n <= 0 {
+		return 0
+	}
+	size := n / storedSize * ChunkSize
+	if rem := n % storedSize; rem > overhead {
+		size += rem - overhead
+	}
+	return size
+}
+
+// chunkCipher seals and opens the chunks of one file.
+type chunkCipher struct {
+	aead   cipher.AEAD
+	fileID [fileIDSize]byte
+}
+
+func newChunkCipher(key []byte, h *header) (*chunkCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkCipher{aead: aead, fileID: h.fileID}, nil
+}
+
+// ad binds a chunk to its file and position.
+// The last chunk is marked, so truncating a file at a chunk boundary is detected.
+func (c *chunkCipher) ad(idx int64, last bool) []byte {
+	b := binary.BigEndian.AppendUint64(c.fileID[:len(c.fileID):len(c.fileID)], uint64(idx))
+	if last {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// seal encrypts a chunk with a random nonce, which is prepended.
+func (c *chunkCipher) seal(idx int64, last bool, plain []byte) ([]byte, error) {
+	out := make([]byte, nonceSize, nonceSize+len(plain)+tagSize)
+	if _, err := io.ReadFull(rand.Reader, out); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(out, out, plain, c.ad(idx, last)), nil
+}
+
+func (c *chunkCipher) open(idx int64, last bool, sealed []byte) ([]byte, error) {
+	if len(sealed) < overhead {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], c.ad(idx, last))
+}
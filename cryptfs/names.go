@@ -0,0 +1,78 @@
+package cryptfs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"syscall"
+)
+
+// maxNameLen is the maximum length of an encrypted name, the limit of most file systems.
+const maxNameLen = 255
+
+// ErrNameTooLong is returned if the encrypted name of a path segment exceeds 255 bytes.
+// It is syscall.ENAMETOOLONG, so callers can handle it like any long name.
+var ErrNameTooLong error = syscall.ENAMETOOLONG
+
+// nameCipher encrypts path segments deterministically.
+// The nonce is a MAC of the plaintext (synthetic IV),
+// so equal names encrypt to equal ciphertexts and decryption is authenticated.
+type nameCipher struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+func newNameCipher(key []byte) (*nameCipher, error) {
+	block, err := aes.NewCipher(derive(key, "name-enc"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &nameCipher{aead: aead, macKey: derive(key, "name-iv")}, nil
+}
+
+func derive(key []byte, label string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(label))
+	return h.Sum(nil)
+}
+
+func (c *nameCipher) encrypt(name string) string {
+	h := hmac.New(sha256.New, c.macKey)
+	h.Write([]byte(name))
+	nonce := h.Sum(nil)[:nonceSize]
+	sealed := c.aead.Seal(nonce[:nonceSize:nonceSize], nonce, []byte(name), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+func (c *nameCipher) decrypt(name string) (string, bool) {
+	sealed, err := base64.RawURLEncoding.DecodeString(name)
+	if err != nil || len(sealed) < overhead {
+		return "", false
+	}
+	plain, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", false
+	}
+	return string(plain), true
+}
+
+// path encrypts every segment of a path separated by sep.
+func (c *nameCipher) path(p string, sep string) (string, error) {
+	segs := strings.Split(p, sep)
+	for i, s := range segs {
+		if s == "" || s == "." || s == ".." {
+			continue
+		}
+		if segs[i] = c.encrypt(s); len(segs[i]) > maxNameLen {
+			return "", ErrNameTooLong
+		}
+	}
+	return strings.Join(segs, sep), nil
+}
@@ -0,0 +1,130 @@
+package davfs
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// digest holds the challenge of a server using digest authentication (RFC 7616).
+type digest struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	nc        int
+}
+
+// parseChallenge parses the parameters of a WWW-Authenticate header
+// of the given scheme, or returns false if it uses another scheme.
+func parseChallenge(header, scheme string) (map[string]string, bool) {
+	if len(header) < len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) {
+		return nil, false
+	}
+	params := map[string]string{}
+	s := strings.TrimSpace(header[len(scheme):])
+	for s != "" {
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimSpace(s[i+1:])
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			// Quoted strings may contain commas and escaped quotes.
+			var b strings.Builder
+			j := 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			value = b.String()
+			if j < len(s) {
+				j++
+			}
+			s = s[j:]
+		} else {
+			j := strings.IndexByte(s, ',')
+			if j < 0 {
+				j = len(s)
+			}
+			value = strings.TrimSpace(s[:j])
+			s = s[j:]
+		}
+		params[key] = value
+		s = strings.TrimLeft(s, ", ")
+	}
+	return params, true
+}
+
+func newDigest(header string) (*digest, bool) {
+	params, ok := parseChallenge(header, "Digest")
+	if !ok || params["nonce"] == "" {
+		return nil, false
+	}
+	d := &digest{
+		realm:     params["realm"],
+		nonce:     params["nonce"],
+		opaque:    params["opaque"],
+		algorithm: params["algorithm"],
+	}
+	for _, qop := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(qop) == "auth" {
+			d.qop = "auth"
+		}
+	}
+	switch strings.ToUpper(d.algorithm) {
+	case "", "MD5", "MD5-SESS":
+		return d, true
+	default:
+		// SHA-256 and others are not supported.
+		return nil, false
+	}
+}
+
+func md5hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// authorize sets the Authorization header of req, the caller must serialize calls.
+func (d *digest) authorize(req *http.Request, username, password string) {
+	d.nc++
+	b := make([]byte, 8)
+	rand.Read(b)
+	cnonce := hex.EncodeToString(b)
+	nc := fmt.Sprintf("%08x", d.nc)
+	uri := req.URL.RequestURI()
+
+	ha1 := md5hex(username + ":" + d.realm + ":" + password)
+	if strings.EqualFold(d.algorithm, "MD5-sess") {
+		ha1 = md5hex(ha1 + ":" + d.nonce + ":" + cnonce)
+	}
+	ha2 := md5hex(req.Method + ":" + uri)
+	var response string
+	if d.qop != "" {
+		response = md5hex(strings.Join([]string{ha1, d.nonce, nc, cnonce, d.qop, ha2}, ":"))
+	} else {
+		response = md5hex(ha1 + ":" + d.nonce + ":" + ha2)
+	}
+
+	auth := fmt.Sprintf(`Digest username=%q, realm=%q, nonce=%q, uri=%q, response=%q`,
+		username, d.realm, d.nonce, uri, response)
+	if d.algorithm != "" {
+		auth += ", algorithm=" + d.algorithm
+	}
+	if d.qop != "" {
+		auth += fmt.Sprintf(`, qop=%s, nc=%s, cnonce=%q`, d.qop, nc, cnonce)
+	}
+	if d.opaque != "" {
+		auth += fmt.Sprintf(`, opaque=%q`, d.opaque)
+	}
+	req.Header.Set("Authorization", auth)
+}
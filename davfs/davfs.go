@@ -0,0 +1,506 @@
+// Package davfs provides a vfs.Filesystem backed by a WebDAV server.
+package davfs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	filepath "path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/alexsnet/vfs"
+)
+
+// ErrNotEmpty is returned by Remove for directories which are not empty,
+// as WebDAV deletes collections recursively.
+var ErrNotEmpty error = syscall.ENOTEMPTY
+
+// Options configure a FS.
+type Options struct {
+	// Username and Password authenticate with basic or digest authentication,
+	// whichever the server asks for.
+	Username string
+	Password string
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// A FS accessing the files of a WebDAV server.
+//
+// Stat and ReadDir use PROPFIND, reads are ranged GET requests supporting
+// ReadAt and Seek. Files opened for writing are buffered in memory and
+// uploaded with PUT on Sync and Close.
+// Mkdir, Rename and Remove map to MKCOL, MOVE and DELETE.
+type FS struct {
+	base *url.URL
+	opts Options
+
+	mutex sync.Mutex
+	// basic is set once the server asked for basic authentication.
+	basic  bool
+	digest *digest
+}
+
+// Create returns a FS for the collection at endpoint, e.g. "https://example.com/dav/".
+func Create(endpoint string, opts Options) (*FS, error) {
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("davfs: unsupported scheme %q", base.Scheme)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	base.RawPath = ""
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	return &FS{base: base, opts: opts}, nil
+}
+
+// httpError is returned for unexpected responses of the server.
+type httpError struct {
+	code   int
+	status string
+}
+
+func (e *httpError) Error() string {
+	return "WebDAV request failed: " + e.status
+}
+
+// StatusCode returns the HTTP status code of the response.
+func (e *httpError) StatusCode() int {
+	return e.code
+}
+
+// statusError returns a *os.PathError describing the unexpected response resp.
+func statusError(op, name string, resp *http.Response) error {
+	var err error
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusConflict:
+		// 409 Conflict is returned if a parent collection is missing.
+		err = os.ErrNotExist
+	case http.StatusUnauthorized, http.StatusForbidden:
+		err = os.ErrPermission
+	case http.StatusMethodNotAllowed, http.StatusPreconditionFailed:
+		err = os.ErrExist
+	default:
+		err = &httpError{code: resp.StatusCode, status: resp.Status}
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+func clean(name string) string {
+	return filepath.Clean("/" + name)
+}
+
+// url returns the URL of name, with a trailing slash for collections.
+func (fs *FS) url(name string, collection bool) string {
+	u := *fs.base
+	u.Path = fs.base.Path + clean(name)
+	if collection && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+// name returns the path of an href of a response, relative to the endpoint.
+func (fs *FS) name(href string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+	p := clean(u.Path)
+	if fs.base.Path != "" {
+		if p != fs.base.Path && !strings.HasPrefix(p, fs.base.Path+"/") {
+			return "", fmt.Errorf("davfs: href %q outside of %q", href, fs.base.Path)
+		}
+		p = clean(strings.TrimPrefix(p, fs.base.Path))
+	}
+	return p, nil
+}
+
+func (fs *FS) newRequest(method, name string, collection bool, body []byte) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	return http.NewRequest(method, fs.url(name, collection), r)
+}
+
+// authorize adds the credentials for the scheme the server asked for.
+func (fs *FS) authorize(req *http.Request) {
+	if fs.opts.Username == "" && fs.opts.Password == "" {
+		return
+	}
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	switch {
+	case fs.digest != nil:
+		fs.digest.authorize(req, fs.opts.Username, fs.opts.Password)
+	case fs.basic:
+		req.SetBasicAuth(fs.opts.Username, fs.opts.Password)
+	}
+}
+
+// challenge records the authentication scheme of a 401 response,
+// it returns false if the request should not be repeated.
+func (fs *FS) challenge(resp *http.Response) bool {
+	if fs.opts.Username == "" && fs.opts.Password == "" {
+		return false
+	}
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	for _, header := range resp.Header.Values("WWW-Authenticate") {
+		if d, ok := newDigest(header); ok {
+			// A new nonce, either the first one or a stale one.
+			fs.digest = d
+			return true
+		}
+	}
+	for _, header := range resp.Header.Values("WWW-Authenticate") {
+		if _, ok := parseChallenge(header, "Basic"); ok && !fs.basic && fs.digest == nil {
+			fs.basic = true
+			return true
+		}
+	}
+	return false
+}
+
+// do sends req, answering an authentication challenge once.
+func (fs *FS) do(req *http.Request) (*http.Response, error) {
+	fs.authorize(req)
+	resp, err := fs.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) || !fs.challenge(resp) {
+		return resp, nil
+	}
+	resp.Body.Close()
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
+	fs.authorize(req)
+	return fs.opts.Client.Do(req)
+}
+
+// Props are the WebDAV properties of a file, returned by FileInfo.Sys.
+type Props struct {
+	// Href is the URL path of the file on the server.
+	Href string
+	// ETag including its quotes.
+	ETag        string
+	ContentType string
+}
+
+// FileInfo describes a file of a WebDAV server.
+type FileInfo struct {
+	name    string
+	size    int64
+	dir     bool
+	modTime time.Time
+	props   *Props
+}
+
+func (fi *FileInfo) Name() string { return fi.name }
+func (fi *FileInfo) Size() int64  { return fi.size }
+func (fi *FileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return 0755 | os.ModeDir
+	}
+	return 0644
+}
+func (fi *FileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *FileInfo) IsDir() bool        { return fi.dir }
+func (fi *FileInfo) Sys() interface{}   { return fi.props }
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop>
+<D:resourcetype/><D:getcontentlength/><D:getlastmodified/><D:getetag/><D:getcontenttype/>
+</D:prop></D:propfind>`
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ContentLength string `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+				ETag          string `xml:"DAV: getetag"`
+				ContentType   string `xml:"DAV: getcontenttype"`
+			} `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// propfind returns the paths and infos of name and, with depth 1, its members.
+func (fs *FS) propfind(op, name string, depth int) ([]string, []*FileInfo, error) {
+	req, err := fs.newRequest("PROPFIND", name, depth > 0, []byte(propfindBody))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Depth", strconv.Itoa(depth))
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	resp, err := fs.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, nil, statusError(op, name, resp)
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	paths := make([]string, 0, len(ms.Responses))
+	fis := make([]*FileInfo, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		p, err := fs.name(r.Href)
+		if err != nil {
+			return nil, nil, &os.PathError{Op: op, Path: name, Err: err}
+		}
+		fi := &FileInfo{name: filepath.Base(p), props: &Props{Href: r.Href}}
+		if p == "/" {
+			fi.name = "/"
+		}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			prop := ps.Prop
+			fi.dir = fi.dir || prop.ResourceType.Collection != nil
+			if prop.ContentLength != "" {
+				fi.size, _ = strconv.ParseInt(prop.ContentLength, 10, 64)
+			}
+			if prop.LastModified != "" {
+				fi.modTime, _ = http.ParseTime(prop.LastModified)
+			}
+			if prop.ETag != "" {
+				fi.props.ETag = prop.ETag
+			}
+			if prop.ContentType != "" {
+				fi.props.ContentType = prop.ContentType
+			}
+		}
+		if fi.dir {
+			fi.size = 0
+		}
+		paths = append(paths, p)
+		fis = append(fis, fi)
+	}
+	return paths, fis, nil
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return '/' }
+
+// OpenFile implements vfs.Filesystem.
+// Files opened for writing are uploaded on Sync and Close, existing content
+// is downloaded first unless os.O_TRUNC is set.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	p := clean(name)
+	fi, err := fs.Stat(p)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if exists && fi.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: vfs.ErrIsDirectory}
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		if !exists {
+			return nil, err
+		}
+		return &reader{fs: fs, name: p, size: fi.Size()}, nil
+	}
+
+	if !exists && flag&os.O_CREATE == 0 {
+		return nil, err
+	}
+	if exists && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+	w := &writer{fs: fs, name: p, flag: flag, dirty: !exists || flag&os.O_TRUNC != 0}
+	if exists && flag&os.O_TRUNC == 0 {
+		if w.data, err = fs.download(p); err != nil {
+			return nil, err
+		}
+	}
+	w.init()
+	return w, nil
+}
+
+// download reads the content of name.
+func (fs *FS) download(name string) ([]byte, error) {
+	req, err := fs.newRequest("GET", name, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fs.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError("open", name, resp)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// upload replaces the content of name with data.
+func (fs *FS) upload(name string, data []byte) error {
+	req, err := fs.newRequest("PUT", name, false, data)
+	if err != nil {
+		return err
+	}
+	resp, err := fs.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	default:
+		return statusError("write", name, resp)
+	}
+}
+
+// Remove implements vfs.Filesystem.
+// Directories must be empty.
+func (fs *FS) Remove(name string) error {
+	p := clean(name)
+	if p == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	fi, err := fs.Stat(p)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		fis, err := fs.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		if len(fis) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: ErrNotEmpty}
+		}
+	}
+	req, err := fs.newRequest("DELETE", p, fi.IsDir(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := fs.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusAccepted:
+		return nil
+	default:
+		return statusError("remove", name, resp)
+	}
+}
+
+// Rename implements vfs.Filesystem.
+// Existing files at newpath are replaced, directories are not,
+// as WebDAV would delete them with their content.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	if fi, err := fs.Stat(newpath); err == nil && fi.IsDir() {
+		return &os.PathError{Op: "rename", Path: newpath, Err: vfs.ErrIsDirectory}
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	req, err := fs.newRequest("MOVE", oldpath, false, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Destination", fs.url(newpath, false))
+	req.Header.Set("Overwrite", "T")
+	resp, err := fs.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusNoContent:
+		return nil
+	default:
+		return statusError("rename", oldpath, resp)
+	}
+}
+
+// Mkdir implements vfs.Filesystem.
+// The permissions are ignored.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	req, err := fs.newRequest("MKCOL", name, true, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := fs.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return statusError("mkdir", name, resp)
+	}
+	return nil
+}
+
+// Stat implements vfs.Filesystem.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	_, fis, err := fs.propfind("stat", name, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(fis) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return fis[0], nil
+}
+
+// Lstat implements vfs.Filesystem.
+// WebDAV has no symlinks, it is the same as Stat.
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// ReadDir implements vfs.Filesystem.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	p := clean(path)
+	paths, fis, err := fs.propfind("readdir", p, 1)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(fis))
+	for i, fi := range fis {
+		if paths[i] != p {
+			infos = append(infos, fi)
+		} else if !fi.IsDir() {
+			return nil, &os.PathError{Op: "readdir", Path: path, Err: vfs.ErrNotDirectory}
+		}
+	}
+	return infos, nil
+}
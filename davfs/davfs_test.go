@@ -0,0 +1,253 @@
+package davfs
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alexsnet/vfs"
+	dav "golang.org/x/net/webdav"
+)
+
+// recorder records the requests passed to the WebDAV handler.
+type recorder struct {
+	mutex  sync.Mutex
+	ranges []string
+	// noRange strips Range headers, like servers ignoring them.
+	noRange bool
+}
+
+func (rec *recorder) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			rec.mutex.Lock()
+			rec.ranges = append(rec.ranges, r.Header.Get("Range"))
+			rec.mutex.Unlock()
+			if rec.noRange {
+				r.Header.Del("Range")
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func newHandler() http.Handler {
+	return &dav.Handler{FileSystem: dav.NewMemFS(), LockSystem: dav.NewMemLS()}
+}
+
+func newFS(t *testing.T, h http.Handler, opts Options) *FS {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	fs, err := Create(srv.URL+"/", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestReadWrite(t *testing.T) {
+	rec := &recorder{}
+	fs := newFS(t, rec.wrap(newHandler()), Options{})
+	data := []byte(strings.Repeat("0123456789", 1000))
+	if err := vfs.WriteFile(fs, "/f", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.OpenFile("/f", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	p := make([]byte, 5)
+	if n, err := f.ReadAt(p, 1003); n != 5 || err != nil || string(p) != "34567" {
+		t.Fatalf("ReadAt = %d, %v, %q", n, err, p)
+	}
+	if n, err := f.ReadAt(p, 9998); n != 2 || err != io.EOF || string(p[:n]) != "89" {
+		t.Fatalf("ReadAt at end = %d, %v, %q", n, err, p[:n])
+	}
+	if pos, err := f.Seek(-4, io.SeekEnd); pos != 9996 || err != nil {
+		t.Fatalf("Seek = %d, %v", pos, err)
+	}
+	rest, err := ioutil.ReadAll(f)
+	if err != nil || string(rest) != "6789" {
+		t.Fatalf("ReadAll = %q, %v", rest, err)
+	}
+	for _, want := range []string{"bytes=1003-1007", "bytes=9998-10002", "bytes=9996-"} {
+		found := false
+		for _, r := range rec.ranges {
+			found = found || r == want
+		}
+		if !found {
+			t.Errorf("no request with Range %q in %q", want, rec.ranges)
+		}
+	}
+}
+
+func TestIgnoredRange(t *testing.T) {
+	rec := &recorder{noRange: true}
+	fs := newFS(t, rec.wrap(newHandler()), Options{})
+	if err := vfs.WriteFile(fs, "/f", []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.OpenFile("/f", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p := make([]byte, 3)
+	if n, err := f.ReadAt(p, 4); n != 3 || err != nil || string(p) != "456" {
+		t.Fatalf("ReadAt = %d, %v, %q", n, err, p)
+	}
+	f.Seek(8, io.SeekStart)
+	rest, err := ioutil.ReadAll(f)
+	if err != nil || string(rest) != "89" {
+		t.Fatalf("ReadAll = %q, %v", rest, err)
+	}
+}
+
+func basicAuth(h http.Handler, user, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != user || p != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// digestAuth verifies RFC 7616 digest authentication with qop=auth.
+func digestAuth(h http.Handler, user, password string, challenges *int) http.Handler {
+	const realm, nonce, opaque = "test", "n0nce", "0paque"
+	var mutex sync.Mutex
+	lastNC := ""
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params, ok := parseChallenge(r.Header.Get("Authorization"), "Digest")
+		valid := ok && params["username"] == user && params["nonce"] == nonce &&
+			params["opaque"] == opaque && params["uri"] == r.URL.RequestURI()
+		if valid {
+			ha1 := md5hex(user + ":" + realm + ":" + password)
+			ha2 := md5hex(r.Method + ":" + params["uri"])
+			want := md5hex(strings.Join([]string{ha1, nonce, params["nc"], params["cnonce"], "auth", ha2}, ":"))
+			mutex.Lock()
+			// The nonce count must increase.
+			valid = params["response"] == want && params["nc"] > lastNC
+			if valid {
+				lastNC = params["nc"]
+			}
+			mutex.Unlock()
+		}
+		if !valid {
+			mutex.Lock()
+			*challenges++
+			mutex.Unlock()
+			w.Header().Add("WWW-Authenticate", `Basic realm="test"`)
+			w.Header().Add("WWW-Authenticate", `Digest realm="test", qop="auth,auth-int", nonce="n0nce", opaque="0paque"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func TestBasicAuth(t *testing.T) {
+	h := basicAuth(newHandler(), "user", "secret")
+	fs := newFS(t, h, Options{Username: "user", Password: "secret"})
+	if err := vfs.WriteFile(fs, "/f", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := vfs.ReadFile(fs, "/f"); err != nil || string(data) != "data" {
+		t.Fatalf("ReadFile = %q, %v", data, err)
+	}
+
+	wrong := newFS(t, h, Options{Username: "user", Password: "wrong"})
+	if _, err := wrong.Stat("/f"); !os.IsPermission(err) {
+		t.Fatalf("Stat with wrong password = %v", err)
+	}
+	anonymous := newFS(t, h, Options{})
+	if _, err := anonymous.Stat("/f"); !os.IsPermission(err) {
+		t.Fatalf("Stat without credentials = %v", err)
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	challenges := 0
+	fs := newFS(t, digestAuth(newHandler(), "user", "secret", &challenges), Options{Username: "user", Password: "secret"})
+	if err := fs.Mkdir("/d", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := vfs.WriteFile(fs, "/d/f", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := vfs.ReadFile(fs, "/d/f"); err != nil || string(data) != "data" {
+		t.Fatalf("ReadFile = %q, %v", data, err)
+	}
+	// Digest is preferred over basic and the nonce is reused.
+	if challenges != 1 {
+		t.Fatalf("%d challenges, want 1", challenges)
+	}
+
+	wrong := newFS(t, digestAuth(newHandler(), "user", "secret", new(int)), Options{Username: "user", Password: "wrong"})
+	if _, err := wrong.Stat("/"); !os.IsPermission(err) {
+		t.Fatalf("Stat with wrong password = %v", err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	fs := newFS(t, newHandler(), Options{})
+	if err := vfs.MkdirAll(fs, "/d/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/d"); !errors.Is(err, ErrNotEmpty) {
+		t.Fatalf("Remove of non-empty directory = %v", err)
+	}
+	if _, err := fs.Stat("/d/sub"); err != nil {
+		t.Fatalf("content removed: %v", err)
+	}
+	if err := fs.Remove("/d/sub"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/d"); !os.IsNotExist(err) {
+		t.Fatalf("Remove of missing directory = %v", err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	fs := newFS(t, newHandler(), Options{})
+	if err := vfs.MkdirAll(fs, "/d", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := vfs.WriteFile(fs, "/d/keep", []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := vfs.WriteFile(fs, "/a", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := vfs.WriteFile(fs, "/b", []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/a", "/d"); !errors.Is(err, vfs.ErrIsDirectory) {
+		t.Fatalf("Rename onto directory = %v", err)
+	}
+	if _, err := fs.Stat("/d/keep"); err != nil {
+		t.Fatalf("directory destroyed: %v", err)
+	}
+	if err := fs.Rename("/a", "/b"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := vfs.ReadFile(fs, "/b"); err != nil || string(data) != "a" {
+		t.Fatalf("ReadFile = %q, %v", data, err)
+	}
+	if _, err := fs.Stat("/a"); !os.IsNotExist(err) {
+		t.Fatalf("source still exists: %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package davfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/alexsnet/vfs/memfs"
+)
+
+// reader reads a file with ranged GET requests.
+// Seeking drops the current response, the next Read requests the file
+// again from the new position.
+type reader struct {
+	fs   *FS
+	name string
+	size int64
+
+	mutex  sync.Mutex
+	body   io.ReadCloser
+	pos    int64
+	closed bool
+}
+
+// get requests the file from off up to and including end, or up to the end
+// of the file if end is negative. The mutex must be held.
+// It returns io.EOF if off is beyond the end of the file.
+func (r *reader) get(off, end int64) (io.ReadCloser, error) {
+	req, err := r.fs.newRequest("GET", r.name, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	if end >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end))
+	} else if off > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	}
+	resp, err := r.fs.do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		// The range has been ignored, skip to off.
+		if off > 0 {
+			if _, err := io.CopyN(ioutil.Discard, resp.Body, off); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+		return resp.Body, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return nil, io.EOF
+	default:
+		defer resp.Body.Close()
+		return nil, statusError("read", r.name, resp)
+	}
+}
+
+func (r *reader) Name() string { return r.name }
+
+func (r *reader) Stat() (os.FileInfo, error) { return r.fs.Stat(r.name) }
+
+func (r *reader) Read(b []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return 0, &os.PathError{Op: "read", Path: r.name, Err: os.ErrClosed}
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if r.body == nil {
+		if r.pos >= r.size {
+			return 0, io.EOF
+		}
+		body, err := r.get(r.pos, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(b)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *reader) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("ReadAt: negative offset")
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return 0, &os.PathError{Op: "read", Path: r.name, Err: os.ErrClosed}
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	body, err := r.get(off, off+int64(len(b))-1)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.pos + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, errors.New("Seek: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("Seek: negative position")
+	}
+	if abs != r.pos && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.pos = abs
+	return abs, nil
+}
+
+func (r *reader) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: r.name, Err: syscall.EBADF}
+}
+
+func (r *reader) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: r.name, Err: syscall.EBADF}
+}
+
+func (r *reader) Sync() error { return nil }
+
+func (r *reader) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closed = true
+	if r.body != nil {
+		err := r.body.Close()
+		r.body = nil
+		return err
+	}
+	return nil
+}
+
+// writer buffers a file opened for writing in memory.
+type writer struct {
+	fs   *FS
+	name string
+	flag int
+
+	mutex  sync.Mutex
+	data   []byte
+	buf    *memfs.Buf
+	dirty  bool
+	closed bool
+}
+
+func (w *writer) init() {
+	w.buf = memfs.NewBuffer(&w.data)
+	if w.flag&os.O_APPEND != 0 {
+		w.buf.Seek(0, io.SeekEnd)
+	}
+}
+
+func (w *writer) Name() string { return w.name }
+
+// Stat describes the file on the server, which does not include unsynced writes.
+func (w *writer) Stat() (os.FileInfo, error) { return w.fs.Stat(w.name) }
+
+// check returns an error if the file is closed or op is not permitted by its flags.
+func (w *writer) check(op string, read bool) error {
+	if w.closed {
+		return &os.PathError{Op: op, Path: w.name, Err: os.ErrClosed}
+	}
+	if read && w.flag&os.O_RDWR == 0 {
+		return &os.PathError{Op: op, Path: w.name, Err: syscall.EBADF}
+	}
+	return nil
+}
+
+func (w *writer) Read(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := w.check("read", true); err != nil {
+		return 0, err
+	}
+	return w.buf.Read(p)
+}
+
+func (w *writer) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("ReadAt: negative offset")
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := w.check("read", true); err != nil {
+		return 0, err
+	}
+	return w.buf.ReadAt(p, off)
+}
+
+func (w *writer) Seek(offset int64, whence int) (int64, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := w.check("seek", false); err != nil {
+		return 0, err
+	}
+	return w.buf.Seek(offset, whence)
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := w.check("write", false); err != nil {
+		return 0, err
+	}
+	if w.flag&os.O_APPEND != 0 {
+		w.buf.Seek(0, io.SeekEnd)
+	}
+	w.dirty = true
+	return w.buf.Write(p)
+}
+
+func (w *writer) Truncate(size int64) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := w.check("truncate", false); err != nil {
+		return err
+	}
+	w.dirty = true
+	return w.buf.Truncate(size)
+}
+
+// sync uploads the content if it changed, the mutex must be held.
+func (w *writer) sync() error {
+	if !w.dirty {
+		return nil
+	}
+	if err := w.fs.upload(w.name, w.data); err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+// Sync uploads the content if it changed.
+func (w *writer) Sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := w.check("sync", false); err != nil {
+		return err
+	}
+	return w.sync()
+}
+
+// Close uploads the content if it changed.
+func (w *writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.sync()
+}
@@ -0,0 +1,325 @@
+// Package faultfs provides a vfs.Filesystem wrapper which injects errors,
+// latency and short reads or writes for resilience testing.
+package faultfs
+
+import (
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alexsnet/vfs"
+)
+
+// A FS that injects faults into vfs.Filesystem and vfs.File operations.
+// Rules are evaluated in order, the first triggered rule wins.
+// The random triggers use a seeded source, so a sequence of calls
+// fails the same way on every run.
+type FS struct {
+	vfs.Filesystem
+
+	mutex    sync.Mutex
+	rand     *rand.Rand
+	rules    []*ruleState
+	injected int
+}
+
+// Create returns a file system forwarding to root which injects faults according to rules.
+// The seed initializes the random source of probabilistic rules.
+func Create(root vfs.Filesystem, seed int64, rules ...Rule) *FS {
+	fs := &FS{
+		Filesystem: root,
+		rand:       rand.New(rand.NewSource(seed)),
+	}
+	for _, r := range rules {
+		fs.AddRule(r)
+	}
+	return fs
+}
+
+// AddRule appends a rule.
+func (fs *FS) AddRule(r Rule) {
+	fs.mutex.Lock()
+	fs.rules = append(fs.rules, &ruleState{Rule: r})
+	fs.mutex.Unlock()
+}
+
+// Reset removes all rules and resets the random source with seed.
+func (fs *FS) Reset(seed int64) {
+	fs.mutex.Lock()
+	fs.rules = nil
+	fs.injected = 0
+	fs.rand = rand.New(rand.NewSource(seed))
+	fs.mutex.Unlock()
+}
+
+// Injected returns the number of injected faults.
+func (fs *FS) Injected() int {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.injected
+}
+
+// fault returns the fault for the operation or nil.
+// The latency of the fault is already applied.
+func (fs *FS) fault(op, path string) *Fault {
+	fs.mutex.Lock()
+	var fault *Fault
+	for _, r := range fs.rules {
+		if !r.matches(op, path) {
+			continue
+		}
+		if r.Limit > 0 && r.triggered >= r.Limit {
+			continue
+		}
+		r.calls++
+		trigger := true
+		if r.Nth > 0 {
+			trigger = r.calls%r.Nth == 0
+		}
+		if trigger && r.Probability > 0 {
+			trigger = fs.rand.Float64() < r.Probability
+		}
+		if trigger {
+			r.triggered++
+			fs.injected++
+			fault = &r.Fault
+			break
+		}
+	}
+	fs.mutex.Unlock()
+
+	if fault != nil && fault.Latency > 0 {
+		time.Sleep(fault.Latency)
+	}
+	return fault
+}
+
+// err returns the error of the fault as *os.PathError.
+func (f *Fault) err(op, path string) error {
+	err := f.Err
+	if err == nil {
+		if f.Short > 0 || f.Latency > 0 {
+			return nil
+		}
+		err = ErrInjected
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+// short returns the length of a short transfer of n bytes.
+func (f *Fault) short(n int) int {
+	if f.Short <= 0 || f.Short >= 1 {
+		return n
+	}
+	s := int(float64(n) * f.Short)
+	if s == 0 && n > 0 {
+		s = 1
+	}
+	return s
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return fs.Filesystem.PathSeparator() }
+
+// OpenFile implements vfs.Filesystem.
+// Faults are injected into the returned file as well.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	if f := fs.fault(OpOpen, name); f != nil {
+		if err := f.err(OpOpen, name); err != nil {
+			return nil, err
+		}
+	}
+	file, err := fs.Filesystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: file, fs: fs, name: name}, nil
+}
+
+// Remove implements vfs.Filesystem.
+func (fs *FS) Remove(name string) error {
+	if f := fs.fault(OpRemove, name); f != nil {
+		if err := f.err(OpRemove, name); err != nil {
+			return err
+		}
+	}
+	return fs.Filesystem.Remove(name)
+}
+
+// Rename implements vfs.Filesystem.
+// Rules are matched against oldpath.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	if f := fs.fault(OpRename, oldpath); f != nil {
+		if err := f.err(OpRename, oldpath); err != nil {
+			return err
+		}
+	}
+	return fs.Filesystem.Rename(oldpath, newpath)
+}
+
+// Mkdir implements vfs.Filesystem.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	if f := fs.fault(OpMkdir, name); f != nil {
+		if err := f.err(OpMkdir, name); err != nil {
+			return err
+		}
+	}
+	return fs.Filesystem.Mkdir(name, perm)
+}
+
+// Stat implements vfs.Filesystem.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	if f := fs.fault(OpStat, name); f != nil {
+		if err := f.err(OpStat, name); err != nil {
+			return nil, err
+		}
+	}
+	return fs.Filesystem.Stat(name)
+}
+
+// Lstat implements vfs.Filesystem.
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	if f := fs.fault(OpLstat, name); f != nil {
+		if err := f.err(OpLstat, name); err != nil {
+			return nil, err
+		}
+	}
+	return fs.Filesystem.Lstat(name)
+}
+
+// ReadDir implements vfs.Filesystem.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	if f := fs.fault(OpReadDir, path); f != nil {
+		if err := f.err(OpReadDir, path); err != nil {
+			return nil, err
+		}
+	}
+	return fs.Filesystem.ReadDir(path)
+}
+
+// faultFile injects faults with the rules of its filesystem.
+type faultFile struct {
+	vfs.File
+	fs   *FS
+	name string
+}
+
+func (f *faultFile) Read(p []byte) (int, error) {
+	fault := f.fs.fault(OpRead, f.name)
+	if fault == nil {
+		return f.File.Read(p)
+	}
+	if fault.Short <= 0 {
+		if err := fault.err(OpRead, f.name); err != nil {
+			return 0, err
+		}
+		return f.File.Read(p)
+	}
+	n, err := f.File.Read(p[:fault.short(len(p))])
+	if err != nil {
+		return n, err
+	}
+	if err := fault.err(OpRead, f.name); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (f *faultFile) ReadAt(p []byte, off int64) (int, error) {
+	fault := f.fs.fault(OpReadAt, f.name)
+	if fault == nil {
+		return f.File.ReadAt(p, off)
+	}
+	if fault.Short <= 0 {
+		if err := fault.err(OpReadAt, f.name); err != nil {
+			return 0, err
+		}
+		return f.File.ReadAt(p, off)
+	}
+	n, err := f.File.ReadAt(p[:fault.short(len(p))], off)
+	if err != nil {
+		return n, err
+	}
+	if err := fault.err(OpReadAt, f.name); err != nil {
+		return n, err
+	}
+	// ReadAt must not return less than len(p) without an error.
+	if n < len(p) {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, nil
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	fault := f.fs.fault(OpWrite, f.name)
+	if fault == nil {
+		return f.File.Write(p)
+	}
+	if fault.Short <= 0 {
+		if err := fault.err(OpWrite, f.name); err != nil {
+			return 0, err
+		}
+		return f.File.Write(p)
+	}
+	n, err := f.File.Write(p[:fault.short(len(p))])
+	if err != nil {
+		return n, err
+	}
+	if err := fault.err(OpWrite, f.name); err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+func (f *faultFile) Seek(offset int64, whence int) (int64, error) {
+	if fault := f.fs.fault(OpSeek, f.name); fault != nil {
+		if err := fault.err(OpSeek, f.name); err != nil {
+			return 0, err
+		}
+	}
+	return f.File.Seek(offset, whence)
+}
+
+func (f *faultFile) Sync() error {
+	if fault := f.fs.fault(OpSync, f.name); fault != nil {
+		if err := fault.err(OpSync, f.name); err != nil {
+			return err
+		}
+	}
+	return f.File.Sync()
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	if fault := f.fs.fault(OpTruncate, f.name); fault != nil {
+		if err := fault.err(OpTruncate, f.name); err != nil {
+			return err
+		}
+	}
+	return f.File.Truncate(size)
+}
+
+func (f *faultFile) Stat() (os.FileInfo, error) {
+	if fault := f.fs.fault(OpFileStat, f.name); fault != nil {
+		if err := fault.err(OpFileStat, f.name); err != nil {
+			return nil, err
+		}
+	}
+	return f.File.Stat()
+}
+
+// Close always closes the underlying file, an injected error is returned afterwards.
+func (f *faultFile) Close() error {
+	fault := f.fs.fault(OpClose, f.name)
+	err := f.File.Close()
+	if fault != nil {
+		if ferr := fault.err(OpClose, f.name); ferr != nil {
+			return ferr
+		}
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package faultfs
+
+import (
+	"errors"
+	filepath "path"
+	"strings"
+	"time"
+)
+
+// ErrInjected is the default error returned by a Fault without Err.
+var ErrInjected = errors.New("Injected fault")
+
+// Operations which can be selected by Rule.Ops.
+const (
+	OpOpen     = "open"
+	OpRemove   = "remove"
+	OpRename   = "rename"
+	OpMkdir    = "mkdir"
+	OpStat     = "stat"
+	OpLstat    = "lstat"
+	OpReadDir  = "readdir"
+	OpRead     = "read"
+	OpReadAt   = "readat"
+	OpWrite    = "write"
+	OpSeek     = "seek"
+	OpSync     = "sync"
+	OpTruncate = "truncate"
+	OpFileStat = "fstat"
+	OpClose    = "close"
+)
+
+// Fault describes what happens to an affected operation.
+type Fault struct {
+	// Err is returned by the operation.
+	// If Err, Latency and Short are unset ErrInjected is used.
+	Err error
+	// Latency delays the operation.
+	Latency time.Duration
+	// Short limits reads and writes to the given fraction (0 < Short < 1) of the buffer.
+	// If Err is set the operation fails after the partial transfer,
+	// otherwise reads return fewer bytes and writes return io.ErrShortWrite.
+	// Short has no effect on other operations.
+	Short float64
+}
+
+// Rule selects operations and the trigger for a Fault.
+//
+// If neither Nth nor Probability is set, every selected call is affected.
+type Rule struct {
+	Fault
+
+	// Ops selects the operations, all operations are selected if empty.
+	Ops []string
+	// Path is a path.Match pattern selecting the paths, all paths are selected if empty.
+	// A pattern without a path separator is matched against the base name.
+	Path string
+	// Nth triggers the fault on every nth selected call.
+	Nth int
+	// Probability triggers the fault randomly with the given probability (0..1).
+	Probability float64
+	// Limit is the maximum number of triggered faults, 0 means unlimited.
+	Limit int
+}
+
+func (r *Rule) matches(op, path string) bool {
+	if len(r.Ops) > 0 {
+		found := false
+		for _, o := range r.Ops {
+			if o == op {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Path == "" {
+		return true
+	}
+	if !strings.Contains(r.Path, "/") {
+		path = filepath.Base(path)
+	}
+	ok, _ := filepath.Match(r.Path, filepath.Clean(path))
+	return ok
+}
+
+// ruleState keeps the counters of a rule.
+type ruleState struct {
+	Rule
+	calls     int
+	triggered int
+}
@@ -0,0 +1,174 @@
+// Package filterfs provides a vfs.Filesystem wrapper which hides
+// files and directories not matching include/exclude rules.
+package filterfs
+
+import (
+	"os"
+	filepath "path"
+
+	"github.com/alexsnet/vfs"
+)
+
+// Predicate reports whether the file or directory is visible.
+// It is called with an absolute, clean path.
+type Predicate func(name string, isDir bool) bool
+
+// Options of a filtering filesystem.
+type Options struct {
+	// BlockCreate rejects creating hidden files and directories with os.ErrPermission.
+	// Otherwise they are created but stay hidden.
+	BlockCreate bool
+}
+
+// A FS that hides the files and directories rejected by a Predicate.
+//
+// Hidden entries are omitted from ReadDir and appear as not existent to all
+// other operations, as do all entries below a hidden directory.
+type FS struct {
+	vfs.Filesystem
+
+	visible Predicate
+	opts    Options
+}
+
+// Create returns a filtering file system forwarding to root.
+func Create(root vfs.Filesystem, visible Predicate, opts Options) *FS {
+	return &FS{Filesystem: root, visible: visible, opts: opts}
+}
+
+// CreateFromIgnore returns a filtering file system using the patterns of the
+// ignore file name, e.g. "/.gitignore" or "/.vfsignore", read from root.
+func CreateFromIgnore(root vfs.Filesystem, name string, opts Options) (*FS, error) {
+	m, err := LoadIgnore(root, name)
+	if err != nil {
+		return nil, err
+	}
+	return Create(root, m.Visible, opts), nil
+}
+
+// parentHidden reports whether a parent directory of name is hidden.
+func (fs *FS) parentHidden(name string) bool {
+	name = filepath.Clean("/" + name)
+	for p := filepath.Dir(name); p != "/"; p = filepath.Dir(p) {
+		if !fs.visible(p, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// hidden reports whether name or one of its parents is hidden.
+// It returns whether name exists as well.
+func (fs *FS) hidden(name string) (hidden, exists bool) {
+	name = filepath.Clean("/" + name)
+	if name == "/" {
+		return false, true
+	}
+	if fs.parentHidden(name) {
+		return true, false
+	}
+	fi, err := fs.Filesystem.Lstat(name)
+	if err != nil {
+		return !fs.visible(name, false), false
+	}
+	return !fs.visible(name, fi.IsDir()), true
+}
+
+func notExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return fs.Filesystem.PathSeparator() }
+
+// OpenFile implements vfs.Filesystem.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	hidden, exists := fs.hidden(name)
+	if hidden {
+		if exists || flag&os.O_CREATE == 0 {
+			return nil, notExist("open", name)
+		}
+		if fs.opts.BlockCreate {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+		}
+	}
+	return fs.Filesystem.OpenFile(name, flag, perm)
+}
+
+// Remove implements vfs.Filesystem.
+func (fs *FS) Remove(name string) error {
+	if hidden, _ := fs.hidden(name); hidden {
+		return notExist("remove", name)
+	}
+	return fs.Filesystem.Remove(name)
+}
+
+// Rename implements vfs.Filesystem.
+// Hidden files can not be overwritten.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	if hidden, _ := fs.hidden(oldpath); hidden {
+		return notExist("rename", oldpath)
+	}
+	if fs.parentHidden(newpath) {
+		return notExist("rename", newpath)
+	}
+	fi, err := fs.Filesystem.Lstat(oldpath)
+	if err != nil {
+		return err
+	}
+	target := filepath.Clean("/" + newpath)
+	if _, err := fs.Filesystem.Lstat(newpath); err == nil && !fs.visible(target, fi.IsDir()) {
+		return &os.PathError{Op: "rename", Path: newpath, Err: os.ErrPermission}
+	}
+	if fs.opts.BlockCreate && !fs.visible(target, fi.IsDir()) {
+		return &os.PathError{Op: "rename", Path: newpath, Err: os.ErrPermission}
+	}
+	return fs.Filesystem.Rename(oldpath, newpath)
+}
+
+// Mkdir implements vfs.Filesystem.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	if fs.parentHidden(name) {
+		return notExist("mkdir", name)
+	}
+	if fs.opts.BlockCreate && !fs.visible(filepath.Clean("/"+name), true) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrPermission}
+	}
+	return fs.Filesystem.Mkdir(name, perm)
+}
+
+// Stat implements vfs.Filesystem.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	if hidden, _ := fs.hidden(name); hidden {
+		return nil, notExist("stat", name)
+	}
+	return fs.Filesystem.Stat(name)
+}
+
+// Lstat implements vfs.Filesystem.
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	if hidden, _ := fs.hidden(name); hidden {
+		return nil, notExist("lstat", name)
+	}
+	return fs.Filesystem.Lstat(name)
+}
+
+// ReadDir implements vfs.Filesystem.
+// Hidden entries are omitted.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	if hidden, _ := fs.hidden(path); hidden {
+		return nil, notExist("readdir", path)
+	}
+	fis, err := fs.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Clean("/" + path)
+	res := fis[:0]
+	for _, fi := range fis {
+		if fs.visible(filepath.Join(dir, fi.Name()), fi.IsDir()) {
+			res = append(res, fi)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,110 @@
+package filterfs
+
+import (
+	"bufio"
+	"bytes"
+	filepath "path"
+	"strings"
+
+	"github.com/alexsnet/vfs"
+	"github.com/alexsnet/vfs/internal/glob"
+)
+
+// pattern is a parsed gitignore line.
+type pattern struct {
+	segs    []string
+	negate  bool
+	dirOnly bool
+	// anchored patterns match the whole path, others any trailing segments.
+	anchored bool
+}
+
+// Matcher matches paths against gitignore-style patterns.
+//
+// Lines starting with "#" are comments, a leading "!" re-includes
+// previously excluded paths and a trailing "/" only matches directories.
+// Patterns containing a "/" are relative to the root, others match the
+// name at any depth. A "**" segment matches any number of segments.
+// The last matching pattern decides.
+type Matcher struct {
+	patterns []pattern
+}
+
+// NewMatcher parses the given patterns, one per entry.
+func NewMatcher(lines ...string) *Matcher {
+	m := &Matcher{}
+	for _, line := range lines {
+		m.add(line)
+	}
+	return m
+}
+
+// LoadIgnore parses a .gitignore or .vfsignore file of fs.
+func LoadIgnore(fs vfs.Filesystem, name string) (*Matcher, error) {
+	b, err := vfs.ReadFile(fs, name)
+	if err != nil {
+		return nil, err
+	}
+	m := &Matcher{}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		m.add(s.Text())
+	}
+	return m, s.Err()
+}
+
+func (m *Matcher) add(line string) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+	p := pattern{}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	p.anchored = strings.Contains(line, "/")
+	line = strings.Trim(line, "/")
+	if line == "" {
+		return
+	}
+	p.segs = strings.Split(line, "/")
+	m.patterns = append(m.patterns, p)
+}
+
+// Excluded reports whether the path itself is excluded,
+// excluded parent directories are not taken into account.
+func (m *Matcher) Excluded(name string, isDir bool) bool {
+	segs := strings.Split(strings.Trim(filepath.Clean("/"+name), "/"), "/")
+	excluded := false
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.matches(segs) {
+			excluded = !p.negate
+		}
+	}
+	return excluded
+}
+
+// Visible is a Predicate reporting the paths not excluded.
+func (m *Matcher) Visible(name string, isDir bool) bool {
+	return !m.Excluded(name, isDir)
+}
+
+func (p *pattern) matches(segs []string) bool {
+	if p.anchored {
+		return glob.Match(p.segs, segs)
+	}
+	if len(segs) < len(p.segs) {
+		return false
+	}
+	return glob.Match(p.segs, segs[len(segs)-len(p.segs):])
+}
@@ -0,0 +1,253 @@
+// Package foldfs provides a vfs.Filesystem wrapper which resolves names
+// case-insensitively and independent of their Unicode normalization form.
+package foldfs
+
+import (
+	"errors"
+	"os"
+	filepath "path"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/alexsnet/vfs"
+)
+
+// ErrCollision is returned if a name matches several entries of a directory
+// and none of them exactly.
+var ErrCollision = errors.New("Name matches several entries")
+
+// Options of a folding filesystem.
+type Options struct {
+	// CaseInsensitive resolves names using Unicode case folding.
+	CaseInsensitive bool
+	// Normalize resolves names after normalizing them to NFC,
+	// so NFC names (Windows, Linux) and NFD names (macOS) are equal.
+	Normalize bool
+}
+
+// A FS that resolves every path segment against the existing directory entries.
+//
+// An existing entry matching the name exactly is always preferred.
+// New files and directories are created with the name as given,
+// which preserves its case and normalization form.
+// If several entries of a directory match a name, but none exactly,
+// the operation fails with ErrCollision, see Collisions.
+//
+// mountfs.MountFS is case-sensitive, but it lists mount points in ReadDir.
+// Wrapping the MountFS as a whole therefore resolves mount points as well,
+// while wrapping single mounts only affects the names inside them.
+type FS struct {
+	vfs.Filesystem
+
+	opts Options
+}
+
+// Create returns a folding file system forwarding to root.
+func Create(root vfs.Filesystem, opts Options) *FS {
+	return &FS{Filesystem: root, opts: opts}
+}
+
+// Key returns the name under which segment is compared to other names.
+func (fs *FS) Key(segment string) string {
+	if fs.opts.Normalize || fs.opts.CaseInsensitive {
+		segment = norm.NFC.String(segment)
+	}
+	if fs.opts.CaseInsensitive {
+		// Folding does not preserve the normalization form, a Caser is not safe for concurrent use.
+		segment = norm.NFC.String(cases.Fold().String(segment))
+	}
+	return segment
+}
+
+// lookup returns the entry of the resolved directory dir matching name.
+// It returns an empty string if there is none.
+func (fs *FS) lookup(dir, name string) (string, error) {
+	if _, err := fs.Filesystem.Lstat(filepath.Join(dir, name)); err == nil {
+		return name, nil
+	}
+	fis, err := fs.Filesystem.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	key := fs.Key(name)
+	found := ""
+	for _, fi := range fis {
+		if fs.Key(fi.Name()) != key {
+			continue
+		}
+		if found != "" {
+			return "", &os.PathError{Op: "lookup", Path: filepath.Join(dir, name), Err: ErrCollision}
+		}
+		found = fi.Name()
+	}
+	return found, nil
+}
+
+// resolve returns the existing path matching name.
+// If the last segment does not exist, it is returned as given.
+// Missing parent directories return an os.ErrNotExist error.
+// Relative names stay relative, leading ".." segments are kept.
+func (fs *FS) resolve(name string) (string, error) {
+	clean := filepath.Clean(name)
+	p := "/"
+	if !filepath.IsAbs(clean) {
+		p = "."
+	}
+	segs := strings.Split(strings.Trim(clean, "/"), "/")
+	for i, seg := range segs {
+		if seg == "" || seg == "." {
+			continue
+		}
+		if seg == ".." {
+			p = filepath.Join(p, seg)
+			continue
+		}
+		found, err := fs.lookup(p, seg)
+		if err != nil {
+			if os.IsNotExist(err) {
+				err = &os.PathError{Op: "lookup", Path: name, Err: os.ErrNotExist}
+			}
+			return "", err
+		}
+		if found == "" {
+			if i < len(segs)-1 {
+				return "", &os.PathError{Op: "lookup", Path: name, Err: os.ErrNotExist}
+			}
+			found = seg
+		}
+		p = filepath.Join(p, found)
+	}
+	return p, nil
+}
+
+// Resolve returns the path of the existing file or directory matching name.
+func (fs *FS) Resolve(name string) (string, error) {
+	p, err := fs.resolve(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fs.Filesystem.Lstat(p); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// Collisions returns the groups of entries below dir whose names have the same key.
+// Each group is a list of paths.
+func (fs *FS) Collisions(dir string) ([][]string, error) {
+	dir, err := fs.resolve(dir)
+	if err != nil {
+		return nil, err
+	}
+	fis, err := fs.Filesystem.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	byKey := make(map[string][]string)
+	groups := [][]string{}
+	for _, fi := range fis {
+		p := filepath.Join(dir, fi.Name())
+		key := fs.Key(fi.Name())
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], p)
+		if fi.IsDir() {
+			sub, err := fs.Collisions(p)
+			if err != nil {
+				return nil, err
+			}
+			groups = append(groups, sub...)
+		}
+	}
+	for _, key := range keys {
+		if len(byKey[key]) > 1 {
+			groups = append(groups, byKey[key])
+		}
+	}
+	return groups, nil
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return fs.Filesystem.PathSeparator() }
+
+// OpenFile implements vfs.Filesystem.
+// A new file is created with the name as given.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	p, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Filesystem.OpenFile(p, flag, perm)
+}
+
+// Remove implements vfs.Filesystem.
+func (fs *FS) Remove(name string) error {
+	p, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	return fs.Filesystem.Remove(p)
+}
+
+// Rename implements vfs.Filesystem.
+// Renaming a file to a name differing only in case or form changes its name.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	o, err := fs.resolve(oldpath)
+	if err != nil {
+		return err
+	}
+	n, err := fs.resolve(newpath)
+	if err != nil {
+		return err
+	}
+	if n == o {
+		// Same entry, keep the new spelling of the last segment.
+		n = filepath.Join(filepath.Dir(n), filepath.Base(newpath))
+		if n == o {
+			return nil
+		}
+	}
+	return fs.Filesystem.Rename(o, n)
+}
+
+// Mkdir implements vfs.Filesystem.
+// A new directory is created with the name as given.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	p, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	return fs.Filesystem.Mkdir(p, perm)
+}
+
+// Stat implements vfs.Filesystem.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	p, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Filesystem.Stat(p)
+}
+
+// Lstat implements vfs.Filesystem.
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	p, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Filesystem.Lstat(p)
+}
+
+// ReadDir implements vfs.Filesystem.
+// Entries are listed with their stored names.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	p, err := fs.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Filesystem.ReadDir(p)
+}
@@ -0,0 +1,195 @@
+package httpfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/alexsnet/vfs"
+)
+
+// file reads a file with Range requests.
+// Every request covers at least readAhead bytes, the response is kept
+// to serve the following reads.
+type file struct {
+	fs   *FS
+	name string
+	// size is -1 until the end of a file without Content-Length is found.
+	size int64
+
+	mutex  sync.Mutex
+	pos    int64
+	buf    []byte
+	bufOff int64
+	closed bool
+}
+
+// get returns the bytes from off up to and including end, the mutex must be held.
+// It returns io.EOF if off is beyond the end of the file.
+func (f *file) get(off, end int64) ([]byte, error) {
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", off, end)}}
+	resp, err := f.fs.do("GET", f.name, false, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, size, ok := contentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != off {
+			err := fmt.Errorf("httpfs: unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), off)
+			return nil, &os.PathError{Op: "read", Path: f.name, Err: err}
+		}
+		if size >= 0 {
+			f.size = size
+		}
+		return ioutil.ReadAll(io.LimitReader(resp.Body, end-off+1))
+	case http.StatusOK:
+		// The range has been ignored, skip to off.
+		if off > 0 {
+			if _, err := io.CopyN(ioutil.Discard, resp.Body, off); err == io.EOF {
+				return nil, io.EOF
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		return ioutil.ReadAll(io.LimitReader(resp.Body, end-off+1))
+	case http.StatusRequestedRangeNotSatisfiable:
+		return nil, io.EOF
+	default:
+		return nil, statusError("read", f.name, resp)
+	}
+}
+
+// contentRange returns the start and the complete length of a Content-Range
+// header such as "bytes 0-99/1234", the length is -1 if it is unknown.
+func contentRange(header string) (start, size int64, ok bool) {
+	var end int64
+	var total string
+	if _, err := fmt.Sscanf(header, "bytes %d-%d/%s", &start, &end, &total); err != nil || end < start {
+		return 0, 0, false
+	}
+	size = -1
+	if total != "*" {
+		if size, err := strconv.ParseInt(total, 10, 64); err == nil && size > end {
+			return start, size, true
+		}
+		return 0, 0, false
+	}
+	return start, size, true
+}
+
+// readAt fills p from off, using the buffer where possible. The mutex must be held.
+func (f *file) readAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if f.size >= 0 && pos >= f.size {
+			return n, io.EOF
+		}
+		if pos >= f.bufOff && pos < f.bufOff+int64(len(f.buf)) {
+			n += copy(p[n:], f.buf[pos-f.bufOff:])
+			continue
+		}
+		want := int64(len(p) - n)
+		if want < int64(f.fs.readAhead) {
+			want = int64(f.fs.readAhead)
+		}
+		end := pos + want - 1
+		if f.size >= 0 && end >= f.size {
+			end = f.size - 1
+		}
+		data, err := f.get(pos, end)
+		if err == io.EOF || (err == nil && int64(len(data)) < end-pos+1) {
+			// The file is shorter than expected.
+			f.size = pos + int64(len(data))
+			if len(data) == 0 {
+				return n, io.EOF
+			}
+		} else if err != nil {
+			return n, err
+		}
+		f.buf, f.bufOff = data, pos
+	}
+	return n, nil
+}
+
+func (f *file) Name() string { return f.name }
+
+func (f *file) Stat() (os.FileInfo, error) { return f.fs.Stat(f.name) }
+
+func (f *file) Read(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrClosed}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := f.readAt(p, f.pos)
+	f.pos += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("ReadAt: negative offset")
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrClosed}
+	}
+	return f.readAt(p, off)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.pos + offset
+	case io.SeekEnd:
+		if f.size < 0 {
+			return 0, errors.New("Seek: unknown size")
+		}
+		abs = f.size + offset
+	default:
+		return 0, errors.New("Seek: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("Seek: negative position")
+	}
+	f.pos = abs
+	return abs, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: vfs.ErrReadOnly}
+}
+
+func (f *file) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: vfs.ErrReadOnly}
+}
+
+func (f *file) Sync() error { return nil }
+
+func (f *file) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.closed = true
+	f.buf = nil
+	return nil
+}
@@ -0,0 +1,462 @@
+// Package httpfs provides a read-only vfs.Filesystem backed by a static HTTP server.
+package httpfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	filepath "path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexsnet/vfs"
+)
+
+// DefaultReadAhead is the default minimum size of range requests.
+const DefaultReadAhead = 256 << 10
+
+// ErrNoListing is returned by ReadDir if neither a manifest nor autoindex is configured.
+var ErrNoListing = errors.New("Directory listing not available")
+
+// Options configure a FS.
+type Options struct {
+	// Header is added to every request, e.g. for an Authorization header.
+	Header http.Header
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+	// ReadAhead is the minimum number of bytes requested by a read,
+	// DefaultReadAhead if 0. Negative values disable read-ahead.
+	ReadAhead int
+	// Manifest is the path of a JSON index listing all files, e.g. "/index.json".
+	// It is an array of ManifestEntry objects, parent directories are implied.
+	// If set, it is read once and serves ReadDir and Stat of directories.
+	Manifest string
+	// Autoindex lists directories by parsing the HTML index pages served for
+	// directory URLs, e.g. by nginx autoindex or Apache mod_autoindex.
+	Autoindex bool
+}
+
+// ManifestEntry is a file of the manifest.
+// Names ending with a slash are directories.
+type ManifestEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// A FS reading the files of an HTTP server.
+//
+// Stat uses HEAD requests, reads are Range requests of at least ReadAhead
+// bytes, which are kept to serve subsequent reads. Directories are detected
+// by redirects to or successful responses for the URL with a trailing slash,
+// or by the manifest. All modifications return vfs.ErrReadOnly.
+type FS struct {
+	base      *url.URL
+	opts      Options
+	readAhead int
+
+	mutex    sync.Mutex
+	manifest *manifest
+}
+
+// Create returns a FS for the files below base, e.g. "https://example.com/assets/".
+func Create(base string, opts Options) (*FS, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("httpfs: unsupported scheme %q", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	fs := &FS{base: u, opts: opts, readAhead: opts.ReadAhead}
+	if fs.readAhead == 0 {
+		fs.readAhead = DefaultReadAhead
+	} else if fs.readAhead < 0 {
+		fs.readAhead = 0
+	}
+	return fs, nil
+}
+
+// httpError is returned for unexpected responses of the server.
+type httpError struct {
+	code   int
+	status string
+}
+
+func (e *httpError) Error() string {
+	return "HTTP request failed: " + e.status
+}
+
+// StatusCode returns the HTTP status code of the response.
+func (e *httpError) StatusCode() int {
+	return e.code
+}
+
+// statusError returns a *os.PathError describing the unexpected response resp.
+func statusError(op, name string, resp *http.Response) error {
+	var err error
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusGone:
+		err = os.ErrNotExist
+	case http.StatusUnauthorized, http.StatusForbidden:
+		err = os.ErrPermission
+	default:
+		err = &httpError{code: resp.StatusCode, status: resp.Status}
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+func clean(name string) string {
+	return filepath.Clean("/" + name)
+}
+
+// url returns the URL of name, with a trailing slash for directories.
+func (fs *FS) url(name string, dir bool) *url.URL {
+	u := *fs.base
+	u.Path = fs.base.Path + clean(name)
+	if dir && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return &u
+}
+
+// do sends a request for name with the configured headers.
+func (fs *FS) do(method, name string, dir bool, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, fs.url(name, dir).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range fs.opts.Header {
+		req.Header[k] = v
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return fs.opts.Client.Do(req)
+}
+
+// Props are the HTTP headers describing a file, returned by FileInfo.Sys.
+type Props struct {
+	// URL of the file.
+	URL string
+	// ETag including its quotes.
+	ETag        string
+	ContentType string
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	dir     bool
+	modTime time.Time
+	props   *Props
+}
+
+func (fi *fileInfo) Name() string { return fi.name }
+func (fi *fileInfo) Size() int64 {
+	if fi.size < 0 {
+		// Unknown without Content-Length.
+		return 0
+	}
+	return fi.size
+}
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return 0555 | os.ModeDir
+	}
+	return 0444
+}
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() interface{}   { return fi.props }
+
+func (fs *FS) dirInfo(name string, modTime time.Time) *fileInfo {
+	return &fileInfo{
+		name:    filepath.Base(name),
+		dir:     true,
+		modTime: modTime,
+		props:   &Props{URL: fs.url(name, true).String()},
+	}
+}
+
+// manifest is the parsed index of Options.Manifest.
+type manifest struct {
+	infos map[string]*fileInfo
+	// childs are the entries of each directory.
+	childs map[string]map[string]*fileInfo
+}
+
+// loadManifest returns the manifest, reading it on first use.
+// Failures are not cached, the next call tries again.
+func (fs *FS) loadManifest() (*manifest, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if fs.manifest != nil {
+		return fs.manifest, nil
+	}
+	resp, err := fs.do("GET", fs.opts.Manifest, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError("manifest", fs.opts.Manifest, resp)
+	}
+	var entries []ManifestEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, &os.PathError{Op: "manifest", Path: fs.opts.Manifest, Err: err}
+	}
+
+	m := &manifest{
+		infos:  map[string]*fileInfo{"/": fs.dirInfo("/", time.Time{})},
+		childs: map[string]map[string]*fileInfo{"/": {}},
+	}
+	var add func(name string, fi *fileInfo)
+	add = func(name string, fi *fileInfo) {
+		if old, ok := m.infos[name]; ok && old.dir && fi.dir {
+			return
+		}
+		dir := filepath.Dir(name)
+		if _, ok := m.childs[dir]; !ok {
+			add(dir, fs.dirInfo(dir, fi.modTime))
+		}
+		m.infos[name] = fi
+		m.childs[dir][fi.name] = fi
+		if fi.dir {
+			m.childs[name] = map[string]*fileInfo{}
+		} else {
+			// Later entries replace earlier ones.
+			delete(m.childs, name)
+		}
+	}
+	for _, e := range entries {
+		name := clean(e.Name)
+		if name == "/" {
+			continue
+		}
+		if strings.HasSuffix(e.Name, "/") {
+			add(name, fs.dirInfo(name, e.ModTime))
+			continue
+		}
+		add(name, &fileInfo{
+			name:    filepath.Base(name),
+			size:    e.Size,
+			modTime: e.ModTime,
+			props:   &Props{URL: fs.url(name, false).String()},
+		})
+	}
+	fs.manifest = m
+	return m, nil
+}
+
+// head describes the file or directory name with a HEAD request.
+func (fs *FS) head(op, name string) (*fileInfo, error) {
+	resp, err := fs.do("HEAD", name, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && name != "/" {
+		// Servers without redirects may know the directory with a trailing slash only.
+		resp1, err := fs.do("HEAD", name, true, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp1.Body.Close()
+		if resp1.StatusCode == http.StatusOK {
+			resp = resp1
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(op, name, resp)
+	}
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if name == "/" || strings.HasSuffix(resp.Request.URL.Path, "/") {
+		// Redirected to the index page of a directory.
+		return fs.dirInfo(name, modTime), nil
+	}
+	fi := &fileInfo{
+		name:    filepath.Base(name),
+		size:    resp.ContentLength,
+		modTime: modTime,
+		props: &Props{
+			URL:         resp.Request.URL.String(),
+			ETag:        resp.Header.Get("ETag"),
+			ContentType: resp.Header.Get("Content-Type"),
+		},
+	}
+	return fi, nil
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return '/' }
+
+// OpenFile implements vfs.Filesystem.
+// Only os.O_RDONLY is supported.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: vfs.ErrReadOnly}
+	}
+	p := clean(name)
+	fi, err := fs.stat("open", p)
+	if err != nil {
+		return nil, err
+	}
+	if fi.dir {
+		return nil, &os.PathError{Op: "open", Path: name, Err: vfs.ErrIsDirectory}
+	}
+	return &file{fs: fs, name: p, size: fi.size}, nil
+}
+
+// Remove implements vfs.Filesystem.
+func (fs *FS) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: vfs.ErrReadOnly}
+}
+
+// Rename implements vfs.Filesystem.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	return &os.PathError{Op: "rename", Path: oldpath, Err: vfs.ErrReadOnly}
+}
+
+// Mkdir implements vfs.Filesystem.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: vfs.ErrReadOnly}
+}
+
+func (fs *FS) stat(op, name string) (*fileInfo, error) {
+	if fs.opts.Manifest != "" {
+		m, err := fs.loadManifest()
+		if err != nil {
+			return nil, err
+		}
+		if fi, ok := m.infos[name]; ok && fi.dir {
+			return fi, nil
+		}
+	}
+	return fs.head(op, name)
+}
+
+// Stat implements vfs.Filesystem.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	return fs.stat("stat", clean(name))
+}
+
+// Lstat implements vfs.Filesystem.
+// HTTP has no symlinks, it is the same as Stat.
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	return fs.stat("lstat", clean(name))
+}
+
+// ReadDir implements vfs.Filesystem.
+// Entries are taken from the manifest or the autoindex page, sorted by name.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	p := clean(path)
+	switch {
+	case fs.opts.Manifest != "":
+		m, err := fs.loadManifest()
+		if err != nil {
+			return nil, err
+		}
+		childs, ok := m.childs[p]
+		if !ok {
+			if _, ok := m.infos[p]; ok {
+				return nil, &os.PathError{Op: "readdir", Path: path, Err: vfs.ErrNotDirectory}
+			}
+			return nil, &os.PathError{Op: "readdir", Path: path, Err: os.ErrNotExist}
+		}
+		fis := make([]os.FileInfo, 0, len(childs))
+		for _, fi := range childs {
+			fis = append(fis, fi)
+		}
+		sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+		return fis, nil
+	case fs.opts.Autoindex:
+		return fs.autoindex(p)
+	}
+	return nil, &os.PathError{Op: "readdir", Path: path, Err: ErrNoListing}
+}
+
+// hrefs matches the link targets of an HTML page.
+var hrefs = regexp.MustCompile(`(?i)<a\s[^>]*?href\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+// autoindex lists the directory name by parsing its HTML index page.
+// Links outside of the directory, to parents, with queries (e.g. sort links)
+// or to nested paths are ignored. Files are described with HEAD requests.
+func (fs *FS) autoindex(name string) ([]os.FileInfo, error) {
+	resp, err := fs.do("GET", name, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError("readdir", name, resp)
+	}
+	page, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	dirURL := resp.Request.URL
+	if !strings.HasSuffix(dirURL.Path, "/") {
+		// The page of a file, not a directory.
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: vfs.ErrNotDirectory}
+	}
+	dirs := map[string]bool{}
+	for _, match := range hrefs.FindAllSubmatch(page, -1) {
+		href := string(match[1]) + string(match[2])
+		u, err := url.Parse(html.UnescapeString(href))
+		if err != nil || u.RawQuery != "" || u.Opaque != "" {
+			continue
+		}
+		target := dirURL.ResolveReference(u)
+		if target.Scheme != dirURL.Scheme || target.Host != dirURL.Host ||
+			!strings.HasPrefix(target.Path, dirURL.Path) {
+			continue
+		}
+		rel := strings.TrimPrefix(target.Path, dirURL.Path)
+		dir := strings.HasSuffix(rel, "/")
+		rel = strings.TrimSuffix(rel, "/")
+		if rel == "" || rel == "." || rel == ".." || strings.Contains(rel, "/") {
+			continue
+		}
+		dirs[rel] = dirs[rel] || dir
+	}
+
+	names := make([]string, 0, len(dirs))
+	for n := range dirs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	fis := make([]os.FileInfo, 0, len(names))
+	for _, n := range names {
+		p := filepath.Join(name, n)
+		if dirs[n] {
+			fis = append(fis, fs.dirInfo(p, time.Time{}))
+			continue
+		}
+		fi, err := fs.head("readdir", p)
+		if os.IsNotExist(err) {
+			// A stale link.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		fis = append(fis, fi)
+	}
+	return fis, nil
+}
@@ -0,0 +1,348 @@
+package httpfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexsnet/vfs"
+)
+
+var modTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// site serves files and directory pages from memory like a static web server.
+// Directories without trailing slash are redirected if redirect is set,
+// Range headers are ignored if noRange is set.
+type site struct {
+	files    map[string]string
+	redirect bool
+	noRange  bool
+
+	mutex  sync.Mutex
+	ranges []string
+}
+
+func (s *site) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	if r.Method == "GET" {
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+	}
+	s.mutex.Unlock()
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	content, ok := s.files[r.URL.Path]
+	if !ok {
+		if _, ok := s.files[r.URL.Path+"/"]; ok && s.redirect {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/") {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, content)
+		return
+	}
+	if s.noRange {
+		r.Header.Del("Range")
+	}
+	w.Header().Set("ETag", `"`+r.URL.Path+`"`)
+	http.ServeContent(w, r, r.URL.Path, modTime, strings.NewReader(content))
+}
+
+func (s *site) requests() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+func newFS(t *testing.T, s *site, opts Options) *FS {
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	if opts.Header == nil {
+		opts.Header = http.Header{"Authorization": {"Bearer token"}}
+	}
+	fs, err := Create(srv.URL+"/base/", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestStat(t *testing.T) {
+	for _, redirect := range []bool{true, false} {
+		s := &site{redirect: redirect, files: map[string]string{
+			"/base/":       "",
+			"/base/d/":     "",
+			"/base/d/f.js": "content",
+		}}
+		fs := newFS(t, s, Options{})
+		fi, err := fs.Stat("/d/f.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+		props := fi.Sys().(*Props)
+		if fi.IsDir() || fi.Size() != 7 || !fi.ModTime().Equal(modTime) || props.ETag != `"/base/d/f.js"` ||
+			!strings.Contains(props.ContentType, "javascript") {
+			t.Fatalf("Stat = %v %d %v %+v", fi.IsDir(), fi.Size(), fi.ModTime(), props)
+		}
+		for _, dir := range []string{"/", "/d"} {
+			if fi, err := fs.Stat(dir); err != nil || !fi.IsDir() {
+				t.Fatalf("redirect %v: Stat(%q) = %v, %v", redirect, dir, fi, err)
+			}
+		}
+		if _, err := fs.Stat("/missing"); !os.IsNotExist(err) {
+			t.Fatalf("Stat of missing file = %v", err)
+		}
+		if _, err := fs.OpenFile("/d", os.O_RDONLY, 0); !errors.Is(err, vfs.ErrIsDirectory) {
+			t.Fatalf("OpenFile of directory = %v", err)
+		}
+	}
+
+	fs := newFS(t, &site{}, Options{Header: http.Header{}})
+	if _, err := fs.Stat("/f"); !os.IsPermission(err) {
+		t.Fatalf("Stat without authorization = %v", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	fs := newFS(t, &site{files: map[string]string{"/base/f": "x"}}, Options{})
+	if _, err := fs.OpenFile("/f", os.O_RDWR, 0); !errors.Is(err, vfs.ErrReadOnly) {
+		t.Fatalf("OpenFile for writing = %v", err)
+	}
+	if err := fs.Remove("/f"); !errors.Is(err, vfs.ErrReadOnly) {
+		t.Fatalf("Remove = %v", err)
+	}
+	if _, err := fs.ReadDir("/"); !errors.Is(err, ErrNoListing) {
+		t.Fatalf("ReadDir without listing = %v", err)
+	}
+}
+
+func content(n int) string {
+	var b strings.Builder
+	for i := 0; b.Len() < n; i++ {
+		b.WriteString("0123456789")
+	}
+	return b.String()[:n]
+}
+
+func TestReadAhead(t *testing.T) {
+	data := content(10000)
+	s := &site{files: map[string]string{"/base/f": data}}
+	fs := newFS(t, s, Options{ReadAhead: 4096})
+	f, err := fs.OpenFile("/f", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var got bytes.Buffer
+	p := make([]byte, 100)
+	for {
+		n, err := f.Read(p)
+		got.Write(p[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got.String() != data {
+		t.Fatal("content differs")
+	}
+	want := []string{"bytes=0-4095", "bytes=4096-8191", "bytes=8192-9999"}
+	if r := s.requests(); strings.Join(r, ",") != strings.Join(want, ",") {
+		t.Fatalf("requests %q, want %q", r, want)
+	}
+
+	// Reads larger than the read-ahead are requested at once.
+	big := make([]byte, 5000)
+	if n, err := f.ReadAt(big, 10); n != 5000 || err != nil || string(big) != data[10:5010] {
+		t.Fatalf("ReadAt = %d, %v", n, err)
+	}
+	if n, err := f.ReadAt(p[:10], 9995); n != 5 || err != io.EOF || string(p[:n]) != "56789" {
+		t.Fatalf("ReadAt at end = %d, %v, %q", n, err, p[:n])
+	}
+	if pos, err := f.Seek(-3, io.SeekEnd); pos != 9997 || err != nil {
+		t.Fatalf("Seek = %d, %v", pos, err)
+	}
+	rest, err := ioutil.ReadAll(f)
+	if err != nil || string(rest) != "789" {
+		t.Fatalf("ReadAll = %q, %v", rest, err)
+	}
+}
+
+func TestIgnoredRange(t *testing.T) {
+	data := content(1000)
+	fs := newFS(t, &site{noRange: true, files: map[string]string{"/base/f": data}}, Options{ReadAhead: -1})
+	f, err := fs.OpenFile("/f", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p := make([]byte, 10)
+	if n, err := f.ReadAt(p, 500); n != 10 || err != nil || string(p) != data[500:510] {
+		t.Fatalf("ReadAt = %d, %v, %q", n, err, p)
+	}
+	if n, err := f.ReadAt(p, 995); n != 5 || err != io.EOF || string(p[:n]) != data[995:] {
+		t.Fatalf("ReadAt at end = %d, %v, %q", n, err, p[:n])
+	}
+}
+
+func TestWrongContentRange(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		if r.Method == "HEAD" {
+			return
+		}
+		// Always the start of the file.
+		w.Header().Set("Content-Range", "bytes 0-9/100")
+		w.Header().Set("Content-Length", "10")
+		w.WriteHeader(http.StatusPartialContent)
+		io.WriteString(w, "0123456789")
+	}))
+	defer srv.Close()
+	fs, err := Create(srv.URL, Options{ReadAhead: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.OpenFile("/f", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p := make([]byte, 10)
+	if _, err := f.ReadAt(p, 50); err == nil {
+		t.Fatal("ReadAt accepted the wrong range")
+	}
+	if n, err := f.ReadAt(p, 0); n != 10 || err != nil {
+		t.Fatalf("ReadAt = %d, %v", n, err)
+	}
+}
+
+func TestContentRange(t *testing.T) {
+	for _, tc := range []struct {
+		header      string
+		start, size int64
+		ok          bool
+	}{
+		{"bytes 0-99/1234", 0, 1234, true},
+		{"bytes 100-199/*", 100, -1, true},
+		{"bytes 100-99/1234", 0, 0, false},
+		{"bytes 0-99/50", 0, 0, false},
+		{"bytes */1234", 0, 0, false},
+		{"", 0, 0, false},
+	} {
+		start, size, ok := contentRange(tc.header)
+		if start != tc.start || size != tc.size || ok != tc.ok {
+			t.Errorf("contentRange(%q) = %d, %d, %v", tc.header, start, size, ok)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	s := &site{files: map[string]string{
+		"/base/index.json": `[
+			{"name": "a/b/c.txt", "size": 3, "modTime": "2020-01-02T03:04:05Z"},
+			{"name": "a/d.txt", "size": 1},
+			{"name": "empty/"},
+			{"name": "top"}
+		]`,
+		"/base/a/b/c.txt": "abc",
+	}}
+	fs := newFS(t, s, Options{Manifest: "/index.json"})
+	for dir, want := range map[string]string{
+		"/":      "a/ empty/ top",
+		"/a":     "b/ d.txt",
+		"/a/b":   "c.txt",
+		"/empty": "",
+	} {
+		fis, err := fs.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range fis {
+			name := fi.Name()
+			if fi.IsDir() {
+				name += "/"
+			}
+			names = append(names, name)
+		}
+		if got := strings.Join(names, " "); got != want {
+			t.Errorf("ReadDir(%q) = %q, want %q", dir, got, want)
+		}
+	}
+	fis, _ := fs.ReadDir("/a/b")
+	if fis[0].Size() != 3 || !fis[0].ModTime().Equal(modTime) {
+		t.Errorf("manifest entry = %d %v", fis[0].Size(), fis[0].ModTime())
+	}
+	// Directories are known without requests.
+	if fi, err := fs.Stat("/empty"); err != nil || !fi.IsDir() {
+		t.Fatalf("Stat = %v, %v", fi, err)
+	}
+	if _, err := fs.ReadDir("/top"); !errors.Is(err, vfs.ErrNotDirectory) {
+		t.Fatalf("ReadDir of file = %v", err)
+	}
+	if _, err := fs.ReadDir("/missing"); !os.IsNotExist(err) {
+		t.Fatalf("ReadDir of missing directory = %v", err)
+	}
+	if data, err := vfs.ReadFile(fs, "/a/b/c.txt"); err != nil || string(data) != "abc" {
+		t.Fatalf("ReadFile = %q, %v", data, err)
+	}
+}
+
+func TestAutoindex(t *testing.T) {
+	s := &site{redirect: true, files: map[string]string{
+		"/base/": `<html><body><h1>Index of /base/</h1>
+<a href="?C=N;O=D">Name</a> <a href='?C=M;O=A'>Last modified</a>
+<a href="../">Parent Directory</a>
+<a href="sub/">sub/</a>
+<a href="a%20b.txt">a b.txt</a>
+<A HREF="/base/c&amp;d.txt">c&amp;d.txt</A>
+<a href="c&amp;d.txt"><img src="icon.png"></a>
+<a href="stale.txt">stale.txt</a>
+<a href="sub/nested.txt">nested</a>
+<a href="/other/x.txt">outside</a>
+<a href="http://example.com/base/y.txt">other host</a>
+<a href="#top">top</a>
+</body></html>`,
+		"/base/sub/":      `<a href="../">..</a>`,
+		"/base/a b.txt":   "ab",
+		"/base/c&d.txt":   "cd!",
+		"/base/other.txt": "not linked",
+	}}
+	fs := newFS(t, s, Options{Autoindex: true})
+	fis, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if got := strings.Join(names, ","); got != "a b.txt,c&d.txt,sub" {
+		t.Fatalf("ReadDir = %q", got)
+	}
+	if fis[0].Size() != 2 || fis[1].Size() != 3 || !fis[2].IsDir() {
+		t.Fatalf("sizes %d %d, dir %v", fis[0].Size(), fis[1].Size(), fis[2].IsDir())
+	}
+	if fis, err := fs.ReadDir("/sub"); err != nil || len(fis) != 0 {
+		t.Fatalf("ReadDir(/sub) = %v, %v", fis, err)
+	}
+	if _, err := fs.ReadDir("/a b.txt"); !errors.Is(err, vfs.ErrNotDirectory) && !os.IsNotExist(err) {
+		t.Fatalf("ReadDir of file = %v", err)
+	}
+}
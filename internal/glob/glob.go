@@ -0,0 +1,46 @@
+// Package glob matches paths split into segments against patterns whose
+// segments are path.Match patterns, a "**" segment matches any number of segments.
+package glob
+
+import (
+	filepath "path"
+)
+
+// Match reports whether the segments of a name match the pattern segments.
+func Match(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if Match(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// MatchPrefix reports whether the pattern can match a path below name.
+func MatchPrefix(pattern, name []string) bool {
+	for len(name) > 0 {
+		if len(pattern) == 0 {
+			return false
+		}
+		if pattern[0] == "**" {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(pattern) > 0
+}
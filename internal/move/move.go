@@ -0,0 +1,66 @@
+// Package move renames files and directory trees on backends which
+// report success for renames they do not perform, such as s3fs.
+package move
+
+import (
+	"io"
+	"os"
+	filepath "path"
+
+	"github.com/alexsnet/vfs"
+)
+
+// Rename renames oldpath to newpath on fs.
+// If the rename succeeds but newpath does not exist afterwards,
+// oldpath is copied to newpath and removed. Errors of the rename are returned as is.
+func Rename(fs vfs.Filesystem, oldpath, newpath string) error {
+	if err := fs.Rename(oldpath, newpath); err != nil {
+		return err
+	}
+	if _, err := fs.Lstat(newpath); !os.IsNotExist(err) {
+		return err
+	}
+	if err := copyAll(fs, oldpath, newpath); err != nil {
+		vfs.RemoveAll(fs, newpath)
+		return err
+	}
+	return vfs.RemoveAll(fs, oldpath)
+}
+
+// copyAll copies the file or directory tree src to dst.
+func copyAll(fs vfs.Filesystem, src, dst string) error {
+	fi, err := fs.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		if err := fs.Mkdir(dst, fi.Mode().Perm()); err != nil {
+			return err
+		}
+		fis, err := fs.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			if err := copyAll(fs, filepath.Join(src, fi.Name()), filepath.Join(dst, fi.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	in, err := fs.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := fs.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
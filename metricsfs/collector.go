@@ -0,0 +1,228 @@
+package metricsfs
+
+import (
+	"io"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Observation describes a single completed operation.
+type Observation struct {
+	// Mount is the label of the instrumented filesystem, e.g. the mount path inside mountfs.
+	Mount string
+	// Op is the name of the operation, see the Op* constants.
+	Op string
+	// Duration is the time spent inside the wrapped filesystem or file.
+	Duration time.Duration
+	// Bytes is the number of bytes transferred by read and write operations.
+	Bytes int64
+	// Err is the error returned by the operation, if any.
+	Err error
+}
+
+// Collector receives an Observation for every instrumented operation.
+// Implementations must be safe for concurrent use.
+type Collector interface {
+	Observe(o Observation)
+}
+
+// Operations recorded by the instrumented Filesystem and File.
+const (
+	OpOpen     = "open"
+	OpRemove   = "remove"
+	OpRename   = "rename"
+	OpMkdir    = "mkdir"
+	OpStat     = "stat"
+	OpLstat    = "lstat"
+	OpReadDir  = "readdir"
+	OpRead     = "read"
+	OpReadAt   = "readat"
+	OpWrite    = "write"
+	OpSeek     = "seek"
+	OpSync     = "sync"
+	OpTruncate = "truncate"
+	OpFileStat = "fstat"
+	OpClose    = "close"
+)
+
+// Error classes returned by ErrorClass.
+const (
+	ErrClassNone       = ""
+	ErrClassNotExist   = "not_exist"
+	ErrClassExist      = "exist"
+	ErrClassPermission = "permission"
+	ErrClassTimeout    = "timeout"
+	ErrClassOther      = "other"
+)
+
+// ErrorClass maps an error to a coarse class suitable as a metric label.
+// io.EOF is not considered an error.
+func ErrorClass(err error) string {
+	switch {
+	case err == nil, err == io.EOF:
+		return ErrClassNone
+	case os.IsNotExist(err):
+		return ErrClassNotExist
+	case os.IsExist(err):
+		return ErrClassExist
+	case os.IsPermission(err):
+		return ErrClassPermission
+	case os.IsTimeout(err):
+		return ErrClassTimeout
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return ErrClassTimeout
+	}
+	return ErrClassOther
+}
+
+// DefaultBuckets are the upper bounds in seconds of the latency histogram buckets.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry is a Collector aggregating observations in memory.
+// Its contents can be exported with WritePrometheus or published with expvar.
+type Registry struct {
+	buckets []float64
+
+	mutex sync.Mutex
+	ops   map[opKey]*OpStats
+	bytes map[string]*ByteStats
+}
+
+type opKey struct {
+	mount, op string
+}
+
+// OpStats contains the aggregated metrics of one operation on one mount.
+type OpStats struct {
+	Mount  string
+	Op     string
+	Count  int64
+	Errors map[string]int64
+	// Sum is the total time spent in seconds.
+	Sum float64
+	// Buckets holds cumulative counts per upper bound of the Registry buckets.
+	Buckets []int64
+}
+
+// ByteStats contains the number of bytes transferred on one mount.
+type ByteStats struct {
+	Mount   string
+	Read    int64
+	Written int64
+}
+
+// NewRegistry creates a Registry using the given histogram bucket bounds in seconds.
+// If no buckets are given, DefaultBuckets are used.
+func NewRegistry(buckets ...float64) *Registry {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	b := make([]float64, len(buckets))
+	copy(b, buckets)
+	sort.Float64s(b)
+	return &Registry{
+		buckets: b,
+		ops:     make(map[opKey]*OpStats),
+		bytes:   make(map[string]*ByteStats),
+	}
+}
+
+// Observe implements Collector.
+func (r *Registry) Observe(o Observation) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	k := opKey{o.Mount, o.Op}
+	s, ok := r.ops[k]
+	if !ok {
+		s = &OpStats{
+			Mount:   o.Mount,
+			Op:      o.Op,
+			Errors:  make(map[string]int64),
+			Buckets: make([]int64, len(r.buckets)),
+		}
+		r.ops[k] = s
+	}
+	s.Count++
+	if class := ErrorClass(o.Err); class != ErrClassNone {
+		s.Errors[class]++
+	}
+	secs := o.Duration.Seconds()
+	s.Sum += secs
+	for i, le := range r.buckets {
+		if secs <= le {
+			s.Buckets[i]++
+		}
+	}
+
+	if o.Bytes > 0 {
+		b, ok := r.bytes[o.Mount]
+		if !ok {
+			b = &ByteStats{Mount: o.Mount}
+			r.bytes[o.Mount] = b
+		}
+		switch o.Op {
+		case OpRead, OpReadAt:
+			b.Read += o.Bytes
+		case OpWrite:
+			b.Written += o.Bytes
+		}
+	}
+}
+
+// Buckets returns the upper bounds of the latency histogram in seconds.
+func (r *Registry) Buckets() []float64 {
+	b := make([]float64, len(r.buckets))
+	copy(b, r.buckets)
+	return b
+}
+
+// Ops returns a copy of the operation statistics sorted by mount and operation.
+func (r *Registry) Ops() []OpStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stats := make([]OpStats, 0, len(r.ops))
+	for _, s := range r.ops {
+		c := *s
+		c.Errors = make(map[string]int64, len(s.Errors))
+		for k, v := range s.Errors {
+			c.Errors[k] = v
+		}
+		c.Buckets = make([]int64, len(s.Buckets))
+		copy(c.Buckets, s.Buckets)
+		stats = append(stats, c)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Mount != stats[j].Mount {
+			return stats[i].Mount < stats[j].Mount
+		}
+		return stats[i].Op < stats[j].Op
+	})
+	return stats
+}
+
+// Bytes returns a copy of the byte counters sorted by mount.
+func (r *Registry) Bytes() []ByteStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stats := make([]ByteStats, 0, len(r.bytes))
+	for _, b := range r.bytes {
+		stats = append(stats, *b)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Mount < stats[j].Mount })
+	return stats
+}
+
+// Reset drops all collected metrics.
+func (r *Registry) Reset() {
+	r.mutex.Lock()
+	r.ops = make(map[opKey]*OpStats)
+	r.bytes = make(map[string]*ByteStats)
+	r.mutex.Unlock()
+}
@@ -0,0 +1,98 @@
+package metricsfs
+
+import (
+	"bufio"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WritePrometheus writes all metrics in the Prometheus text exposition format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	ops := r.Ops()
+	bytes := r.Bytes()
+
+	fmt.Fprintln(bw, "# HELP vfs_operations_total Number of filesystem operations.")
+	fmt.Fprintln(bw, "# TYPE vfs_operations_total counter")
+	for _, s := range ops {
+		fmt.Fprintf(bw, "vfs_operations_total{%s} %d\n", labels("mount", s.Mount, "op", s.Op), s.Count)
+	}
+
+	fmt.Fprintln(bw, "# HELP vfs_operation_errors_total Number of failed filesystem operations by error class.")
+	fmt.Fprintln(bw, "# TYPE vfs_operation_errors_total counter")
+	for _, s := range ops {
+		classes := make([]string, 0, len(s.Errors))
+		for class := range s.Errors {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			fmt.Fprintf(bw, "vfs_operation_errors_total{%s} %d\n", labels("mount", s.Mount, "op", s.Op, "class", class), s.Errors[class])
+		}
+	}
+
+	fmt.Fprintln(bw, "# HELP vfs_operation_duration_seconds Latency of filesystem operations.")
+	fmt.Fprintln(bw, "# TYPE vfs_operation_duration_seconds histogram")
+	for _, s := range ops {
+		for i, le := range r.buckets {
+			fmt.Fprintf(bw, "vfs_operation_duration_seconds_bucket{%s} %d\n",
+				labels("mount", s.Mount, "op", s.Op, "le", strconv.FormatFloat(le, 'g', -1, 64)), s.Buckets[i])
+		}
+		fmt.Fprintf(bw, "vfs_operation_duration_seconds_bucket{%s} %d\n", labels("mount", s.Mount, "op", s.Op, "le", "+Inf"), s.Count)
+		fmt.Fprintf(bw, "vfs_operation_duration_seconds_sum{%s} %g\n", labels("mount", s.Mount, "op", s.Op), s.Sum)
+		fmt.Fprintf(bw, "vfs_operation_duration_seconds_count{%s} %d\n", labels("mount", s.Mount, "op", s.Op), s.Count)
+	}
+
+	fmt.Fprintln(bw, "# HELP vfs_read_bytes_total Number of bytes read from files.")
+	fmt.Fprintln(bw, "# TYPE vfs_read_bytes_total counter")
+	for _, b := range bytes {
+		fmt.Fprintf(bw, "vfs_read_bytes_total{%s} %d\n", labels("mount", b.Mount), b.Read)
+	}
+	fmt.Fprintln(bw, "# HELP vfs_written_bytes_total Number of bytes written to files.")
+	fmt.Fprintln(bw, "# TYPE vfs_written_bytes_total counter")
+	for _, b := range bytes {
+		fmt.Fprintf(bw, "vfs_written_bytes_total{%s} %d\n", labels("mount", b.Mount), b.Written)
+	}
+
+	return bw.Flush()
+}
+
+// labels formats key value pairs as a Prometheus label set.
+func labels(kv ...string) string {
+	parts := make([]string, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		parts = append(parts, kv[i]+"="+strconv.Quote(kv[i+1]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WritePrometheus(w)
+}
+
+// String returns the metrics encoded as JSON and implements expvar.Var.
+func (r *Registry) String() string {
+	b, err := json.Marshal(struct {
+		Buckets []float64
+		Ops     []OpStats
+		Bytes   []ByteStats
+	}{r.Buckets(), r.Ops(), r.Bytes()})
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// Publish publishes the registry with the given name in expvar.
+// Like expvar.Publish it panics if the name is already registered.
+func (r *Registry) Publish(name string) {
+	expvar.Publish(name, r)
+}
@@ -0,0 +1,170 @@
+// Package metricsfs provides a vfs.Filesystem wrapper which records
+// counts, latencies, transferred bytes and errors of every operation.
+package metricsfs
+
+import (
+	"os"
+	"time"
+
+	"github.com/alexsnet/vfs"
+	"github.com/alexsnet/vfs/mountfs"
+)
+
+// A FS that reports every vfs.Filesystem and vfs.File operation to a Collector.
+type FS struct {
+	vfs.Filesystem
+
+	// Collector receives the observations.
+	Collector Collector
+	// Mount is used as the mount label of all observations.
+	Mount string
+}
+
+// Create returns an instrumented file system forwarding to root.
+// The given mount is used as label for all observations.
+func Create(root vfs.Filesystem, c Collector, mount string) *FS {
+	return &FS{root, c, mount}
+}
+
+// InstrumentMounts wraps the root and every mounted filesystem of m.
+// Observations are labeled with the mount path.
+func InstrumentMounts(m *mountfs.MountFS, c Collector) {
+	for path, mount := range m.Mounts() {
+		m.Mount(Create(mount, c, path), path)
+	}
+}
+
+func (fs *FS) observe(op string, start time.Time, n int64, err error) {
+	fs.Collector.Observe(Observation{
+		Mount:    fs.Mount,
+		Op:       op,
+		Duration: time.Since(start),
+		Bytes:    n,
+		Err:      err,
+	})
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return fs.Filesystem.PathSeparator() }
+
+// OpenFile implements vfs.Filesystem.
+// The returned file reports its operations as well.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	start := time.Now()
+	f, err := fs.Filesystem.OpenFile(name, flag, perm)
+	fs.observe(OpOpen, start, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &file{File: f, fs: fs}, nil
+}
+
+// Remove implements vfs.Filesystem.
+func (fs *FS) Remove(name string) error {
+	start := time.Now()
+	err := fs.Filesystem.Remove(name)
+	fs.observe(OpRemove, start, 0, err)
+	return err
+}
+
+// Rename implements vfs.Filesystem.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	start := time.Now()
+	err := fs.Filesystem.Rename(oldpath, newpath)
+	fs.observe(OpRename, start, 0, err)
+	return err
+}
+
+// Mkdir implements vfs.Filesystem.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	start := time.Now()
+	err := fs.Filesystem.Mkdir(name, perm)
+	fs.observe(OpMkdir, start, 0, err)
+	return err
+}
+
+// Stat implements vfs.Filesystem.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	start := time.Now()
+	fi, err := fs.Filesystem.Stat(name)
+	fs.observe(OpStat, start, 0, err)
+	return fi, err
+}
+
+// Lstat implements vfs.Filesystem.
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	start := time.Now()
+	fi, err := fs.Filesystem.Lstat(name)
+	fs.observe(OpLstat, start, 0, err)
+	return fi, err
+}
+
+// ReadDir implements vfs.Filesystem.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	start := time.Now()
+	fis, err := fs.Filesystem.ReadDir(path)
+	fs.observe(OpReadDir, start, 0, err)
+	return fis, err
+}
+
+// file reports all operations to the Collector of its filesystem.
+type file struct {
+	vfs.File
+	fs *FS
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := f.File.Read(p)
+	f.fs.observe(OpRead, start, int64(n), err)
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := f.File.ReadAt(p, off)
+	f.fs.observe(OpReadAt, start, int64(n), err)
+	return n, err
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := f.File.Write(p)
+	f.fs.observe(OpWrite, start, int64(n), err)
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	start := time.Now()
+	n, err := f.File.Seek(offset, whence)
+	f.fs.observe(OpSeek, start, 0, err)
+	return n, err
+}
+
+func (f *file) Sync() error {
+	start := time.Now()
+	err := f.File.Sync()
+	f.fs.observe(OpSync, start, 0, err)
+	return err
+}
+
+func (f *file) Truncate(size int64) error {
+	start := time.Now()
+	err := f.File.Truncate(size)
+	f.fs.observe(OpTruncate, start, 0, err)
+	return err
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	start := time.Now()
+	fi, err := f.File.Stat()
+	f.fs.observe(OpFileStat, start, 0, err)
+	return fi, err
+}
+
+func (f *file) Close() error {
+	start := time.Now()
+	err := f.File.Close()
+	f.fs.observe(OpClose, start, 0, err)
+	return err
+}
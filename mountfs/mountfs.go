@@ -30,7 +30,8 @@ func Create(rootFS vfs.Filesystem) *MountFS {
 // Only filesystems with the same path separator are compatible.
 // It's not possible to mount a specific source directory, only the
 // root of the filesystem can be mounted, use a chroot in this case.
-// The resulting filesystem is case-sensitive.
+// The resulting filesystem is case-sensitive, wrap it in a foldfs.FS
+// to resolve names, including mount paths, case-insensitively.
 type MountFS struct {
 	rootFS  vfs.Filesystem
 	mounts  map[string]vfs.Filesystem
@@ -61,11 +62,26 @@ func (fs *MountFS) Mount(mount vfs.Filesystem, path string) error {
 	if parent == "" {
 		parent = "/"
 	}
-	fs.parents[parent] = append(fs.parents[parent], path)
+	if _, ok := fs.mounts[path]; !ok {
+		fs.parents[parent] = append(fs.parents[parent], path)
+	}
 	fs.mounts[path] = mount
 	return nil
 }
 
+// Mounts returns a copy of the mount table keyed by mount path.
+// The root filesystem is included with the path `/`.
+// Passing an entry back to Mount replaces the filesystem on that path,
+// which allows wrapping every mounted filesystem.
+func (fs *MountFS) Mounts() map[string]vfs.Filesystem {
+	mounts := make(map[string]vfs.Filesystem, len(fs.mounts)+1)
+	for path, mount := range fs.mounts {
+		mounts[path] = mount
+	}
+	mounts["/"] = fs.rootFS
+	return mounts
+}
+
 // PathSeparator returns the path separator
 func (fs MountFS) PathSeparator() uint8 {
 	return fs.rootFS.PathSeparator()
@@ -3,6 +3,7 @@ package vfs
 import (
 	"io/ioutil"
 	"os"
+	"time"
 )
 
 // OsFS represents a filesystem backed by the filesystem of the underlying OS.
@@ -52,3 +53,28 @@ func (fs OsFS) Lstat(name string) (os.FileInfo, error) {
 func (fs OsFS) ReadDir(path string) ([]os.FileInfo, error) {
 	return ioutil.ReadDir(path)
 }
+
+// Symlink wraps os.Symlink
+func (fs OsFS) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+// Link wraps os.Link
+func (fs OsFS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+// Readlink wraps os.Readlink
+func (fs OsFS) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+// Chmod wraps os.Chmod
+func (fs OsFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+// Chtimes wraps os.Chtimes
+func (fs OsFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
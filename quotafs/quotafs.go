@@ -0,0 +1,327 @@
+// Package quotafs provides a vfs.Filesystem wrapper which enforces
+// limits on the total size, the number of files and the size of single files.
+package quotafs
+
+import (
+	"os"
+	filepath "path"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/alexsnet/vfs"
+)
+
+// ErrNoSpace is returned, wrapped in an *os.PathError, if an operation would exceed a limit.
+// It is syscall.ENOSPC, so callers can handle it like a full disk.
+var ErrNoSpace error = syscall.ENOSPC
+
+// Limits of a quota filesystem. Zero values mean unlimited.
+type Limits struct {
+	// MaxBytes is the maximum total size of all files.
+	MaxBytes int64
+	// MaxFiles is the maximum number of regular files.
+	MaxFiles int
+	// MaxFileSize is the maximum size of a single file.
+	MaxFileSize int64
+}
+
+// Usage is the accounted usage of a quota filesystem.
+type Usage struct {
+	Bytes int64
+	Files int
+}
+
+// A FS that enforces Limits on the wrapped filesystem.
+//
+// Only files written through the FS or found by Rescan are accounted,
+// an existing tree should be scanned once after Create.
+// Files not known yet are accounted with their current size when opened.
+type FS struct {
+	vfs.Filesystem
+
+	Limits Limits
+
+	mutex sync.Mutex
+	sizes map[string]int64
+	bytes int64
+}
+
+// Create returns a file system forwarding to root which enforces limits.
+func Create(root vfs.Filesystem, limits Limits) *FS {
+	return &FS{
+		Filesystem: root,
+		Limits:     limits,
+		sizes:      make(map[string]int64),
+	}
+}
+
+// key returns the absolute clean path used for accounting.
+func key(name string) string {
+	return filepath.Clean("/" + name)
+}
+
+// Usage returns the current usage.
+func (fs *FS) Usage() Usage {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return Usage{Bytes: fs.bytes, Files: len(fs.sizes)}
+}
+
+// Rescan drops the accounted usage and walks the whole tree to rebuild it.
+func (fs *FS) Rescan() error {
+	sizes := make(map[string]int64)
+	var bytes int64
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		fis, err := fs.Filesystem.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			p := filepath.Join(dir, fi.Name())
+			if fi.IsDir() {
+				if err := walk(p); err != nil {
+					return err
+				}
+				continue
+			}
+			sizes[p] = fi.Size()
+			bytes += fi.Size()
+		}
+		return nil
+	}
+	if err := walk("/"); err != nil {
+		return err
+	}
+
+	fs.mutex.Lock()
+	fs.sizes = sizes
+	fs.bytes = bytes
+	fs.mutex.Unlock()
+	return nil
+}
+
+// lookup returns the accounted size of the file and whether it exists.
+// Unknown files are looked up in the wrapped filesystem and accounted.
+func (fs *FS) lookup(name string) (int64, bool) {
+	k := key(name)
+	fs.mutex.Lock()
+	size, ok := fs.sizes[k]
+	fs.mutex.Unlock()
+	if ok {
+		return size, true
+	}
+
+	fi, err := fs.Filesystem.Stat(name)
+	if err != nil || fi.IsDir() {
+		return 0, false
+	}
+	fs.mutex.Lock()
+	if _, ok := fs.sizes[k]; !ok {
+		fs.sizes[k] = fi.Size()
+		fs.bytes += fi.Size()
+	}
+	size = fs.sizes[k]
+	fs.mutex.Unlock()
+	return size, true
+}
+
+// grow reserves space to extend the file to end bytes.
+// It returns the end which fits the limits, which is less than end if err is not nil.
+func (fs *FS) grow(name string, end int64) (int64, error) {
+	k := key(name)
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	size := fs.sizes[k]
+	if end <= size {
+		return end, nil
+	}
+	allowed := end
+	if max := fs.Limits.MaxFileSize; max > 0 && allowed > max {
+		allowed = max
+	}
+	if max := fs.Limits.MaxBytes; max > 0 && fs.bytes+allowed-size > max {
+		allowed = max - fs.bytes + size
+	}
+	if allowed < size {
+		allowed = size
+	}
+	fs.bytes += allowed - size
+	fs.sizes[k] = allowed
+	if allowed < end {
+		return allowed, ErrNoSpace
+	}
+	return end, nil
+}
+
+// resize sets the accounted size of the file without checking any limit.
+func (fs *FS) resize(name string, size int64) {
+	k := key(name)
+	fs.mutex.Lock()
+	fs.bytes += size - fs.sizes[k]
+	fs.sizes[k] = size
+	fs.mutex.Unlock()
+}
+
+// forget drops the accounting of name and everything below it.
+func (fs *FS) forget(name string) {
+	k := key(name)
+	fs.mutex.Lock()
+	for p, size := range fs.sizes {
+		if p == k || strings.HasPrefix(p, k+"/") || k == "/" {
+			fs.bytes -= size
+			delete(fs.sizes, p)
+		}
+	}
+	fs.mutex.Unlock()
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return fs.Filesystem.PathSeparator() }
+
+// OpenFile implements vfs.Filesystem.
+// Creating a file fails with ErrNoSpace if the file count limit is reached.
+// Writes to the returned file fail with ErrNoSpace if they exceed a size limit.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	size, exists := fs.lookup(name)
+	if !exists && flag&os.O_CREATE == os.O_CREATE {
+		fs.mutex.Lock()
+		full := fs.Limits.MaxFiles > 0 && len(fs.sizes) >= fs.Limits.MaxFiles
+		fs.mutex.Unlock()
+		if full {
+			return nil, &os.PathError{Op: "open", Path: name, Err: ErrNoSpace}
+		}
+	}
+
+	f, err := fs.Filesystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_TRUNC == os.O_TRUNC {
+		size = 0
+	}
+	// Directories are never accounted and can only be opened without O_CREATE.
+	if exists || flag&os.O_CREATE == os.O_CREATE {
+		fs.resize(name, size)
+	}
+
+	qf := &file{File: f, fs: fs, name: name, append: flag&os.O_APPEND == os.O_APPEND}
+	if qf.append {
+		qf.pos = size
+	}
+	return qf, nil
+}
+
+// Remove implements vfs.Filesystem.
+func (fs *FS) Remove(name string) error {
+	if err := fs.Filesystem.Remove(name); err != nil {
+		return err
+	}
+	fs.forget(name)
+	return nil
+}
+
+// Rename implements vfs.Filesystem.
+// The accounting of oldpath and everything below it is moved to newpath,
+// replacing the accounting of newpath and everything below it.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	if err := fs.Filesystem.Rename(oldpath, newpath); err != nil {
+		return err
+	}
+
+	oldKey, newKey := key(oldpath), key(newpath)
+	fs.mutex.Lock()
+	for p, size := range fs.sizes {
+		if p == oldKey || strings.HasPrefix(p, oldKey+"/") {
+			continue
+		}
+		if p == newKey || strings.HasPrefix(p, newKey+"/") {
+			fs.bytes -= size
+			delete(fs.sizes, p)
+		}
+	}
+	for p, size := range fs.sizes {
+		if p == oldKey {
+			delete(fs.sizes, p)
+			fs.sizes[newKey] = size
+		} else if strings.HasPrefix(p, oldKey+"/") {
+			delete(fs.sizes, p)
+			fs.sizes[newKey+strings.TrimPrefix(p, oldKey)] = size
+		}
+	}
+	fs.mutex.Unlock()
+	return nil
+}
+
+// file accounts writes and truncates with the quota of its filesystem.
+type file struct {
+	vfs.File
+	fs   *FS
+	name string
+	pos  int64
+	// append files are written at their end, whatever pos is.
+	append bool
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.pos = pos
+	}
+	return pos, err
+}
+
+// Write writes as much of p as the limits allow.
+// It returns ErrNoSpace if p was not written completely due to a limit.
+func (f *file) Write(p []byte) (int, error) {
+	before, _ := f.fs.lookup(f.name)
+	if f.append {
+		f.pos = before
+	}
+	end, quotaErr := f.fs.grow(f.name, f.pos+int64(len(p)))
+	if allowed := end - f.pos; allowed < int64(len(p)) {
+		if allowed < 0 {
+			allowed = 0
+		}
+		p = p[:allowed]
+	}
+
+	n, err := f.File.Write(p)
+	f.pos += int64(n)
+	if n < len(p) {
+		size := before
+		if f.pos > size {
+			size = f.pos
+		}
+		f.fs.resize(f.name, size)
+	}
+	if err == nil && quotaErr != nil {
+		err = &os.PathError{Op: "write", Path: f.name, Err: quotaErr}
+	}
+	return n, err
+}
+
+// Truncate fails with ErrNoSpace if growing the file exceeds a limit.
+func (f *file) Truncate(size int64) error {
+	before, _ := f.fs.lookup(f.name)
+	if size > before {
+		if _, err := f.fs.grow(f.name, size); err != nil {
+			f.fs.resize(f.name, before)
+			return &os.PathError{Op: "truncate", Path: f.name, Err: err}
+		}
+	}
+	if err := f.File.Truncate(size); err != nil {
+		f.fs.resize(f.name, before)
+		return err
+	}
+	f.fs.resize(f.name, size)
+	return nil
+}
@@ -1,8 +1,10 @@
 package vfs
 
 import (
-	"errors"
+	"io"
 	"os"
+	"syscall"
+	"time"
 )
 
 // ReadOnly creates a readonly wrapper around the given filesystem.
@@ -12,10 +14,14 @@ import (
 // 	- Remove
 // 	- Rename
 // 	- Mkdir
+// 	- Chmod, Chown, Chtimes, Symlink, Link and Truncate
 //
-// And disables OpenFile flags: os.O_CREATE, os.O_APPEND, os.O_WRONLY
+// And disables OpenFile flags: os.O_CREATE, os.O_APPEND, os.O_WRONLY,
+// os.O_RDWR and os.O_TRUNC
 //
-// OpenFile returns a File with disabled Write() method otherwise.
+// OpenFile returns a File with disabled Write(), WriteAt(), ReadFrom(),
+// Truncate() and Sync() methods otherwise.
+// Every disabled operation returns a *os.PathError wrapping ErrReadOnly.
 func ReadOnly(fs Filesystem) *RoFS {
 	return &RoFS{Filesystem: fs}
 }
@@ -26,44 +32,78 @@ type RoFS struct {
 	Filesystem
 }
 
-// ErrorReadOnly is returned on every disabled operation.
-var ErrReadOnly = errors.New("Filesystem is read-only")
+// ErrReadOnly is returned on every disabled operation.
+// It is syscall.EROFS, so errors.Is(err, syscall.EROFS) holds as well.
+var ErrReadOnly error = syscall.EROFS
 
-// Remove is disabled and returns ErrorReadOnly
+// writeFlags are the OpenFile flags rejected by RoFS.
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_APPEND | os.O_CREATE | os.O_TRUNC
+
+func readOnlyError(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: ErrReadOnly}
+}
+
+// Remove is disabled and returns ErrReadOnly
 func (fs RoFS) Remove(name string) error {
-	return ErrReadOnly
+	return readOnlyError("remove", name)
 }
 
-// Rename is disabled and returns ErrorReadOnly
+// Rename is disabled and returns ErrReadOnly
 func (fs RoFS) Rename(oldpath, newpath string) error {
-	return ErrReadOnly
+	return readOnlyError("rename", oldpath)
 }
 
-// Mkdir is disabled and returns ErrorReadOnly
+// Mkdir is disabled and returns ErrReadOnly
 func (fs RoFS) Mkdir(name string, perm os.FileMode) error {
-	return ErrReadOnly
+	return readOnlyError("mkdir", name)
+}
+
+// Chmod is disabled and returns ErrReadOnly
+func (fs RoFS) Chmod(name string, mode os.FileMode) error {
+	return readOnlyError("chmod", name)
+}
+
+// Chown is disabled and returns ErrReadOnly
+func (fs RoFS) Chown(name string, uid, gid int) error {
+	return readOnlyError("chown", name)
+}
+
+// Chtimes is disabled and returns ErrReadOnly
+func (fs RoFS) Chtimes(name string, atime, mtime time.Time) error {
+	return readOnlyError("chtimes", name)
+}
+
+// Symlink is disabled and returns ErrReadOnly
+func (fs RoFS) Symlink(oldname, newname string) error {
+	return readOnlyError("symlink", newname)
+}
+
+// Link is disabled and returns ErrReadOnly
+func (fs RoFS) Link(oldname, newname string) error {
+	return readOnlyError("link", newname)
+}
+
+// Truncate is disabled and returns ErrReadOnly
+func (fs RoFS) Truncate(name string, size int64) error {
+	return readOnlyError("truncate", name)
 }
 
-// OpenFile returns ErrorReadOnly if flag contains os.O_CREATE, os.O_APPEND, os.O_WRONLY.
-// Otherwise it returns a read-only File with disabled Write(..) operation.
+// OpenFile returns ErrReadOnly if flag contains os.O_CREATE, os.O_APPEND,
+// os.O_WRONLY, os.O_RDWR or os.O_TRUNC.
+// Otherwise it returns a read-only File with disabled write operations.
 func (fs RoFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
-	if flag&os.O_CREATE == os.O_CREATE {
-		return nil, ErrReadOnly
-	}
-	if flag&os.O_APPEND == os.O_APPEND {
-		return nil, ErrReadOnly
-	}
-	if flag&os.O_WRONLY == os.O_WRONLY {
-		return nil, ErrReadOnly
+	if flag&writeFlags != 0 {
+		return nil, readOnlyError("open", name)
 	}
 	f, err := fs.Filesystem.OpenFile(name, flag, perm)
 	if err != nil {
-		return ReadOnlyFile(f), err
+		return nil, err
 	}
 	return ReadOnlyFile(f), nil
 }
 
-// ReadOnlyFile wraps the given file and disables Write(..) operation.
+// ReadOnlyFile wraps the given file and disables all write operations.
+// Optional interfaces of the wrapped file, like io.WriterAt, are hidden.
 func ReadOnlyFile(f File) File {
 	return &roFile{f}
 }
@@ -72,7 +112,42 @@ type roFile struct {
 	File
 }
 
-// Write is disabled and returns ErrorReadOnly
+// Write is disabled and returns ErrReadOnly
 func (f roFile) Write(p []byte) (n int, err error) {
-	return 0, ErrReadOnly
+	return 0, readOnlyError("write", f.Name())
+}
+
+// WriteAt is disabled and returns ErrReadOnly
+func (f roFile) WriteAt(p []byte, off int64) (n int, err error) {
+	return 0, readOnlyError("write", f.Name())
+}
+
+// WriteString is disabled and returns ErrReadOnly
+func (f roFile) WriteString(s string) (n int, err error) {
+	return 0, readOnlyError("write", f.Name())
+}
+
+// ReadFrom is disabled and returns ErrReadOnly
+func (f roFile) ReadFrom(r io.Reader) (n int64, err error) {
+	return 0, readOnlyError("write", f.Name())
+}
+
+// Truncate is disabled and returns ErrReadOnly
+func (f roFile) Truncate(size int64) error {
+	return readOnlyError("truncate", f.Name())
+}
+
+// Sync does nothing, there is nothing to flush on a read-only file.
+func (f roFile) Sync() error {
+	return nil
+}
+
+// Chmod is disabled and returns ErrReadOnly
+func (f roFile) Chmod(mode os.FileMode) error {
+	return readOnlyError("chmod", f.Name())
+}
+
+// Chown is disabled and returns ErrReadOnly
+func (f roFile) Chown(uid, gid int) error {
+	return readOnlyError("chown", f.Name())
 }
@@ -0,0 +1,165 @@
+package retryfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Event describes a failed attempt which is going to be retried.
+type Event struct {
+	// Op is the retried operation.
+	Op string
+	// Attempt is the one based number of the failed attempt.
+	Attempt int
+	// Err is the error of the failed attempt.
+	Err error
+	// Delay is the time waited before the next attempt.
+	Delay time.Duration
+	// Elapsed is the time since the first attempt started.
+	Elapsed time.Duration
+}
+
+// Policy configures how often and when failed operations are retried.
+// The zero value does not retry.
+type Policy struct {
+	// MaxAttempts is the maximum number of attempts including the first, at least 1.
+	MaxAttempts int
+	// InitialInterval is the delay after the first failed attempt.
+	InitialInterval time.Duration
+	// MaxInterval caps the delay between attempts, 0 means no cap.
+	MaxInterval time.Duration
+	// Multiplier is applied to the delay after each failed attempt, 2 if below 1.
+	Multiplier float64
+	// Jitter randomly shortens each delay by up to this fraction, 0 to 1.
+	Jitter float64
+	// MaxElapsed stops retrying once the total time would exceed it, 0 means no limit.
+	MaxElapsed time.Duration
+	// Retryable classifies errors, IsRetryable is used if nil.
+	Retryable func(err error) bool
+	// OnRetry is called before waiting for the next attempt, it may be nil.
+	OnRetry func(e Event)
+}
+
+// DefaultPolicy returns a policy making up to 5 attempts
+// with delays from 100ms up to 5s and a total limit of 30s.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MaxAttempts:     5,
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     5 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.5,
+		MaxElapsed:      30 * time.Second,
+	}
+}
+
+// Backoff returns the delay after the given one based failed attempt, including jitter.
+func (p *Policy) Backoff(attempt int) time.Duration {
+	m := p.Multiplier
+	if m < 1 {
+		m = 2
+	}
+	d := float64(p.InitialInterval) * math.Pow(m, float64(attempt-1))
+	if p.MaxInterval > 0 && d > float64(p.MaxInterval) {
+		d = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		d -= d * math.Min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// Wait decides whether a failed attempt of op is retried.
+// If so, it calls the OnRetry hook, sleeps for the backoff delay and returns true.
+// start is the time the first attempt started.
+// It returns false without retrying once ctx is done, also while sleeping.
+func (p *Policy) Wait(ctx context.Context, op string, attempt int, start time.Time, err error) bool {
+	if p == nil || attempt >= p.MaxAttempts || ctx.Err() != nil {
+		return false
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	if !retryable(err) {
+		return false
+	}
+	delay := p.Backoff(attempt)
+	elapsed := time.Since(start)
+	if p.MaxElapsed > 0 && elapsed+delay > p.MaxElapsed {
+		return false
+	}
+	if p.OnRetry != nil {
+		p.OnRetry(Event{Op: op, Attempt: attempt, Err: err, Delay: delay, Elapsed: elapsed})
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Do calls fn until it succeeds or the failure is not retried.
+// Operations which are not idempotent are attempted once.
+func (p *Policy) Do(op string, idempotent bool, fn func(attempt int) error) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || !idempotent || !p.Wait(context.Background(), op, attempt, start, err) {
+			return err
+		}
+	}
+}
+
+// IsRetryable reports whether err is likely transient:
+// timeouts, connection resets, HTTP 5xx and 429 status codes
+// and the S3 error codes SlowDown, RequestTimeout, InternalError and ServiceUnavailable.
+// Canceled and expired contexts are final.
+//
+// Errors may report a status code with a StatusCode() int method
+// and an error code with a Code() string method.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if os.IsNotExist(err) || os.IsExist(err) || os.IsPermission(err) {
+		return false
+	}
+
+	var code interface{ Code() string }
+	if errors.As(err, &code) {
+		switch code.Code() {
+		case "SlowDown", "RequestTimeout", "InternalError",
+			"ServiceUnavailable", "Throttling", "ThrottlingException":
+			return true
+		}
+	}
+	var status interface{ StatusCode() int }
+	if errors.As(err, &status) {
+		c := status.StatusCode()
+		return c >= 500 || c == 429 || c == 408
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for _, e := range []error{syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ECONNABORTED,
+		syscall.EPIPE, syscall.ETIMEDOUT, io.ErrUnexpectedEOF} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	var temp interface{ Temporary() bool }
+	return errors.As(err, &temp) && temp.Temporary()
+}
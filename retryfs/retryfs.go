@@ -0,0 +1,151 @@
+// Package retryfs provides a vfs.Filesystem wrapper which retries
+// operations failing with transient errors using exponential backoff.
+package retryfs
+
+import (
+	"os"
+
+	"github.com/alexsnet/vfs"
+)
+
+// Operations passed to Policy.Wait and reported in Event.Op.
+const (
+	OpOpen    = "open"
+	OpRemove  = "remove"
+	OpRename  = "rename"
+	OpMkdir   = "mkdir"
+	OpStat    = "stat"
+	OpLstat   = "lstat"
+	OpReadDir = "readdir"
+	OpRead    = "read"
+	OpReadAt  = "readat"
+	OpSync    = "sync"
+)
+
+// A FS that retries idempotent operations according to a Policy.
+//
+// Lookups, listings, opening without O_EXCL, Remove, Mkdir and reads are retried.
+// A retried Remove failing with os.ErrNotExist, or a retried Mkdir failing with
+// os.ErrExist, is treated as success, as an earlier attempt may have succeeded.
+// Rename and writes are not idempotent and are attempted once.
+type FS struct {
+	vfs.Filesystem
+
+	Policy *Policy
+}
+
+// Create returns a retrying file system forwarding to root.
+func Create(root vfs.Filesystem, p *Policy) *FS {
+	return &FS{Filesystem: root, Policy: p}
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return fs.Filesystem.PathSeparator() }
+
+// OpenFile implements vfs.Filesystem.
+// Reads of the returned file are retried as well.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	var f vfs.File
+	idempotent := flag&os.O_EXCL == 0
+	err := fs.Policy.Do(OpOpen, idempotent, func(int) (err error) {
+		f, err = fs.Filesystem.OpenFile(name, flag, perm)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &file{File: f, fs: fs}, nil
+}
+
+// Remove implements vfs.Filesystem.
+func (fs *FS) Remove(name string) error {
+	return fs.Policy.Do(OpRemove, true, func(attempt int) error {
+		err := fs.Filesystem.Remove(name)
+		if attempt > 1 && os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+// Rename implements vfs.Filesystem.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	return fs.Filesystem.Rename(oldpath, newpath)
+}
+
+// Mkdir implements vfs.Filesystem.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	return fs.Policy.Do(OpMkdir, true, func(attempt int) error {
+		err := fs.Filesystem.Mkdir(name, perm)
+		if attempt > 1 && os.IsExist(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+// Stat implements vfs.Filesystem.
+func (fs *FS) Stat(name string) (fi os.FileInfo, err error) {
+	err = fs.Policy.Do(OpStat, true, func(int) (err error) {
+		fi, err = fs.Filesystem.Stat(name)
+		return err
+	})
+	return fi, err
+}
+
+// Lstat implements vfs.Filesystem.
+func (fs *FS) Lstat(name string) (fi os.FileInfo, err error) {
+	err = fs.Policy.Do(OpLstat, true, func(int) (err error) {
+		fi, err = fs.Filesystem.Lstat(name)
+		return err
+	})
+	return fi, err
+}
+
+// ReadDir implements vfs.Filesystem.
+func (fs *FS) ReadDir(path string) (fis []os.FileInfo, err error) {
+	err = fs.Policy.Do(OpReadDir, true, func(int) (err error) {
+		fis, err = fs.Filesystem.ReadDir(path)
+		return err
+	})
+	return fis, err
+}
+
+// file retries reads which did not transfer any data.
+type file struct {
+	vfs.File
+	fs *FS
+}
+
+// Read is only retried if nothing was read, as the offset moves otherwise.
+func (f *file) Read(p []byte) (n int, err error) {
+	var rerr error
+	err = f.fs.Policy.Do(OpRead, true, func(int) error {
+		n, rerr = f.File.Read(p)
+		if n > 0 {
+			return nil
+		}
+		return rerr
+	})
+	if n > 0 {
+		return n, rerr
+	}
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (n int, err error) {
+	err = f.fs.Policy.Do(OpReadAt, true, func(int) (err error) {
+		n, err = f.File.ReadAt(p, off)
+		if n == len(p) {
+			return nil
+		}
+		return err
+	})
+	return n, err
+}
+
+func (f *file) Sync() error {
+	return f.fs.Policy.Do(OpSync, true, func(int) error {
+		return f.File.Sync()
+	})
+}
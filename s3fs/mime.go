@@ -1,5 +1,7 @@
 package s3fs
 
+import "strings"
+
 var mimeTypes = map[string]string{
 	".123":         "application/vnd.lotus-1-2-3",
 	".3dml":        "text/vnd.in3d.3dml",
@@ -819,3 +821,10 @@ var mimeTypes = map[string]string{
 	".zirz":        "application/vnd.zul",
 	".zmm":         "application/vnd.handheld-entertainment+xml",
 }
+
+// TypeByExtension returns the MIME type associated with the file extension ext,
+// which has to start with a leading dot, e.g. ".html".
+// It returns "" if the extension is unknown.
+func TypeByExtension(ext string) string {
+	return mimeTypes[strings.ToLower(ext)]
+}
@@ -1,59 +1,219 @@
 package s3fs
 
 import (
-	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 )
 
+// reader streams an object with ranged GET requests.
+// Seeking drops the current response, the next Read requests the object
+// again from the new position.
 type reader struct {
-	o           *s3file
-	buf         *bytes.Buffer
-	pos         int64
-	totalsize   int64
-	initializer sync.Once
-	err         error
+	o      *s3file
+	mutex  sync.Mutex
+	body   io.ReadCloser
+	pos    int64
+	size   int64 // -1 until known
+	closed bool
 }
 
-func (r *reader) Read(b []byte) (int, error) {
-	r.initializer.Do(func() {
-		fi, err := r.o.fs.Lstat(r.o.key)
-		if err != nil {
-			r.err = err
-			return
+func newReader(o *s3file) *reader {
+	return &reader{o: o, size: -1}
+}
+
+// get requests the object from off up to and including end, or up to the end
+// of the object if end is negative. The mutex must be held.
+// It returns io.EOF if off is beyond the end of the object.
+func (r *reader) get(off, end int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(r.o.fs.context(), "GET", r.o.fs.url(r.o.key), nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.o.versionID != "" {
+		req.URL.RawQuery = url.Values{"versionId": {r.o.versionID}}.Encode()
+	}
+	if end >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end))
+	} else if off > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	}
+	r.o.fs.signRequest(req)
+
+	resp, err := r.o.fs.do(req, "get")
+	if err != nil {
+		return nil, err
+	}
+	switch c := resp.StatusCode; c {
+	case http.StatusPartialContent:
+		if size := rangeSize(resp.Header.Get("Content-Range")); size >= 0 {
+			r.size = size
+		}
+		return resp.Body, nil
+	case http.StatusOK:
+		// The range has been ignored, skip to off.
+		if resp.ContentLength >= 0 {
+			r.size = resp.ContentLength
+		}
+		if off > 0 {
+			if _, err := io.CopyN(ioutil.Discard, resp.Body, off); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
 		}
-		r.totalsize = fi.Size()
+		return resp.Body, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		if size := rangeSize(resp.Header.Get("Content-Range")); size >= 0 {
+			r.size = size
+		}
+		return nil, io.EOF
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, &os.PathError{Op: "open", Path: r.o.key, Err: os.ErrNotExist}
+	default:
+		defer resp.Body.Close()
+		return nil, newS3Error(resp, "could not get object: %d", c)
+	}
+}
 
-		// w := int64(len(b))
-		req, err := http.NewRequest("GET", r.o.fs.url(r.o.key), nil)
+// rangeSize returns the complete length of a Content-Range header
+// such as "bytes 0-99/1234", or -1 if it is unknown.
+func rangeSize(contentRange string) int64 {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return -1
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// stat returns the size of the object, the mutex must be held.
+func (r *reader) stat() (int64, error) {
+	if r.size >= 0 {
+		return r.size, nil
+	}
+	if r.o.versionID == "" {
+		fi, err := r.o.fs.Lstat(r.o.key)
 		if err != nil {
-			r.err = err
-			return
+			return 0, err
 		}
-		// req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.pos, r.pos+w))
-		r.o.fs.signRequest(req)
+		r.size = fi.Size()
+		return r.size, nil
+	}
+	// Versions are not visible to Lstat, a one byte range reports the size.
+	body, err := r.get(0, 0)
+	if err == io.EOF {
+		if r.size < 0 {
+			r.size = 0
+		}
+		return r.size, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	body.Close()
+	return r.size, nil
+}
 
-		resp, err := r.o.fs.client.Do(req)
+func (r *reader) Read(b []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return 0, &os.PathError{Op: "read", Path: r.o.key, Err: os.ErrClosed}
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if r.body == nil {
+		if r.size >= 0 && r.pos >= r.size {
+			return 0, io.EOF
+		}
+		body, err := r.get(r.pos, -1)
 		if err != nil {
-			r.err = err
-			return
+			return 0, err
 		}
+		r.body = body
+	}
+	n, err := r.body.Read(b)
+	r.pos += int64(n)
+	return n, err
+}
 
-		defer resp.Body.Close()
+func (r *reader) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("ReadAt: negative offset")
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return 0, &os.PathError{Op: "read", Path: r.o.key, Err: os.ErrClosed}
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if r.size >= 0 && off >= r.size {
+		return 0, io.EOF
+	}
+	body, err := r.get(off, off+int64(len(b))-1)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
 
-		n, err := io.Copy(r.buf, resp.Body)
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.pos + offset
+	case io.SeekEnd:
+		size, err := r.stat()
 		if err != nil {
-			r.err = err
-			return
+			return 0, err
 		}
-		r.pos = r.pos + int64(n)
-	})
-	if r.err != nil {
-		return 0, r.err
+		abs = size + offset
+	default:
+		return 0, errors.New("Seek: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("Seek: negative position")
+	}
+	if abs != r.pos && r.body != nil {
+		r.body.Close()
+		r.body = nil
 	}
+	r.pos = abs
+	return abs, nil
+}
 
-	// if r.buf.Cap() > 0 {
-	return r.buf.Read(b)
-	// }
+func (r *reader) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closed = true
+	if r.body != nil {
+		err := r.body.Close()
+		r.body = nil
+		return err
+	}
+	return nil
 }
@@ -1,7 +1,8 @@
 package s3fs
 
 import (
-	"bytes"
+	"errors"
+	"net/http"
 	"os"
 	"path"
 	"sync"
@@ -11,6 +12,8 @@ type s3file struct {
 	fs         *S3FS
 	rwl        sync.RWMutex
 	key        string
+	flag       int
+	versionID  string
 	writer     *writer
 	reader     *reader
 	onceWriter sync.Once
@@ -36,38 +39,97 @@ func (file *s3file) Truncate(int64) error {
 	panic("not implemented")
 }
 
-func (file *s3file) Read(p []byte) (n int, err error) {
-	file.onceWriter.Do(func() {
+// openReader returns the reader of the file, created on first use.
+func (file *s3file) openReader() *reader {
+	file.onceReader.Do(func() {
 		file.rwl.Lock()
 		defer file.rwl.Unlock()
-		file.reader = &reader{
-			o:   file,
-			buf: new(bytes.Buffer),
-		}
+		file.reader = newReader(file)
 	})
-	return file.reader.Read(p)
+	return file.reader
 }
 
-func (file *s3file) ReadAt(p []byte, off int64) (n int, err error) {
-	panic("not implemented")
+func (file *s3file) Read(p []byte) (n int, err error) {
+	return file.openReader().Read(p)
+}
 
+// ReadAt reads with a ranged GET request, independent of the read position.
+func (file *s3file) ReadAt(p []byte, off int64) (n int, err error) {
+	return file.openReader().ReadAt(p, off)
 }
 
+// Seek sets the read position, files being written can not seek.
 func (file *s3file) Seek(offset int64, whence int) (int64, error) {
-	panic("not implemented")
+	file.rwl.RLock()
+	writing := file.writer != nil
+	file.rwl.RUnlock()
+	if writing {
+		return 0, errors.New("Seek: not supported while writing")
+	}
+	return file.openReader().Seek(offset, whence)
 }
 
 func (file *s3file) Write(p []byte) (n int, err error) {
 	file.onceWriter.Do(func() {
+		file.rwl.Lock()
+		defer file.rwl.Unlock()
 		file.writer = newWriter(file)
 	})
 
 	return file.writer.Write(p)
 }
 
+// Close finishes the upload of written files.
+// Files which have not been written are closed without uploading,
+// unless they have to be created empty.
 func (file *s3file) Close() error {
-	file.onceWriter.Do(func() {
-		file.writer = newWriter(file)
-	})
-	return file.writer.Close()
+	file.rwl.RLock()
+	r, w := file.reader, file.writer
+	file.rwl.RUnlock()
+
+	var err error
+	if w != nil {
+		err = w.Close()
+	}
+	if r != nil {
+		if err1 := r.Close(); err == nil {
+			err = err1
+		}
+	}
+	if err == nil && (w == nil || !w.written()) {
+		err = file.create()
+	}
+	return err
+}
+
+// create stores an empty object for unwritten files opened with os.O_TRUNC,
+// or with os.O_CREATE if the object does not exist.
+func (file *s3file) create() error {
+	switch {
+	case file.flag&os.O_TRUNC == os.O_TRUNC:
+	case file.flag&os.O_CREATE == os.O_CREATE:
+		if _, err := file.fs.Stat(file.key); !os.IsNotExist(err) {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(file.fs.context(), "PUT", file.fs.url(file.key), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(`Content-Type`, contentType(file.key))
+	file.fs.signRequest(req)
+
+	resp, err := file.fs.do(req, "put")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if c := resp.StatusCode; c != 200 {
+		return newS3Error(resp, "could not create object: %d", c)
+	}
+	return nil
 }
@@ -1,6 +1,7 @@
 package s3fs
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"net/http"
@@ -12,6 +13,8 @@ import (
 	"time"
 
 	"github.com/alexsnet/vfs"
+	"github.com/alexsnet/vfs/retryfs"
+	"github.com/alexsnet/vfs/tracefs"
 	"github.com/sirupsen/logrus"
 )
 
@@ -26,8 +29,14 @@ type S3FS struct {
 	Proto  string
 	Host   string
 
+	// Tracer receives a span for every HTTP request, it may be nil.
+	Tracer tracefs.Tracer
+	// Retry is the policy for failed idempotent requests, nil disables retries.
+	Retry *retryfs.Policy
+
 	client             *http.Client
 	concurrencyUploads int
+	ctx                context.Context
 }
 
 // Create returns a file system
@@ -38,33 +47,70 @@ func Create(bucket, accessKey, secret, host, proto string) *S3FS {
 		Secret:             secret,
 		Host:               host,
 		Proto:              proto,
+		Retry:              retryfs.DefaultPolicy(),
 		client:             http.DefaultClient,
-		concurrencyUploads: 5,
+		concurrencyUploads: nConcurrentUploads,
+	}
+}
+
+// WithContext returns a file system sharing the configuration of fs
+// whose requests carry ctx: they are canceled with it and their spans
+// are children of the span carried by ctx.
+func (fs *S3FS) WithContext(ctx context.Context) *S3FS {
+	c := *fs
+	c.ctx = ctx
+	return &c
+}
+
+// WithSpan implements tracefs.SpanFilesystem,
+// so the requests of a traced S3FS are children of the operation span.
+func (fs *S3FS) WithSpan(ctx context.Context) vfs.Filesystem {
+	return fs.WithContext(ctx)
+}
+
+// context returns the context of requests.
+func (fs *S3FS) context() context.Context {
+	if fs.ctx == nil {
+		return context.Background()
+	}
+	return fs.ctx
+}
+
+// SetConcurrencyUploads sets the maximum number of parts uploaded
+// in parallel by each file written. Values below 1 are treated as 1.
+func (fs *S3FS) SetConcurrencyUploads(n int) {
+	if n < 1 {
+		n = 1
 	}
+	fs.concurrencyUploads = n
 }
 
 // PathSeparator implements vfs.Filesystem.
 func (fs *S3FS) PathSeparator() uint8 { return '/' }
 
 // OpenFile implements vfs.Filesystem.
+// Written files are uploaded on Close. Files which are not written are
+// stored empty on Close if opened with os.O_TRUNC, or with os.O_CREATE
+// if they do not exist.
 func (fs *S3FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
-	// @TODO: make work with flags and permisions
+	// @TODO: make work with permisions
 	f := &s3file{
-		fs:  fs,
-		key: name,
+		fs:   fs,
+		key:  name,
+		flag: flag,
 	}
 	return f, nil
 }
 
 // Remove implements vfs.Filesystem.
 func (fs *S3FS) Remove(name string) error {
-	req, err := http.NewRequest("DELETE", fs.url(name), nil)
+	req, err := http.NewRequestWithContext(fs.context(), "DELETE", fs.url(name), nil)
 	if err != nil {
 		return err
 	}
 	fs.signRequest(req)
 
-	resp, err := fs.client.Do(req)
+	resp, err := fs.do(req, "delete")
 	if err != nil {
 		return err
 	}
@@ -94,13 +140,13 @@ func (fs *S3FS) Stat(name string) (os.FileInfo, error) {
 
 // Lstat implements vfs.Filesystem.
 func (fs *S3FS) Lstat(name string) (os.FileInfo, error) {
-	req, err := http.NewRequest("HEAD", fs.url(name), nil)
+	req, err := http.NewRequestWithContext(fs.context(), "HEAD", fs.url(name), nil)
 	if err != nil {
 		return nil, err
 	}
 	fs.signRequest(req)
 
-	resp, err := fs.client.Do(req)
+	resp, err := fs.do(req, "head")
 	if err != nil {
 		return nil, err
 	}
@@ -168,12 +214,12 @@ func (fs *S3FS) ReadDir(path string) ([]os.FileInfo, error) {
 		uri.RawQuery = vars.Encode()
 		uri.Path = fmt.Sprintf("/%s/", fs.Bucket)
 
-		req, _ := http.NewRequest("GET", uri.String(), nil)
+		req, _ := http.NewRequestWithContext(fs.context(), "GET", uri.String(), nil)
 		req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
 
 		fs.signRequest(req)
 
-		resp, err := fs.client.Do(req)
+		resp, err := fs.do(req, "list")
 		if err != nil {
 			return nil, err
 		}
@@ -258,14 +304,14 @@ func (fs *S3FS) GetLifecycle() {
 	}
 	base.RawQuery = "lifecycle"
 
-	req, err := http.NewRequest("GET", base.String(), nil)
+	req, err := http.NewRequestWithContext(fs.context(), "GET", base.String(), nil)
 	if err != nil {
 		// return nil, err
 		return
 	}
 	fs.signRequest(req)
 
-	resp, err := fs.client.Do(req)
+	resp, err := fs.do(req, "get_lifecycle")
 	if err != nil {
 		// return nil, err
 		return
@@ -42,8 +42,8 @@ func (fs *S3FS) authString(req *http.Request) string {
 	cres = fmt.Sprintf("/%s/", fs.Bucket)
 
 	if req.URL.Path == cres || req.URL.Path == "/" {
-		if strings.Contains(req.URL.RawQuery, "lifecycle") {
-			cres = cres + `?` + req.URL.RawQuery
+		if sub := bucketSubresources(req.URL.Query()); sub != "" {
+			cres = cres + `?` + sub
 		}
 	} else {
 		c, rawQuery := canonicalResource(req.URL.Path, req.URL.Query())
@@ -96,6 +96,21 @@ func canonicalResource(path string, query url.Values) (cres, rawQuery string) {
 	return
 }
 
+// bucketSubresources returns the sorted sub-resources of a bucket request,
+// which have to be included in the canonical resource.
+// Other parameters such as prefix or delimiter are not signed.
+func bucketSubresources(query url.Values) string {
+	a := make([]string, 0, 1)
+	for k := range query {
+		switch k {
+		case "lifecycle", "versioning", "versions":
+			a = append(a, k)
+		}
+	}
+	sort.Strings(a)
+	return strings.Join(a, "&")
+}
+
 // escape ensures everything is properly escaped and spaces use %20 instead of +
 func escape(s string) string {
 	return strings.Replace(url.QueryEscape(s), `+`, `%20`, -1)
@@ -0,0 +1,69 @@
+package s3fs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/alexsnet/vfs/tracefs"
+)
+
+// do sends the signed request and traces every attempt as span "s3.<op>".
+//
+// Requests failing with a transient error or a 5xx, 408 or 429 status are
+// retried according to fs.Retry, unless they are not idempotent (POST),
+// their body can not be rewound or their context is done.
+// After the last attempt the response is returned as is.
+func (fs *S3FS) do(req *http.Request, op string, attrs ...tracefs.Attr) (*http.Response, error) {
+	idempotent := req.Method != http.MethodPost && (req.Body == nil || req.GetBody != nil)
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		resp, err := fs.send(req, op, attempt, attrs)
+		retryErr := err
+		if err == nil && retryableStatus(resp.StatusCode) {
+			// Keep the body readable for the caller if this is the last attempt.
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+			retryErr = &s3err{code: resp.StatusCode, text: resp.Status, xmlBody: string(b)}
+		}
+		if retryErr == nil || !idempotent || !fs.Retry.Wait(req.Context(), "s3."+op, attempt, start, retryErr) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
+
+func (fs *S3FS) send(req *http.Request, op string, attempt int, attrs []tracefs.Attr) (*http.Response, error) {
+	_, span := tracefs.Start(req.Context(), fs.Tracer, "s3."+op, append([]tracefs.Attr{
+		{Key: "method", Value: req.Method},
+		{Key: tracefs.AttrPath, Value: req.URL.Path},
+	}, attrs...)...)
+	if req.ContentLength > 0 {
+		span.SetAttrs(tracefs.Attr{Key: tracefs.AttrBytes, Value: req.ContentLength})
+	}
+	if attempt > 1 {
+		span.SetAttrs(tracefs.Attr{Key: "retry", Value: attempt - 1})
+	}
+
+	resp, err := fs.client.Do(req)
+	if err == nil {
+		span.SetAttrs(tracefs.Attr{Key: "status", Value: resp.StatusCode})
+	}
+	span.End(err)
+	return resp, err
+}
+
+func retryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+}
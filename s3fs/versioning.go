@@ -0,0 +1,159 @@
+package s3fs
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/alexsnet/vfs"
+)
+
+// ObjectVersion describes a version of an object in a bucket with versioning enabled.
+// For the meaning of these fields, see
+// http://docs.aws.amazon.com/AmazonS3/latest/API/RESTBucketGETVersion.html.
+type ObjectVersion struct {
+	Key          string
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool
+	LastModified string
+	ETag         string
+	Size         int64
+	// DeleteMarker is true if the version marks the deletion of the object.
+	DeleteMarker bool `xml:"-"`
+}
+
+type listVersionsResult struct {
+	IsTruncated         bool
+	NextKeyMarker       string
+	NextVersionIDMarker string          `xml:"NextVersionIdMarker"`
+	Versions            []ObjectVersion `xml:"Version"`
+	DeleteMarkers       []ObjectVersion `xml:"DeleteMarker"`
+}
+
+// Versioning reports whether versioning is enabled on the bucket.
+func (fs *S3FS) Versioning() (bool, error) {
+	req, err := http.NewRequestWithContext(fs.context(), "GET", fs.url(""), nil)
+	if err != nil {
+		return false, err
+	}
+	req.URL.RawQuery = "versioning"
+	fs.signRequest(req)
+
+	resp, err := fs.do(req, "get_versioning")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if c := resp.StatusCode; c != http.StatusOK {
+		return false, newS3Error(resp, "could not get bucket versioning: %d", c)
+	}
+
+	var result struct {
+		Status string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Status == "Enabled", nil
+}
+
+// ListVersions returns all versions and delete markers of the named object, newest first.
+func (fs *S3FS) ListVersions(name string) ([]ObjectVersion, error) {
+	key := strings.TrimLeft(name, "/")
+	versions := []ObjectVersion{}
+	var keyMarker, versionMarker string
+	for {
+		vars := url.Values{}
+		vars.Set("versions", "")
+		vars.Set("prefix", key)
+		if keyMarker != "" {
+			vars.Set("key-marker", keyMarker)
+			vars.Set("version-id-marker", versionMarker)
+		}
+
+		req, err := http.NewRequestWithContext(fs.context(), "GET", fs.url(""), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.URL.RawQuery = vars.Encode()
+		fs.signRequest(req)
+
+		resp, err := fs.do(req, "list_versions")
+		if err != nil {
+			return nil, err
+		}
+		if c := resp.StatusCode; c != http.StatusOK {
+			err := newS3Error(resp, "could not list versions: %d", c)
+			resp.Body.Close()
+			return nil, err
+		}
+		result := listVersionsResult{}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range result.Versions {
+			if v.Key == key {
+				v.ETag = strings.Trim(v.ETag, `"`)
+				versions = append(versions, v)
+			}
+		}
+		for _, v := range result.DeleteMarkers {
+			if v.Key == key {
+				v.DeleteMarker = true
+				versions = append(versions, v)
+			}
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+		keyMarker, versionMarker = result.NextKeyMarker, result.NextVersionIDMarker
+	}
+
+	// Both lists are ordered newest first, merge them by time.
+	// LastModified is formatted as RFC3339 and sorts lexically.
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].LastModified > versions[j].LastModified
+	})
+	return versions, nil
+}
+
+// OpenVersion opens a version of the named object for reading.
+func (fs *S3FS) OpenVersion(name, versionID string) (vfs.File, error) {
+	if versionID == "" {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return &s3file{
+		fs:        fs,
+		key:       name,
+		versionID: versionID,
+	}, nil
+}
+
+// RemoveVersion permanently deletes a version of the named object.
+func (fs *S3FS) RemoveVersion(name, versionID string) error {
+	req, err := http.NewRequestWithContext(fs.context(), "DELETE", fs.url(name), nil)
+	if err != nil {
+		return err
+	}
+	req.URL.RawQuery = url.Values{"versionId": {versionID}}.Encode()
+	fs.signRequest(req)
+
+	resp, err := fs.do(req, "delete_version")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if c := resp.StatusCode; c != http.StatusNoContent {
+		return newS3Error(resp, "could not remove version: %d", c)
+	}
+	return nil
+}
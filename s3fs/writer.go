@@ -2,8 +2,10 @@ package s3fs
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +14,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+
+	"github.com/alexsnet/vfs/tracefs"
 )
 
 const (
@@ -25,6 +29,9 @@ const (
 	nRetries           = 2
 )
 
+// errChecksum is returned if the ETag of an uploaded part does not match.
+var errChecksum = errors.New("checksums does not match")
+
 type Writer interface {
 	io.WriteCloser
 
@@ -75,23 +82,17 @@ func (w *writer) prepare() error {
 	}
 
 	uri, _ := url.Parse(w.o.fs.url(fmt.Sprintf("%s?uploads", w.o.key)))
-	req, err := http.NewRequest("POST", uri.String(), nil)
+	req, err := http.NewRequestWithContext(w.o.fs.context(), "POST", uri.String(), nil)
 	if err != nil {
 		return err
 	}
 
-	// detect mime type
-	ext := filepath.Ext(w.o.key)
-	contentType := "application/octet-stream"
-	if v, ok := mimeTypes[ext]; ok {
-		contentType = v
-	}
-	req.Header.Set(`Content-Type`, contentType)
+	req.Header.Set(`Content-Type`, contentType(w.o.key))
 
 	// sign and send
 	w.o.fs.signRequest(req)
 
-	resp, err := w.o.fs.client.Do(req)
+	resp, err := w.o.fs.do(req, "create_multipart")
 	if err != nil {
 		return err
 	}
@@ -117,10 +118,23 @@ func (w *writer) prepare() error {
 	return nil
 }
 
+// contentType detects the mime type of key by its extension.
+func contentType(key string) string {
+	if v := TypeByExtension(filepath.Ext(key)); v != "" {
+		return v
+	}
+	return "application/octet-stream"
+}
+
+// Write creates the multipart upload with the first non-empty p.
 func (w *writer) Write(p []byte) (n int, err error) {
 	w.m.Lock()
 	defer w.m.Unlock()
 
+	if len(p) == 0 {
+		return 0, nil
+	}
+
 	// prepare
 	if !w.prepared {
 		err := w.prepare()
@@ -145,9 +159,19 @@ func (w *writer) Write(p []byte) (n int, err error) {
 	return
 }
 
+// schedule uploads the parts with at most concurrencyUploads in parallel.
 func (w *writer) schedule() {
+	n := w.o.fs.concurrencyUploads
+	if n < 1 {
+		n = nConcurrentUploads
+	}
+	sem := make(chan struct{}, n)
 	for p := range w.pc {
-		go w.uploadPartRetry(p)
+		sem <- struct{}{}
+		go func(p *part) {
+			defer func() { <-sem }()
+			w.uploadPartRetry(p)
+		}(p)
 	}
 }
 
@@ -174,19 +198,32 @@ func (w *writer) flush() {
 func (w *writer) uploadPartRetry(p *part) {
 	defer w.wg.Done()
 
+	ctx, span := tracefs.Start(w.o.fs.context(), w.o.fs.Tracer, "s3.part",
+		tracefs.Attr{Key: tracefs.AttrPath, Value: w.o.key},
+		tracefs.Attr{Key: "part", Value: p.PartNumber},
+		tracefs.Attr{Key: tracefs.AttrBytes, Value: len(p.buf)},
+	)
+	// Transient HTTP errors are retried by the request policy,
+	// a corrupted upload is repeated up to nRetries times.
 	var err error
+	var attempts int
 	for i := 0; i < nRetries; i++ {
-		err = w.uploadPart(p)
-		if err == nil {
+		attempts++
+		err = w.uploadPart(ctx, p, i)
+		if err == nil || !errors.Is(err, errChecksum) {
 			break
 		}
 	}
+	span.SetAttrs(tracefs.Attr{Key: "attempts", Value: attempts})
+	span.End(err)
 	if err != nil {
 		w.close(true)
 	}
 }
 
-func (w *writer) uploadPart(p *part) error {
+// uploadPart uploads a single part, attempt is the zero based retry counter.
+// The requests are traced as children of the span carried by ctx.
+func (w *writer) uploadPart(ctx context.Context, p *part, attempt int) error {
 	buf := bytes.NewBuffer(p.buf)
 
 	var uv = make(url.Values)
@@ -196,14 +233,17 @@ func (w *writer) uploadPart(p *part) error {
 	uri, _ := url.Parse(w.o.fs.url(w.o.key))
 	uri.RawQuery = uv.Encode()
 
-	req, err := http.NewRequest("PUT", uri.String(), buf)
+	req, err := http.NewRequestWithContext(ctx, "PUT", uri.String(), buf)
 	if err != nil {
 		return err
 	}
 	req.ContentLength = int64(buf.Len())
 	w.o.fs.signRequest(req)
 
-	resp, err := w.o.fs.client.Do(req)
+	resp, err := w.o.fs.do(req, "upload_part",
+		tracefs.Attr{Key: "part", Value: p.PartNumber},
+		tracefs.Attr{Key: "attempt", Value: attempt},
+	)
 	if err != nil {
 		return err
 	}
@@ -217,7 +257,7 @@ func (w *writer) uploadPart(p *part) error {
 	etag := strings.Trim(resp.Header.Get("ETag"), ` "`)
 	if strings.Compare(p.ETag, etag) != 0 {
 		// logrus.WithField("ETagLocal", p.ETag).WithField("EtagRemote", etag).Error("File checksums does not match!")
-		return fmt.Errorf("checksums does not match: %s != %s", p.ETag, etag)
+		return fmt.Errorf("%w: %s != %s", errChecksum, p.ETag, etag)
 	}
 
 	return nil
@@ -230,6 +270,12 @@ func (w *writer) close(abort bool) error {
 	if w.closed {
 		return nil
 	}
+	if !w.prepared {
+		// Nothing has been written, there is no upload to finish.
+		close(w.pc)
+		w.closed = true
+		return nil
+	}
 
 	w.aborted = abort
 	w.flush()
@@ -250,14 +296,14 @@ func (w *writer) abort() error {
 	uv.Set("uploadId", w.uploadId)
 	url := w.o.fs.url("?" + uv.Encode())
 
-	req, err := http.NewRequest("DELETE", url, nil)
+	req, err := http.NewRequestWithContext(w.o.fs.context(), "DELETE", url, nil)
 	if err != nil {
 		return err
 	}
 
 	w.o.fs.signRequest(req)
 
-	resp, err := w.o.fs.client.Do(req)
+	resp, err := w.o.fs.do(req, "abort_multipart")
 	if err != nil {
 		return err
 	}
@@ -283,14 +329,14 @@ func (w *writer) complete() error {
 	uri, _ := url.Parse(w.o.fs.url(fmt.Sprintf("%s", w.o.key)))
 	uri.RawQuery = uv.Encode()
 
-	req, err := http.NewRequest("POST", uri.String(), bytes.NewBuffer(b))
+	req, err := http.NewRequestWithContext(w.o.fs.context(), "POST", uri.String(), bytes.NewBuffer(b))
 	if err != nil {
 		return err
 	}
 
 	w.o.fs.signRequest(req)
 
-	resp, err := w.o.fs.client.Do(req)
+	resp, err := w.o.fs.do(req, "complete_multipart")
 	if err != nil {
 		return err
 	}
@@ -302,6 +348,13 @@ func (w *writer) complete() error {
 	return nil
 }
 
+// written reports whether any data has been written.
+func (w *writer) written() bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w.prepared
+}
+
 func (w *writer) Close() error {
 	return w.close(false)
 }
@@ -331,3 +384,17 @@ func newS3Error(resp *http.Response, strFmt string, args ...interface{}) *s3err
 func (e *s3err) Error() string {
 	return e.text
 }
+
+// StatusCode returns the HTTP status code of the response.
+func (e *s3err) StatusCode() int {
+	return e.code
+}
+
+// Code returns the S3 error code of the response body, e.g. "SlowDown".
+func (e *s3err) Code() string {
+	var body struct {
+		Code string
+	}
+	xml.Unmarshal([]byte(e.xmlBody), &body)
+	return body.Code
+}
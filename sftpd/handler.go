@@ -0,0 +1,455 @@
+package sftpd
+
+import (
+	"errors"
+	"io"
+	"os"
+	filepath "path"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/alexsnet/vfs"
+	"github.com/pkg/sftp"
+)
+
+// maxPending is the number of bytes buffered per file while waiting for
+// out of order writes to become contiguous.
+const maxPending = 16 << 20
+
+// Optional operations used by the handlers if a Filesystem supports them.
+type (
+	readlinker interface {
+		Readlink(name string) (string, error)
+	}
+	symlinker interface {
+		Symlink(oldname, newname string) error
+	}
+	linker interface {
+		Link(oldname, newname string) error
+	}
+	chmoder interface {
+		Chmod(name string, mode os.FileMode) error
+	}
+	chowner interface {
+		Chown(name string, uid, gid int) error
+	}
+	chtimeser interface {
+		Chtimes(name string, atime, mtime time.Time) error
+	}
+	truncater interface {
+		Truncate(name string, size int64) error
+	}
+)
+
+// statusError keeps the message of err but reports code as SFTP status.
+type statusError struct {
+	err  error
+	code error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+func (e *statusError) Unwrap() error { return e.code }
+
+// status maps err to the SFTP status code sent to the client.
+func status(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	var code error
+	switch {
+	case os.IsNotExist(err) || errors.Is(err, os.ErrNotExist):
+		code = sftp.ErrSSHFxNoSuchFile
+	case os.IsPermission(err) || errors.Is(err, os.ErrPermission) || errors.Is(err, vfs.ErrReadOnly):
+		code = sftp.ErrSSHFxPermissionDenied
+	case errors.Is(err, sftp.ErrSSHFxOpUnsupported):
+		code = sftp.ErrSSHFxOpUnsupported
+	default:
+		code = sftp.ErrSSHFxFailure
+	}
+	return &statusError{err: err, code: code}
+}
+
+// unsupported returns the error of an operation fs does not provide.
+func unsupported(op, name string) error {
+	return status(&os.PathError{Op: op, Path: name, Err: sftp.ErrSSHFxOpUnsupported})
+}
+
+// clean returns name as absolute path without "..", so it can not escape a prefixfs.
+func clean(name string) string {
+	return filepath.Clean("/" + name)
+}
+
+// dirInfo reports os.ModeDir for directories of backends omitting it.
+type dirInfo struct {
+	os.FileInfo
+}
+
+func (fi dirInfo) Mode() os.FileMode { return fi.FileInfo.Mode() | os.ModeDir }
+
+func info(fi os.FileInfo) os.FileInfo {
+	if fi.IsDir() && !fi.Mode().IsDir() {
+		return dirInfo{fi}
+	}
+	return fi
+}
+
+// listerAt serves a fixed list of files.
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(fis []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(fis, l[offset:])
+	if offset+int64(n) >= int64(len(l)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// file adapts a vfs.File to the io.ReaderAt and io.WriterAt used by sftp.
+//
+// Files implementing io.WriterAt are written directly. Otherwise writes are
+// sequential: clients send several writes at once, so writes ahead of the
+// current position are buffered up to maxPending bytes until the gap is filled.
+// Only then the file is seeked, which is not supported by every backend.
+type file struct {
+	f vfs.File
+
+	mutex   sync.Mutex
+	pos     int64
+	pending map[int64][]byte
+	size    int
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	f.mutex.Lock()
+	// Reads of files opened for reading and writing see the pending writes.
+	err := f.flush(true)
+	f.mutex.Unlock()
+	if err != nil {
+		return 0, status(err)
+	}
+	n, err := f.f.ReadAt(p, off)
+	if err == io.EOF {
+		return n, err
+	}
+	return n, status(err)
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	if w, ok := f.f.(io.WriterAt); ok {
+		n, err := w.WriteAt(p, off)
+		return n, status(err)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if off > f.pos && f.size+len(p) <= maxPending {
+		if f.pending == nil {
+			f.pending = map[int64][]byte{}
+		}
+		f.pending[off] = append([]byte(nil), p...)
+		f.size += len(p)
+		return len(p), nil
+	}
+	n, err := f.write(p, off)
+	if err != nil {
+		return n, status(err)
+	}
+	return n, status(f.flush(false))
+}
+
+// write writes p at off, seeking if necessary. The mutex must be held.
+func (f *file) write(p []byte, off int64) (int, error) {
+	if off != f.pos {
+		if _, err := f.f.Seek(off, io.SeekStart); err != nil {
+			return 0, err
+		}
+		f.pos = off
+	}
+	n, err := f.f.Write(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+// flush writes the pending data continuing at the current position,
+// or all of it if force is set. The mutex must be held.
+func (f *file) flush(force bool) error {
+	for len(f.pending) > 0 {
+		off := f.pos
+		p, ok := f.pending[off]
+		if !ok {
+			if !force {
+				return nil
+			}
+			offs := make([]int64, 0, len(f.pending))
+			for o := range f.pending {
+				offs = append(offs, o)
+			}
+			sort.Slice(offs, func(i, j int) bool { return offs[i] < offs[j] })
+			off = offs[0]
+			p = f.pending[off]
+		}
+		delete(f.pending, off)
+		f.size -= len(p)
+		if _, err := f.write(p, off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *file) Close() error {
+	f.mutex.Lock()
+	err := f.flush(true)
+	f.mutex.Unlock()
+	if err1 := f.f.Close(); err == nil {
+		err = err1
+	}
+	return status(err)
+}
+
+// handler maps SFTP requests to a vfs.Filesystem.
+type handler struct {
+	fs vfs.Filesystem
+}
+
+// Handlers returns the sftp.Handlers serving fs, for use with sftp.NewRequestServer.
+//
+// Opening, listing, renaming, removing, creating directories and stat map
+// to the Filesystem methods, errors are reported with SFTP status codes.
+// Setstat, symlinks and hardlinks use Chmod, Chown, Chtimes, Truncate,
+// Symlink, Readlink and Link if the Filesystem implements them like OsFS.
+func Handlers(fs vfs.Filesystem) sftp.Handlers {
+	h := &handler{fs: fs}
+	return sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h}
+}
+
+func (h *handler) open(r *sftp.Request) (*file, error) {
+	pflags := r.Pflags()
+	var flag int
+	switch {
+	case pflags.Read && pflags.Write:
+		flag = os.O_RDWR
+	case pflags.Write || pflags.Append:
+		flag = os.O_WRONLY
+	}
+	if pflags.Append {
+		flag |= os.O_APPEND
+	}
+	if pflags.Creat {
+		flag |= os.O_CREATE
+	}
+	if pflags.Trunc {
+		flag |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flag |= os.O_EXCL
+	}
+	perm := os.FileMode(0666)
+	if r.AttrFlags().Permissions {
+		perm = r.Attributes().FileMode().Perm()
+	}
+	f, err := h.fs.OpenFile(clean(r.Filepath), flag, perm)
+	if err != nil {
+		return nil, status(err)
+	}
+	return &file{f: f}, nil
+}
+
+// Fileread implements sftp.FileReader.
+func (h *handler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	f, err := h.open(r)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Filewrite implements sftp.FileWriter.
+func (h *handler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	f, err := h.open(r)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// OpenFile implements sftp.OpenFileWriter.
+func (h *handler) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	f, err := h.open(r)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Filecmd implements sftp.FileCmder.
+func (h *handler) Filecmd(r *sftp.Request) error {
+	name := clean(r.Filepath)
+	switch r.Method {
+	case "Setstat":
+		return h.setstat(name, r)
+	case "Rename":
+		// Version 3 of the protocol does not replace existing files.
+		target := clean(r.Target)
+		if _, err := h.fs.Lstat(target); err == nil {
+			return status(&os.PathError{Op: "rename", Path: target, Err: os.ErrExist})
+		}
+		return status(h.fs.Rename(name, target))
+	case "Rmdir":
+		fi, err := h.fs.Lstat(name)
+		if err != nil {
+			return status(err)
+		}
+		if !fi.IsDir() {
+			return status(&os.PathError{Op: "rmdir", Path: name, Err: vfs.ErrNotDirectory})
+		}
+		// Some backends remove directories with their content.
+		if fis, err := h.fs.ReadDir(name); err != nil {
+			return status(err)
+		} else if len(fis) > 0 {
+			return status(&os.PathError{Op: "rmdir", Path: name, Err: syscall.ENOTEMPTY})
+		}
+		return status(h.fs.Remove(name))
+	case "Remove":
+		fi, err := h.fs.Lstat(name)
+		if err != nil {
+			return status(err)
+		}
+		if fi.IsDir() {
+			return status(&os.PathError{Op: "remove", Path: name, Err: vfs.ErrIsDirectory})
+		}
+		return status(h.fs.Remove(name))
+	case "Mkdir":
+		perm := os.FileMode(0777)
+		if r.AttrFlags().Permissions {
+			perm = r.Attributes().FileMode().Perm()
+		}
+		return status(h.fs.Mkdir(name, perm))
+	case "Symlink":
+		// The target is stored as sent by the client, Target is the new link.
+		fs, ok := h.fs.(symlinker)
+		if !ok {
+			return unsupported("symlink", r.Target)
+		}
+		return status(fs.Symlink(r.Filepath, clean(r.Target)))
+	case "Link":
+		fs, ok := h.fs.(linker)
+		if !ok {
+			return unsupported("link", r.Target)
+		}
+		return status(fs.Link(name, clean(r.Target)))
+	}
+	return unsupported(r.Method, name)
+}
+
+// PosixRename implements sftp.PosixRenameFileCmder, existing files are replaced.
+func (h *handler) PosixRename(r *sftp.Request) error {
+	return status(h.fs.Rename(clean(r.Filepath), clean(r.Target)))
+}
+
+// setstat applies the attributes of r to name.
+// Modes, owners and times are ignored if the Filesystem can not store them,
+// as clients set them after every upload.
+func (h *handler) setstat(name string, r *sftp.Request) error {
+	flags := r.AttrFlags()
+	attrs := r.Attributes()
+	if flags.Size {
+		if fs, ok := h.fs.(truncater); ok {
+			if err := fs.Truncate(name, int64(attrs.Size)); err != nil {
+				return status(err)
+			}
+		} else if err := h.truncate(name, int64(attrs.Size)); err != nil {
+			return status(err)
+		}
+	}
+	if flags.Permissions {
+		if fs, ok := h.fs.(chmoder); ok {
+			if err := fs.Chmod(name, attrs.FileMode().Perm()); err != nil {
+				return status(err)
+			}
+		}
+	}
+	if flags.UidGid {
+		if fs, ok := h.fs.(chowner); ok {
+			if err := fs.Chown(name, int(attrs.UID), int(attrs.GID)); err != nil {
+				return status(err)
+			}
+		}
+	}
+	if flags.Acmodtime {
+		if fs, ok := h.fs.(chtimeser); ok {
+			atime := time.Unix(int64(attrs.Atime), 0)
+			mtime := time.Unix(int64(attrs.Mtime), 0)
+			if err := fs.Chtimes(name, atime, mtime); err != nil {
+				return status(err)
+			}
+		}
+	}
+	return nil
+}
+
+// truncate truncates name through an open file.
+// Files of backends which can not truncate may panic, as s3fs does,
+// which is reported as unsupported instead of ending the server.
+func (h *handler) truncate(name string, size int64) (err error) {
+	f, err := h.fs.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if recover() != nil {
+			err = &os.PathError{Op: "truncate", Path: name, Err: sftp.ErrSSHFxOpUnsupported}
+		}
+		if err1 := f.Close(); err == nil {
+			err = err1
+		}
+	}()
+	return f.Truncate(size)
+}
+
+// Filelist implements sftp.FileLister.
+func (h *handler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	name := clean(r.Filepath)
+	switch r.Method {
+	case "List":
+		fis, err := h.fs.ReadDir(name)
+		if err != nil {
+			return nil, status(err)
+		}
+		l := make(listerAt, len(fis))
+		for i, fi := range fis {
+			l[i] = info(fi)
+		}
+		return l, nil
+	case "Stat":
+		fi, err := h.fs.Stat(name)
+		if err != nil {
+			return nil, status(err)
+		}
+		return listerAt{info(fi)}, nil
+	}
+	return nil, unsupported(r.Method, name)
+}
+
+// Lstat implements sftp.LstatFileLister.
+func (h *handler) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	fi, err := h.fs.Lstat(clean(r.Filepath))
+	if err != nil {
+		return nil, status(err)
+	}
+	return listerAt{info(fi)}, nil
+}
+
+// Readlink implements sftp.ReadlinkFileLister.
+func (h *handler) Readlink(name string) (string, error) {
+	fs, ok := h.fs.(readlinker)
+	if !ok {
+		return "", unsupported("readlink", name)
+	}
+	target, err := fs.Readlink(clean(name))
+	return target, status(err)
+}
@@ -0,0 +1,284 @@
+// Package sftpd serves a vfs.Filesystem over SFTP.
+//
+// Users are authenticated by an Authenticator, which confines every user to
+// a directory of the filesystem with prefixfs and may restrict them to
+// read-only access. Only the sftp subsystem is available, shell and exec
+// requests are rejected.
+package sftpd
+
+import (
+	"errors"
+	"io"
+	"net"
+	filepath "path"
+	"sync"
+
+	"github.com/alexsnet/vfs"
+	"github.com/alexsnet/vfs/prefixfs"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrDenied is returned by authenticators rejecting a user.
+	ErrDenied = errors.New("Access denied")
+	// ErrNoHostKey is returned by NewServer if Options.HostKeys is empty.
+	ErrNoHostKey = errors.New("No host key")
+	// ErrServerClosed is returned by Serve after Close.
+	ErrServerClosed = errors.New("Server closed")
+)
+
+// Keys of the ssh.Permissions extensions carrying the Account of a connection.
+const (
+	rootExtension     = "vfs-root"
+	readOnlyExtension = "vfs-readonly"
+)
+
+// An Account describes the access of an authenticated user.
+type Account struct {
+	// Root is the directory the user is confined to, "" or "/" for the whole filesystem.
+	Root string
+	// ReadOnly rejects all modifications.
+	ReadOnly bool
+}
+
+// An Authenticator checks the credentials of users.
+// Returning an error, e.g. ErrDenied, rejects the attempt.
+type Authenticator interface {
+	Password(user string, password []byte) (*Account, error)
+	PublicKey(user string, key ssh.PublicKey) (*Account, error)
+}
+
+// PasswordFunc is an Authenticator accepting passwords only.
+type PasswordFunc func(user string, password []byte) (*Account, error)
+
+// Password implements Authenticator.
+func (f PasswordFunc) Password(user string, password []byte) (*Account, error) {
+	return f(user, password)
+}
+
+// PublicKey implements Authenticator.
+func (f PasswordFunc) PublicKey(user string, key ssh.PublicKey) (*Account, error) {
+	return nil, ErrDenied
+}
+
+// PublicKeyFunc is an Authenticator accepting public keys only.
+type PublicKeyFunc func(user string, key ssh.PublicKey) (*Account, error)
+
+// Password implements Authenticator.
+func (f PublicKeyFunc) Password(user string, password []byte) (*Account, error) {
+	return nil, ErrDenied
+}
+
+// PublicKey implements Authenticator.
+func (f PublicKeyFunc) PublicKey(user string, key ssh.PublicKey) (*Account, error) {
+	return f(user, key)
+}
+
+// Options configure a Server.
+type Options struct {
+	// HostKeys identify the server, see ssh.ParsePrivateKey.
+	HostKeys []ssh.Signer
+	// MaxAuthTries is the number of authentication attempts per connection, 6 if 0.
+	MaxAuthTries int
+	// Logger is called when a connection ends with its error, if any.
+	// user is empty if the connection was not authenticated.
+	Logger func(remote net.Addr, user string, err error)
+}
+
+// A Server serves a filesystem over SFTP.
+type Server struct {
+	fs     vfs.Filesystem
+	config *ssh.ServerConfig
+	logger func(remote net.Addr, user string, err error)
+
+	mutex     sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// NewServer returns a Server serving fs to the users accepted by auth.
+func NewServer(fs vfs.Filesystem, auth Authenticator, opts Options) (*Server, error) {
+	if len(opts.HostKeys) == 0 {
+		return nil, ErrNoHostKey
+	}
+	config := &ssh.ServerConfig{
+		MaxAuthTries: opts.MaxAuthTries,
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return permissions(auth.Password(c.User(), password))
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return permissions(auth.PublicKey(c.User(), key))
+		},
+	}
+	for _, key := range opts.HostKeys {
+		config.AddHostKey(key)
+	}
+	return &Server{
+		fs:        fs,
+		config:    config,
+		logger:    opts.Logger,
+		listeners: map[net.Listener]struct{}{},
+		conns:     map[net.Conn]struct{}{},
+	}, nil
+}
+
+// permissions stores account in the ssh.Permissions of the connection.
+func permissions(account *Account, err error) (*ssh.Permissions, error) {
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, ErrDenied
+	}
+	ext := map[string]string{rootExtension: filepath.Clean("/" + account.Root)}
+	if account.ReadOnly {
+		ext[readOnlyExtension] = "true"
+	}
+	return &ssh.Permissions{Extensions: ext}, nil
+}
+
+// filesystem returns the filesystem of an authenticated connection.
+func (s *Server) filesystem(perms *ssh.Permissions) vfs.Filesystem {
+	fs := s.fs
+	if root := perms.Extensions[rootExtension]; root != "/" {
+		fs = prefixfs.Create(fs, root)
+	}
+	if perms.Extensions[readOnlyExtension] != "" {
+		fs = vfs.ReadOnly(fs)
+	}
+	return fs
+}
+
+// Serve accepts connections on l until it fails or the server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.listeners, l)
+		s.mutex.Unlock()
+	}()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(c)
+	}
+}
+
+// ServeConn serves a single connection and closes it when done.
+func (s *Server) ServeConn(c net.Conn) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		c.Close()
+		return ErrServerClosed
+	}
+	s.conns[c] = struct{}{}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, c)
+		s.mutex.Unlock()
+		c.Close()
+	}()
+
+	sc, chans, reqs, err := ssh.NewServerConn(c, s.config)
+	if err != nil {
+		s.log(c.RemoteAddr(), "", err)
+		return err
+	}
+	defer sc.Close()
+	go ssh.DiscardRequests(reqs)
+	fs := s.filesystem(sc.Permissions)
+
+	var wg sync.WaitGroup
+	var errMutex sync.Mutex
+	var firstErr error
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := session(fs, ch, requests); err != nil {
+				errMutex.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	s.log(c.RemoteAddr(), sc.User(), firstErr)
+	return firstErr
+}
+
+func (s *Server) log(remote net.Addr, user string, err error) {
+	if s.logger != nil {
+		s.logger(remote, user, err)
+	}
+}
+
+// session serves SFTP on ch once the client requests the sftp subsystem.
+func session(fs vfs.Filesystem, ch ssh.Channel, requests <-chan *ssh.Request) error {
+	defer ch.Close()
+	for req := range requests {
+		// The payload of a subsystem request is the length prefixed name.
+		ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+		if !ok {
+			continue
+		}
+		go ssh.DiscardRequests(requests)
+		server := sftp.NewRequestServer(ch, Handlers(fs))
+		err := server.Serve()
+		server.Close()
+		if err == io.EOF {
+			err = nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Close stops all listeners and closes all connections.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if err1 := l.Close(); err == nil {
+			err = err1
+		}
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return err
+}
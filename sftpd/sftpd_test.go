@@ -0,0 +1,247 @@
+package sftpd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	filepath "path"
+	"testing"
+
+	"github.com/alexsnet/vfs"
+	"github.com/alexsnet/vfs/memfs"
+	"github.com/alexsnet/vfs/prefixfs"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testFS denies creating directories named "denied" and returns files which panic on Truncate like s3fs.
+type testFS struct {
+	vfs.Filesystem
+}
+
+func (fs testFS) Mkdir(name string, perm os.FileMode) error {
+	if filepath.Base(name) == "denied" {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrPermission}
+	}
+	return fs.Filesystem.Mkdir(name, perm)
+}
+
+func (fs testFS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	f, err := fs.Filesystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return panicFile{f}, nil
+}
+
+type panicFile struct {
+	vfs.File
+}
+
+func (f panicFile) Truncate(int64) error { panic("not implemented") }
+
+// serve starts a server for fs on the loopback interface.
+// The password of every user is "secret", user "guest" is read-only
+// and all other users are confined to /home/<user>.
+func serve(t *testing.T, fs vfs.Filesystem) string {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := PasswordFunc(func(user string, password []byte) (*Account, error) {
+		if string(password) != "secret" {
+			return nil, ErrDenied
+		}
+		if user == "guest" {
+			return &Account{ReadOnly: true}, nil
+		}
+		return &Account{Root: "/home/" + user}, nil
+	})
+	srv, err := NewServer(fs, auth, Options{HostKeys: []ssh.Signer{signer}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return l.Addr().String()
+}
+
+func dial(t *testing.T, addr, user string) *sftp.Client {
+	c, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := sftp.NewClient(c)
+	if err != nil {
+		c.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		c.Close()
+	})
+	return client
+}
+
+func write(t *testing.T, c *sftp.Client, name, data string) {
+	f, err := c.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func code(err error) uint32 {
+	var serr *sftp.StatusError
+	if errors.As(err, &serr) {
+		return serr.Code
+	}
+	return 0
+}
+
+func TestAccount(t *testing.T) {
+	fs := memfs.Create()
+	vfs.MkdirAll(fs, "/home/alice", 0755)
+	vfs.WriteFile(fs, "/top", []byte("top"), 0644)
+	c := dial(t, serve(t, fs), "alice")
+
+	write(t, c, "/../../a", "alice")
+	if data, err := vfs.ReadFile(fs, "/home/alice/a"); err != nil || string(data) != "alice" {
+		t.Fatalf("file of alice = %q, %v", data, err)
+	}
+	if _, err := c.Stat("/../top"); !os.IsNotExist(err) {
+		t.Fatalf("Stat outside of the root = %v", err)
+	}
+	fis, err := c.ReadDir("/")
+	if err != nil || len(fis) != 1 || fis[0].Name() != "a" {
+		t.Fatalf("ReadDir = %v, %v", fis, err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	fs := memfs.Create()
+	vfs.WriteFile(fs, "/a", []byte("data"), 0644)
+	c := dial(t, serve(t, fs), "guest")
+
+	f, err := c.Open("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "data" {
+		t.Fatalf("Read = %q, %v", data, err)
+	}
+	// The read-only filesystem fails with EROFS.
+	if _, err := c.Create("/b"); !os.IsPermission(err) {
+		t.Errorf("Create = %v", err)
+	}
+	if err := c.Remove("/a"); !os.IsPermission(err) {
+		t.Errorf("Remove = %v", err)
+	}
+	if err := c.Mkdir("/d"); !os.IsPermission(err) {
+		t.Errorf("Mkdir = %v", err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	fs := memfs.Create()
+	vfs.MkdirAll(fs, "/home/alice/dir", 0755)
+	vfs.WriteFile(fs, "/home/alice/dir/a", nil, 0644)
+	c := dial(t, serve(t, testFS{fs}), "alice")
+
+	if _, err := c.Stat("/missing"); !os.IsNotExist(err) {
+		t.Errorf("Stat = %v", err)
+	}
+	if err := c.Remove("/missing"); !os.IsNotExist(err) {
+		t.Errorf("Remove = %v", err)
+	}
+	if err := c.Mkdir("/denied"); !os.IsPermission(err) {
+		t.Errorf("Mkdir = %v", err)
+	}
+	// Directories are not removed with their content.
+	if err := c.RemoveDirectory("/dir"); code(err) != 4 {
+		t.Errorf("RemoveDirectory of non-empty directory = %v", err)
+	}
+	if _, err := fs.Stat("/home/alice/dir/a"); err != nil {
+		t.Fatal(err)
+	}
+	// Truncating files which panic is unsupported.
+	if err := c.Truncate("/dir/a", 0); code(err) != 8 {
+		t.Errorf("Truncate = %v", err)
+	}
+	if _, err := c.Stat("/dir/a"); err != nil {
+		t.Fatalf("Stat after Truncate = %v", err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sftpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(dir+"/home/alice", 0755)
+	// The local filesystem replaces existing files on Rename.
+	c := dial(t, serve(t, prefixfs.Create(vfs.OS(), dir)), "alice")
+
+	write(t, c, "/a", "a")
+	write(t, c, "/b", "b")
+	if err := c.Rename("/a", "/b"); err == nil {
+		t.Fatal("Rename replaced an existing file")
+	}
+	if err := c.PosixRename("/a", "/b"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(dir + "/home/alice/b"); err != nil || string(data) != "a" {
+		t.Fatalf("renamed file = %q, %v", data, err)
+	}
+	if err := c.Rename("/b", "/c"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteAtOutOfOrder(t *testing.T) {
+	fs := memfs.Create()
+	vfs.MkdirAll(fs, "/home/alice", 0755)
+	c := dial(t, serve(t, fs), "alice")
+
+	f, err := c.Create("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []struct {
+		data string
+		off  int64
+	}{{"world", 6}, {"!", 11}, {"hello ", 0}} {
+		if _, err := f.WriteAt([]byte(w.data), w.off); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := vfs.ReadFile(fs, "/home/alice/a"); err != nil || string(data) != "hello world!" {
+		t.Fatalf("file = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,56 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/pkg/sftp"
+)
+
+// file is an open file holding its pooled connection until it is closed.
+type file struct {
+	fs   *FS
+	c    *conn
+	f    *sftp.File
+	name string
+	once sync.Once
+}
+
+func (f *file) Name() string { return f.name }
+
+func (f *file) Stat() (os.FileInfo, error) { return f.f.Stat() }
+
+func (f *file) Read(p []byte) (int, error) { return f.f.Read(p) }
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) { return f.f.ReadAt(p, off) }
+
+func (f *file) Write(p []byte) (int, error) { return f.f.Write(p) }
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) { return f.f.WriteAt(p, off) }
+
+func (f *file) Seek(offset int64, whence int) (int64, error) { return f.f.Seek(offset, whence) }
+
+func (f *file) Truncate(size int64) error { return f.f.Truncate(size) }
+
+// Chmod changes the mode of the file.
+func (f *file) Chmod(mode os.FileMode) error { return f.f.Chmod(mode) }
+
+// Sync flushes the file on the server if it supports the fsync@openssh.com extension,
+// otherwise it is a no-op.
+func (f *file) Sync() error {
+	err := f.f.Sync()
+	var status *sftp.StatusError
+	if errors.As(err, &status) && status.FxCode() == sftp.ErrSSHFxOpUnsupported {
+		return nil
+	}
+	return err
+}
+
+func (f *file) Close() error {
+	err := f.f.Close()
+	f.once.Do(func() {
+		f.fs.release(f.c)
+	})
+	return err
+}
@@ -0,0 +1,430 @@
+// Package sftpfs provides a vfs.Filesystem backed by an SFTP server.
+package sftpfs
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	filepath "path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alexsnet/vfs"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// DefaultMaxConns is the default size of the connection pool.
+const DefaultMaxConns = 4
+
+// ErrNoHostKeyCallback is returned by Create if the host key can not be verified.
+var ErrNoHostKeyCallback = errors.New("Neither KnownHosts nor HostKeyCallback set")
+
+// Options configure a FS.
+type Options struct {
+	User string
+	// Password authenticates with password and keyboard-interactive authentication.
+	Password string
+	// Signers authenticate with public keys, see ParseKey.
+	Signers []ssh.Signer
+	// KnownHosts are known_hosts files verifying the host key, e.g. "~/.ssh/known_hosts" expanded.
+	KnownHosts []string
+	// HostKeyCallback verifies the host key instead of KnownHosts.
+	// Use ssh.InsecureIgnoreHostKey() only for testing.
+	HostKeyCallback ssh.HostKeyCallback
+	// MaxConns is the maximum number of SSH connections, DefaultMaxConns if 0.
+	MaxConns int
+	// Timeout for establishing a connection, 30 seconds if 0.
+	Timeout time.Duration
+}
+
+// ParseKey parses a PEM encoded private key, which is decrypted with passphrase if set.
+func ParseKey(pem []byte, passphrase string) (ssh.Signer, error) {
+	if passphrase == "" {
+		return ssh.ParsePrivateKey(pem)
+	}
+	return ssh.ParsePrivateKeyWithPassphrase(pem, []byte(passphrase))
+}
+
+// conn is a pooled SSH connection with its SFTP session.
+type conn struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+	// users counts the running operations and open files.
+	users int
+}
+
+// A FS accessing the files of an SFTP server.
+//
+// Operations are spread over a pool of connections, which are opened on demand
+// up to MaxConns. Connections which are lost are dropped from the pool,
+// operations failing because of a lost connection are repeated once on
+// a new connection, except Rename. Open files fail if their connection is lost.
+type FS struct {
+	addr   string
+	config *ssh.ClientConfig
+	max    int
+
+	mutex   sync.Mutex
+	conns   []*conn
+	pending int
+	closed  bool
+}
+
+// Create returns a FS for the SFTP server at addr, e.g. "example.com:22".
+// The first connection is opened immediately to verify the configuration.
+func Create(addr string, opts Options) (*FS, error) {
+	callback := opts.HostKeyCallback
+	if callback == nil {
+		if len(opts.KnownHosts) == 0 {
+			return nil, ErrNoHostKeyCallback
+		}
+		var err error
+		if callback, err = knownhosts.New(opts.KnownHosts...); err != nil {
+			return nil, err
+		}
+	}
+	config := &ssh.ClientConfig{
+		User:            opts.User,
+		HostKeyCallback: callback,
+		Timeout:         opts.Timeout,
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+	if len(opts.Signers) > 0 {
+		config.Auth = append(config.Auth, ssh.PublicKeys(opts.Signers...))
+	}
+	if opts.Password != "" {
+		password := opts.Password
+		config.Auth = append(config.Auth,
+			ssh.Password(password),
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = password
+				}
+				return answers, nil
+			}))
+	}
+
+	fs := &FS{addr: addr, config: config, max: opts.MaxConns}
+	if fs.max <= 0 {
+		fs.max = DefaultMaxConns
+	}
+	c, err := fs.dial()
+	if err != nil {
+		return nil, err
+	}
+	fs.conns = append(fs.conns, c)
+	return fs, nil
+}
+
+// Close closes all connections, open files fail afterwards.
+func (fs *FS) Close() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.closed = true
+	var err error
+	for _, c := range fs.conns {
+		c.sftp.Close()
+		if err1 := c.ssh.Close(); err == nil {
+			err = err1
+		}
+	}
+	fs.conns = nil
+	return err
+}
+
+func (fs *FS) dial() (*conn, error) {
+	client, err := ssh.Dial("tcp", fs.addr, fs.config)
+	if err != nil {
+		return nil, err
+	}
+	session, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	c := &conn{ssh: client, sftp: session}
+	go func() {
+		// Drop the connection from the pool as soon as it is lost.
+		client.Wait()
+		fs.drop(c)
+	}()
+	return c, nil
+}
+
+// acquire returns the least used connection,
+// opening a new one if all are in use and the pool is not full.
+func (fs *FS) acquire() (*conn, error) {
+	fs.mutex.Lock()
+	if fs.closed {
+		fs.mutex.Unlock()
+		return nil, os.ErrClosed
+	}
+	var best *conn
+	for _, c := range fs.conns {
+		if best == nil || c.users < best.users {
+			best = c
+		}
+	}
+	if best != nil && (best.users == 0 || len(fs.conns)+fs.pending >= fs.max) {
+		best.users++
+		fs.mutex.Unlock()
+		return best, nil
+	}
+	fs.pending++
+	fs.mutex.Unlock()
+
+	c, err := fs.dial()
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.pending--
+	if err != nil {
+		if best != nil && !fs.closed {
+			// Share a busy connection rather than fail.
+			best.users++
+			return best, nil
+		}
+		return nil, err
+	}
+	if fs.closed {
+		c.ssh.Close()
+		return nil, os.ErrClosed
+	}
+	c.users++
+	fs.conns = append(fs.conns, c)
+	return c, nil
+}
+
+func (fs *FS) release(c *conn) {
+	fs.mutex.Lock()
+	c.users--
+	fs.mutex.Unlock()
+}
+
+// drop removes c from the pool and closes it.
+func (fs *FS) drop(c *conn) {
+	fs.mutex.Lock()
+	for i, other := range fs.conns {
+		if other == c {
+			fs.conns = append(fs.conns[:i], fs.conns[i+1:]...)
+			break
+		}
+	}
+	fs.mutex.Unlock()
+	c.ssh.Close()
+}
+
+// lost reports whether err is caused by a broken connection.
+func lost(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// pathError wraps err into a *os.PathError unless it is one already.
+func pathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*os.PathError); ok {
+		return err
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// with calls fn with a pooled session, repeating it once on a new connection
+// if the connection was lost and retry is set.
+func (fs *FS) with(op, name string, retry bool, fn func(c *sftp.Client) error) error {
+	for attempt := 1; ; attempt++ {
+		c, err := fs.acquire()
+		if err != nil {
+			return pathError(op, name, err)
+		}
+		err = fn(c.sftp)
+		fs.release(c)
+		if !lost(err) {
+			return pathError(op, name, err)
+		}
+		fs.drop(c)
+		if !retry || attempt > 1 {
+			return pathError(op, name, err)
+		}
+	}
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return '/' }
+
+// OpenFile implements vfs.Filesystem.
+// New files are created with perm, the connection is held until the file is closed.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	var f *sftp.File
+	var owner *conn
+	for attempt := 1; ; attempt++ {
+		c, err := fs.acquire()
+		if err != nil {
+			return nil, pathError("open", name, err)
+		}
+		created := false
+		if flag&os.O_CREATE != 0 {
+			if flag&os.O_EXCL != 0 {
+				created = true
+			} else if _, err := c.sftp.Lstat(name); os.IsNotExist(err) {
+				created = true
+			}
+		}
+		f, err = c.sftp.OpenFile(name, flag)
+		if err == nil && created {
+			if err = f.Chmod(perm.Perm()); err != nil {
+				f.Close()
+			}
+		}
+		if err == nil {
+			owner = c
+			break
+		}
+		fs.release(c)
+		if !lost(err) {
+			return nil, pathError("open", name, err)
+		}
+		fs.drop(c)
+		if attempt > 1 {
+			return nil, pathError("open", name, err)
+		}
+	}
+	return &file{fs: fs, c: owner, f: f, name: name}, nil
+}
+
+// Remove implements vfs.Filesystem.
+func (fs *FS) Remove(name string) error {
+	attempts := 0
+	return fs.with("remove", name, true, func(c *sftp.Client) error {
+		attempts++
+		err := c.Remove(name)
+		if attempts > 1 && os.IsNotExist(err) {
+			// Removed by the attempt on the lost connection.
+			return nil
+		}
+		return err
+	})
+}
+
+// Rename implements vfs.Filesystem.
+// Existing files at newpath are replaced, atomically if the server supports
+// the posix-rename@openssh.com extension. Otherwise the existing file is moved
+// aside to a temporary name first and restored if the rename fails.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	return fs.with("rename", oldpath, false, func(c *sftp.Client) error {
+		if _, ok := c.HasExtension("posix-rename@openssh.com"); ok {
+			return c.PosixRename(oldpath, newpath)
+		}
+		err := c.Rename(oldpath, newpath)
+		if err == nil {
+			return nil
+		}
+		fi, err1 := c.Lstat(newpath)
+		if err1 != nil || fi.IsDir() {
+			return err
+		}
+		dir, base := filepath.Split(newpath)
+		tmp := filepath.Join(dir, "."+base+".rename-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+		if err := c.Rename(newpath, tmp); err != nil {
+			return err
+		}
+		if err := c.Rename(oldpath, newpath); err != nil {
+			c.Rename(tmp, newpath)
+			return err
+		}
+		// The renamed file is in place, a temporary file left behind is harmless.
+		c.Remove(tmp)
+		return nil
+	})
+}
+
+// Mkdir implements vfs.Filesystem.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	attempts := 0
+	return fs.with("mkdir", name, true, func(c *sftp.Client) error {
+		attempts++
+		if err := c.Mkdir(name); err != nil {
+			if fi, err1 := c.Lstat(name); err1 == nil && fi.IsDir() {
+				if attempts > 1 {
+					// Created by the attempt on the lost connection.
+					return nil
+				}
+				return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+			}
+			return err
+		}
+		return c.Chmod(name, perm.Perm())
+	})
+}
+
+// Stat implements vfs.Filesystem.
+func (fs *FS) Stat(name string) (fi os.FileInfo, err error) {
+	err = fs.with("stat", name, true, func(c *sftp.Client) error {
+		fi, err = c.Stat(name)
+		return err
+	})
+	return fi, err
+}
+
+// Lstat implements vfs.Filesystem.
+func (fs *FS) Lstat(name string) (fi os.FileInfo, err error) {
+	err = fs.with("lstat", name, true, func(c *sftp.Client) error {
+		fi, err = c.Lstat(name)
+		return err
+	})
+	return fi, err
+}
+
+// ReadDir implements vfs.Filesystem.
+func (fs *FS) ReadDir(path string) (fis []os.FileInfo, err error) {
+	err = fs.with("readdir", path, true, func(c *sftp.Client) error {
+		fis, err = c.ReadDir(path)
+		return err
+	})
+	return fis, err
+}
+
+// Chmod changes the mode of the named file.
+func (fs *FS) Chmod(name string, mode os.FileMode) error {
+	return fs.with("chmod", name, true, func(c *sftp.Client) error {
+		return c.Chmod(name, mode)
+	})
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (fs *FS) Chtimes(name string, atime, mtime time.Time) error {
+	return fs.with("chtimes", name, true, func(c *sftp.Client) error {
+		return c.Chtimes(name, atime, mtime)
+	})
+}
+
+// Symlink creates newname as a symbolic link to oldname.
+func (fs *FS) Symlink(oldname, newname string) error {
+	return fs.with("symlink", newname, false, func(c *sftp.Client) error {
+		return c.Symlink(oldname, newname)
+	})
+}
+
+// Readlink returns the target of the symlink name.
+func (fs *FS) Readlink(name string) (target string, err error) {
+	err = fs.with("readlink", name, true, func(c *sftp.Client) error {
+		target, err = c.ReadLink(name)
+		return err
+	})
+	return target, err
+}
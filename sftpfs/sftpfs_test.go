@@ -0,0 +1,340 @@
+package sftpfs
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	filepath "path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alexsnet/vfs"
+	"github.com/alexsnet/vfs/memfs"
+	"github.com/alexsnet/vfs/sftpd"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is an SSH server on the loopback interface running handle
+// for every sftp subsystem request.
+type testServer struct {
+	addr string
+
+	mutex    sync.Mutex
+	conns    []net.Conn
+	accepted int
+}
+
+func startServer(t *testing.T, handle func(ch ssh.Channel)) *testServer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "secret" {
+				return nil, errors.New("denied")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &testServer{addr: l.Addr().String()}
+	t.Cleanup(func() {
+		l.Close()
+		srv.kill()
+	})
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			srv.mutex.Lock()
+			srv.conns = append(srv.conns, c)
+			srv.accepted++
+			srv.mutex.Unlock()
+			go srv.serve(c, config, handle)
+		}
+	}()
+	return srv
+}
+
+func (srv *testServer) serve(c net.Conn, config *ssh.ServerConfig, handle func(ch ssh.Channel)) {
+	_, chans, reqs, err := ssh.NewServerConn(c, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					go handle(ch)
+				}
+			}
+		}()
+	}
+}
+
+// kill closes all connections of the server.
+func (srv *testServer) kill() {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	for _, c := range srv.conns {
+		c.Close()
+	}
+	srv.conns = nil
+}
+
+func (srv *testServer) count() int {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	return srv.accepted
+}
+
+// serveOS serves the local filesystem.
+func serveOS(ch ssh.Channel) {
+	server, err := sftp.NewServer(ch)
+	if err != nil {
+		ch.Close()
+		return
+	}
+	server.Serve()
+	server.Close()
+}
+
+// serveVFS serves fs, which has the rename semantics of protocol version 3.
+func serveVFS(fs vfs.Filesystem) func(ch ssh.Channel) {
+	return func(ch ssh.Channel) {
+		server := sftp.NewRequestServer(ch, sftpd.Handlers(fs))
+		server.Serve()
+		server.Close()
+	}
+}
+
+func connect(t *testing.T, srv *testServer, maxConns int) *FS {
+	fs, err := Create(srv.addr, Options{
+		User:            "user",
+		Password:        "secret",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		MaxConns:        maxConns,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+func TestAuth(t *testing.T) {
+	srv := startServer(t, serveOS)
+	_, err := Create(srv.addr, Options{User: "user", Password: "wrong", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	if err == nil {
+		t.Fatal("Create with wrong password succeeded")
+	}
+	if _, err := Create(srv.addr, Options{User: "user", Password: "secret"}); err != ErrNoHostKeyCallback {
+		t.Fatalf("Create without host key verification = %v", err)
+	}
+}
+
+func TestPool(t *testing.T) {
+	srv := startServer(t, serveOS)
+	fs := connect(t, srv, 2)
+	dir := t.TempDir()
+
+	var files []vfs.File
+	for i := 0; i < 3; i++ {
+		f, err := fs.OpenFile(filepath.Join(dir, string(rune('a'+i))), os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	// The third file shares a connection as the pool is full.
+	if n := srv.count(); n != 2 {
+		t.Fatalf("%d connections, want 2", n)
+	}
+	for _, f := range files {
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs.mutex.Lock()
+	for _, c := range fs.conns {
+		if c.users != 0 {
+			t.Errorf("connection still used by %d", c.users)
+		}
+	}
+	fs.mutex.Unlock()
+
+	// Idle connections are reused.
+	if _, err := fs.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.count(); n != 2 {
+		t.Fatalf("%d connections, want 2", n)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	srv := startServer(t, serveOS)
+	fs := connect(t, srv, 1)
+	name := filepath.Join(t.TempDir(), "f")
+	if err := vfs.WriteFile(fs, name, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.kill()
+	// Open files fail, other operations are repeated on a new connection.
+	if _, err := ioutil.ReadAll(f); err == nil {
+		t.Fatal("read on a lost connection succeeded")
+	}
+	f.Close()
+	fi, err := fs.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 4 {
+		t.Fatalf("Size = %d", fi.Size())
+	}
+	if n := srv.count(); n != 2 {
+		t.Fatalf("%d connections, want 2", n)
+	}
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if len(fs.conns) != 1 {
+		t.Fatalf("%d pooled connections, want 1", len(fs.conns))
+	}
+}
+
+func TestTruncateChmod(t *testing.T) {
+	srv := startServer(t, serveOS)
+	fs := connect(t, srv, 1)
+	name := filepath.Join(t.TempDir(), "f")
+
+	f, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil || string(data) != "012" {
+		t.Fatalf("content = %q, %v", data, err)
+	}
+	fi, err := os.Stat(name)
+	if err != nil || fi.Mode().Perm() != 0640 {
+		t.Fatalf("mode of created file = %v, %v", fi.Mode(), err)
+	}
+
+	if err := fs.Chmod(name, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(name); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("mode after Chmod = %v, %v", fi.Mode(), err)
+	}
+	if err := fs.Chmod(name+".missing", 0600); !os.IsNotExist(err) {
+		t.Fatalf("Chmod of missing file = %v", err)
+	}
+}
+
+func TestPosixRename(t *testing.T) {
+	srv := startServer(t, serveOS)
+	fs := connect(t, srv, 1)
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	ioutil.WriteFile(a, []byte("a"), 0644)
+	ioutil.WriteFile(b, []byte("b"), 0644)
+	if err := fs.Rename(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(b); err != nil || string(data) != "a" {
+		t.Fatalf("content = %q, %v", data, err)
+	}
+}
+
+// failFS fails renames of fail.
+type failFS struct {
+	*memfs.MemFS
+	fail string
+}
+
+func (fs *failFS) Rename(oldpath, newpath string) error {
+	if oldpath == fs.fail {
+		return &os.PathError{Op: "rename", Path: oldpath, Err: os.ErrPermission}
+	}
+	return fs.MemFS.Rename(oldpath, newpath)
+}
+
+func TestRenameWithoutPosixRename(t *testing.T) {
+	if err := sftp.SetSFTPExtensions("hardlink@openssh.com", "statvfs@openssh.com"); err != nil {
+		t.Fatal(err)
+	}
+	defer sftp.SetSFTPExtensions("hardlink@openssh.com", "posix-rename@openssh.com", "statvfs@openssh.com")
+
+	backend := &failFS{MemFS: memfs.Create()}
+	srv := startServer(t, serveVFS(backend))
+	fs := connect(t, srv, 1)
+	if _, ok := fs.conns[0].sftp.HasExtension("posix-rename@openssh.com"); ok {
+		t.Fatal("server supports posix-rename")
+	}
+	for _, name := range []string{"/a", "/b", "/c"} {
+		if err := vfs.WriteFile(backend, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Rename("/a", "/b"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := vfs.ReadFile(backend, "/b"); err != nil || string(data) != "/a" {
+		t.Fatalf("content = %q, %v", data, err)
+	}
+
+	// A failing rename keeps the destination.
+	backend.fail = "/c"
+	if err := fs.Rename("/c", "/b"); !os.IsPermission(err) {
+		t.Fatalf("Rename = %v", err)
+	}
+	if data, err := vfs.ReadFile(backend, "/b"); err != nil || string(data) != "/a" {
+		t.Fatalf("destination = %q, %v", data, err)
+	}
+	fis, err := backend.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range fis {
+		if strings.Contains(fi.Name(), ".rename-") {
+			t.Errorf("temporary file %s left behind", fi.Name())
+		}
+	}
+}
@@ -0,0 +1,562 @@
+package vfs
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	filepath "path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrUnsafePath is returned by ImportTar for entries which would be
+// written outside of the root directory.
+var ErrUnsafePath = errors.New("Path escapes the root directory")
+
+// xattrPrefix is the PAX record prefix of extended attributes.
+const xattrPrefix = "SCHILY.xattr."
+
+// Optional operations used by ExportTar and ImportTar if a Filesystem supports them.
+type (
+	readlinker interface {
+		Readlink(name string) (string, error)
+	}
+	symlinker interface {
+		Symlink(oldname, newname string) error
+	}
+	linker interface {
+		Link(oldname, newname string) error
+	}
+	chmoder interface {
+		Chmod(name string, mode os.FileMode) error
+	}
+	chtimeser interface {
+		Chtimes(name string, atime, mtime time.Time) error
+	}
+	xattrGetter interface {
+		Listxattr(name string) ([]string, error)
+		Getxattr(name, attr string) ([]byte, error)
+	}
+	xattrSetter interface {
+		Setxattr(name, attr string, data []byte) error
+	}
+)
+
+// TarOptions select the entries copied by ExportTar and ImportTar.
+// Names are relative to the root, slash separated and without a leading slash.
+// A nil *TarOptions copies every entry.
+type TarOptions struct {
+	// Include lists path.Match patterns of the entries to copy, every entry if empty.
+	// A pattern matches if it matches the name, its base name or one of its parents.
+	Include []string
+	// Exclude lists path.Match patterns of the entries to skip, matched like Include.
+	// Exclude takes precedence over Include.
+	Exclude []string
+	// Filter is called for every entry left by Include and Exclude,
+	// the entry is skipped if it returns false.
+	Filter func(name string, fi os.FileInfo) bool
+}
+
+// matchAny reports whether a pattern matches name, its base name or a parent.
+func matchAny(patterns []string, name string) bool {
+	for p := name; p != "." && p != "/"; p = filepath.Dir(p) {
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, p); ok {
+				return true
+			}
+			if ok, _ := filepath.Match(pattern, filepath.Base(p)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// excluded reports whether name and, for directories, its contents are skipped.
+func (o *TarOptions) excluded(name string, fi os.FileInfo) bool {
+	if o == nil {
+		return false
+	}
+	if matchAny(o.Exclude, name) {
+		return true
+	}
+	return o.Filter != nil && !o.Filter(name, fi)
+}
+
+// included reports whether name is copied, provided it is not excluded.
+// Directories which are not included are still descended into.
+func (o *TarOptions) included(name string) bool {
+	return o == nil || len(o.Include) == 0 || matchAny(o.Include, name)
+}
+
+// ExportTar writes the tree below the directory root of fs to w as a tar stream.
+// Entry names are relative to root, w is not closed.
+//
+// Directories, regular files and their modes and modification times are always exported.
+// Symlinks are exported if fs has a Readlink(name string) (string, error) method,
+// otherwise their targets are exported.
+// Extended attributes are exported as PAX records if fs has
+// Listxattr(name string) ([]string, error) and Getxattr(name, attr string) ([]byte, error) methods.
+// Other file types are skipped.
+func ExportTar(fs Filesystem, root string, w io.Writer, opts *TarOptions) error {
+	fi, err := fs.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return &os.PathError{Op: "export", Path: root, Err: ErrNotDirectory}
+	}
+	tw := tar.NewWriter(w)
+	if err := exportDir(fs, root, "", tw, opts); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func exportDir(fs Filesystem, root, dir string, tw *tar.Writer, opts *TarOptions) error {
+	fis, err := fs.ReadDir(filepath.Join(root, dir))
+	if err != nil {
+		return err
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	for _, fi := range fis {
+		name := filepath.Join(dir, fi.Name())
+		if opts.excluded(name, fi) {
+			continue
+		}
+		if err := exportEntry(fs, root, name, fi, tw, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportEntry(fs Filesystem, root, name string, fi os.FileInfo, tw *tar.Writer, opts *TarOptions) error {
+	p := filepath.Join(root, name)
+	link := ""
+	if fi.Mode()&os.ModeSymlink != 0 {
+		if rl, ok := fs.(readlinker); ok {
+			target, err := rl.Readlink(p)
+			if err != nil {
+				return err
+			}
+			link = target
+		} else {
+			// Without symlink support the target is exported in place of the link.
+			target, err := fs.Stat(p)
+			if err != nil {
+				return err
+			}
+			fi = target
+		}
+	}
+	if fi.IsDir() && !fi.Mode().IsDir() {
+		// Some backends report directories without os.ModeDir.
+		fi = dirInfo{fi}
+	}
+	if !fi.IsDir() && !fi.Mode().IsRegular() && link == "" {
+		return nil
+	}
+
+	if opts.included(name) {
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if xg, ok := fs.(xattrGetter); ok {
+			if err := exportXattrs(xg, p, hdr); err != nil {
+				return err
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.IsDir() && fi.Mode().IsRegular() {
+			f, err := fs.OpenFile(p, os.O_RDONLY, 0)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if fi.IsDir() {
+		return exportDir(fs, root, name, tw, opts)
+	}
+	return nil
+}
+
+// dirInfo adds os.ModeDir to the mode of a directory.
+type dirInfo struct {
+	os.FileInfo
+}
+
+func (fi dirInfo) Mode() os.FileMode { return fi.FileInfo.Mode() | os.ModeDir }
+
+func exportXattrs(xg xattrGetter, p string, hdr *tar.Header) error {
+	attrs, err := xg.Listxattr(p)
+	if err != nil {
+		return err
+	}
+	for _, attr := range attrs {
+		data, err := xg.Getxattr(p, attr)
+		if err != nil {
+			return err
+		}
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords[xattrPrefix+attr] = string(data)
+	}
+	return nil
+}
+
+// cleanRel returns name cleaned and relative to the root,
+// or false if it is absolute or refers to a parent of the root.
+func cleanRel(name string) (string, bool) {
+	if strings.HasPrefix(name, "/") {
+		return "", false
+	}
+	p := filepath.Clean(name)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", false
+	}
+	return p, true
+}
+
+// importer holds the state of ImportTar.
+type importer struct {
+	fs   Filesystem
+	root string
+	opts *TarOptions
+	// checked directories which are known not to be symlinks.
+	checked map[string]bool
+	// skipped directories whose contents are skipped as well.
+	skipped map[string]bool
+	// dirs get their metadata applied last, as adding entries changes it.
+	dirs []*tar.Header
+}
+
+// ImportTar extracts the tar stream r into the directory root of fs,
+// which is created if it does not exist.
+// Existing files are overwritten.
+//
+// Entries with absolute names or names referring to a parent of root fail
+// with ErrUnsafePath, as do entries which would be written through a symlink
+// and symlinks which could point outside of root: targets must be relative,
+// ".." is only allowed at their start and must not pass through other symlinks.
+// Modes, modification times, symlinks, hardlinks and extended attributes are
+// restored if fs has the corresponding Chmod, Chtimes, Symlink, Link and
+// Setxattr(name, attr string, data []byte) error methods.
+// Hardlinks are copied otherwise, symlinks are skipped and other file types are ignored.
+func ImportTar(fs Filesystem, root string, r io.Reader, opts *TarOptions) error {
+	if err := MkdirAll(fs, root, 0755); err != nil {
+		return err
+	}
+	im := &importer{
+		fs:      fs,
+		root:    root,
+		opts:    opts,
+		checked: map[string]bool{".": true},
+		skipped: map[string]bool{},
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := im.extract(hdr, tr); err != nil {
+			return err
+		}
+	}
+
+	// Children first, so setting a directory read-only does not break its parent.
+	for i := len(im.dirs) - 1; i >= 0; i-- {
+		hdr := im.dirs[i]
+		p := filepath.Join(root, hdr.Name)
+		if err := im.checkParents(hdr.Name); err != nil {
+			return err
+		}
+		if fi, err := fs.Lstat(p); err != nil || !fi.IsDir() || fi.Mode()&os.ModeSymlink != 0 {
+			// Replaced by a later entry.
+			continue
+		}
+		if err := im.setMeta(p, hdr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (im *importer) unsafe(name string) error {
+	return &os.PathError{Op: "import", Path: name, Err: ErrUnsafePath}
+}
+
+func (im *importer) extract(hdr *tar.Header, r io.Reader) error {
+	name, ok := cleanRel(hdr.Name)
+	if !ok {
+		return im.unsafe(hdr.Name)
+	}
+	if name == "." {
+		return nil
+	}
+	for dir := filepath.Dir(name); dir != "."; dir = filepath.Dir(dir) {
+		if im.skipped[dir] {
+			return nil
+		}
+	}
+	fi := hdr.FileInfo()
+	if im.opts.excluded(name, fi) {
+		if fi.IsDir() {
+			im.skipped[name] = true
+		}
+		return nil
+	}
+	if !im.opts.included(name) {
+		return nil
+	}
+	if err := im.checkParents(name); err != nil {
+		return err
+	}
+
+	p := filepath.Join(im.root, name)
+	hdr.Name = name
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := im.replace(name, true); err != nil {
+			return err
+		}
+		if err := MkdirAll(im.fs, p, fi.Mode().Perm()|0700); err != nil {
+			return err
+		}
+		im.dirs = append(im.dirs, hdr)
+		return nil
+	case tar.TypeReg:
+		if err := im.replace(name, false); err != nil {
+			return err
+		}
+		if err := im.writeFile(p, fi.Mode().Perm(), r); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		sl, ok := im.fs.(symlinker)
+		if !ok {
+			return nil
+		}
+		if err := im.checkLink(name, hdr.Linkname); err != nil {
+			return err
+		}
+		if err := im.replace(name, false); err != nil {
+			return err
+		}
+		// Symlinks carry no metadata of their own.
+		return sl.Symlink(hdr.Linkname, p)
+	case tar.TypeLink:
+		target, ok := cleanRel(hdr.Linkname)
+		if !ok || target == "." {
+			return im.unsafe(hdr.Name)
+		}
+		if err := im.checkParents(target); err != nil {
+			return err
+		}
+		if err := im.link(filepath.Join(im.root, target), name); err != nil {
+			return err
+		}
+		return nil
+	default:
+		return nil
+	}
+	return im.setMeta(p, hdr)
+}
+
+// checkParents fails if a parent of name below the root is a symlink,
+// which could redirect the entry outside of the root.
+func (im *importer) checkParents(name string) error {
+	var dirs []string
+	for dir := filepath.Dir(name); !im.checked[dir]; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		fi, err := im.fs.Lstat(filepath.Join(im.root, dirs[i]))
+		if os.IsNotExist(err) {
+			// Missing directories are created by the entry and can not be links.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return im.unsafe(name)
+		}
+		im.checked[dirs[i]] = true
+	}
+	return nil
+}
+
+// checkLink fails if the symlink name pointing to target could resolve outside of the root.
+// The target is checked as written, not cleaned, as the filesystem resolves it
+// segment by segment: ".." may only lead the target, where it ascends through
+// the real parent directories of the link, and the following segments
+// must not pass through existing symlinks.
+func (im *importer) checkLink(name, target string) error {
+	if strings.HasPrefix(target, "/") {
+		return im.unsafe(name)
+	}
+	dir := filepath.Dir(name)
+	var segs []string
+	for _, seg := range strings.Split(target, "/") {
+		if seg != "" && seg != "." {
+			segs = append(segs, seg)
+		}
+	}
+	up, missing := true, false
+	for i, seg := range segs {
+		if seg == ".." {
+			if !up || dir == "." {
+				return im.unsafe(name)
+			}
+			dir = filepath.Dir(dir)
+			continue
+		}
+		up = false
+		dir = filepath.Join(dir, seg)
+		if missing || i == len(segs)-1 {
+			continue
+		}
+		fi, err := im.fs.Lstat(filepath.Join(im.root, dir))
+		if os.IsNotExist(err) {
+			missing = true
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return im.unsafe(name)
+		}
+	}
+	return nil
+}
+
+// forget drops name and its descendants from the checked directories.
+func (im *importer) forget(name string) {
+	for dir := range im.checked {
+		if dir == name || strings.HasPrefix(dir, name+"/") {
+			delete(im.checked, dir)
+		}
+	}
+}
+
+// replace removes an existing entry at name which would otherwise be written through,
+// keeping existing directories if dir is set.
+func (im *importer) replace(name string, dir bool) error {
+	p := filepath.Join(im.root, name)
+	fi, err := im.fs.Lstat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.IsDir() && fi.Mode()&os.ModeSymlink == 0 && dir {
+		return nil
+	}
+	// The entry is replaced, it may become a symlink.
+	im.forget(name)
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return im.fs.Remove(p)
+	}
+	if fi.IsDir() {
+		return RemoveAll(im.fs, p)
+	}
+	if dir || !fi.Mode().IsRegular() {
+		return im.fs.Remove(p)
+	}
+	return nil
+}
+
+func (im *importer) writeFile(p string, perm os.FileMode, r io.Reader) error {
+	if err := MkdirAll(im.fs, filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := im.fs.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// link restores name as hardlink to the already extracted regular file target.
+func (im *importer) link(target, name string) error {
+	fi, err := im.fs.Lstat(target)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() || !fi.Mode().IsRegular() {
+		return &os.PathError{Op: "link", Path: target, Err: os.ErrInvalid}
+	}
+	p := filepath.Join(im.root, name)
+	if err := im.replace(name, false); err != nil {
+		return err
+	}
+	if l, ok := im.fs.(linker); ok {
+		if _, err := im.fs.Lstat(p); err == nil {
+			if err := im.fs.Remove(p); err != nil {
+				return err
+			}
+		}
+		return l.Link(target, p)
+	}
+	src, err := im.fs.OpenFile(target, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return im.writeFile(p, fi.Mode().Perm(), src)
+}
+
+// setMeta applies mode, times and extended attributes of hdr to p where supported.
+func (im *importer) setMeta(p string, hdr *tar.Header) error {
+	if xs, ok := im.fs.(xattrSetter); ok {
+		for key, value := range hdr.PAXRecords {
+			if strings.HasPrefix(key, xattrPrefix) {
+				if err := xs.Setxattr(p, strings.TrimPrefix(key, xattrPrefix), []byte(value)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if cm, ok := im.fs.(chmoder); ok {
+		if err := cm.Chmod(p, hdr.FileInfo().Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if ct, ok := im.fs.(chtimeser); ok && !hdr.ModTime.IsZero() {
+		atime := hdr.AccessTime
+		if atime.IsZero() {
+			atime = hdr.ModTime
+		}
+		if err := ct.Chtimes(p, atime, hdr.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package vfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	filepath "path"
+	"testing"
+)
+
+type tarEntry struct {
+	name, link string
+	typ        byte
+	body       string
+}
+
+func buildTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Linkname: e.link, Typeflag: e.typ, Mode: 0644, Size: int64(len(e.body))}
+		if e.typ == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func tempRoot(t *testing.T) (parent, root string) {
+	parent, err := ioutil.TempDir("", "vfs-tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(parent) })
+	root = filepath.Join(parent, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return parent, root
+}
+
+func TestImportTarSymlinkEscape(t *testing.T) {
+	parent, root := tempRoot(t)
+	archive := buildTar(t, []tarEntry{
+		{name: "a/", typ: tar.TypeDir},
+		{name: "a/x", typ: tar.TypeReg, body: "x"},
+		{name: "s", link: ".", typ: tar.TypeSymlink},
+		{name: "a", link: "s/..", typ: tar.TypeSymlink},
+		{name: "a/evil", typ: tar.TypeReg, body: "evil"},
+	})
+	err := ImportTar(OS(), root, archive, nil)
+	if !errors.Is(err, ErrUnsafePath) {
+		t.Fatalf("ImportTar = %v, want ErrUnsafePath", err)
+	}
+	if _, err := os.Lstat(filepath.Join(parent, "evil")); !os.IsNotExist(err) {
+		t.Fatalf("file written outside of root: %v", err)
+	}
+}
+
+func TestImportTarReplacedParent(t *testing.T) {
+	parent, root := tempRoot(t)
+	outside := filepath.Join(parent, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "ext")); err != nil {
+		t.Fatal(err)
+	}
+	// a is checked as directory first, then replaced by a symlink.
+	archive := buildTar(t, []tarEntry{
+		{name: "a/", typ: tar.TypeDir},
+		{name: "a/x", typ: tar.TypeReg, body: "x"},
+		{name: "a", link: "ext", typ: tar.TypeSymlink},
+		{name: "a/evil", typ: tar.TypeReg, body: "evil"},
+	})
+	err := ImportTar(OS(), root, archive, nil)
+	if !errors.Is(err, ErrUnsafePath) {
+		t.Fatalf("ImportTar = %v, want ErrUnsafePath", err)
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "evil")); !os.IsNotExist(err) {
+		t.Fatalf("file written outside of root: %v", err)
+	}
+}
+
+func TestImportTarLinkTargets(t *testing.T) {
+	for _, tc := range []struct {
+		link string
+		safe bool
+	}{
+		{"x", true},
+		{"./d/x", true},
+		{"../x", true},
+		{"../../x", false},
+		{"/etc/passwd", false},
+		{"d/../x", false},
+		{"../s/x", false},
+		{"../s", true},
+	} {
+		_, root := tempRoot(t)
+		archive := buildTar(t, []tarEntry{
+			{name: "s", link: ".", typ: tar.TypeSymlink},
+			{name: "d/", typ: tar.TypeDir},
+			{name: "d/l", link: tc.link, typ: tar.TypeSymlink},
+		})
+		err := ImportTar(OS(), root, archive, nil)
+		if tc.safe && err != nil {
+			t.Errorf("%q: ImportTar = %v", tc.link, err)
+		}
+		if !tc.safe && !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("%q: ImportTar = %v, want ErrUnsafePath", tc.link, err)
+		}
+	}
+}
+
+func TestTarRoundTrip(t *testing.T) {
+	_, root := tempRoot(t)
+	fs := OS()
+	if err := MkdirAll(fs, filepath.Join(root, "src/d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, filepath.Join(root, "src/d/f"), []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("d/f", filepath.Join(root, "src/l")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := ExportTar(fs, filepath.Join(root, "src"), &buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportTar(fs, filepath.Join(root, "dst"), &buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadFile(fs, filepath.Join(root, "dst/l"))
+	if err != nil || string(data) != "data" {
+		t.Fatalf("ReadFile = %q, %v", data, err)
+	}
+	fi, err := fs.Stat(filepath.Join(root, "dst/d/f"))
+	if err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("Stat = %v, %v", fi, err)
+	}
+}
@@ -0,0 +1,167 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/alexsnet/vfs"
+)
+
+// file reads a regular file of the archive.
+// Files with a known offset are read directly from the archive,
+// others through a tar reader streamed to the entry and positioned at rpos.
+type file struct {
+	fs   *FS
+	name string
+	n    *node
+
+	mutex  sync.Mutex
+	pos    int64
+	tr     *tar.Reader
+	closer io.Closer
+	rpos   int64
+	closed bool
+}
+
+func (f *file) Name() string { return f.name }
+
+func (f *file) Stat() (os.FileInfo, error) { return f.n.info(), nil }
+
+func (f *file) size() int64 { return f.n.data.hdr.Size }
+
+// open streams the archive up to the entry.
+func (f *file) open() error {
+	if f.closer != nil {
+		f.closer.Close()
+	}
+	tr, closer, _, err := f.fs.reader()
+	if err != nil {
+		return err
+	}
+	for i := 0; i <= f.n.data.index; i++ {
+		if _, err := tr.Next(); err != nil {
+			closer.Close()
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	f.tr, f.closer, f.rpos = tr, closer, 0
+	return nil
+}
+
+// readAt reads from the entry at off, the mutex must be held.
+func (f *file) readAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrClosed}
+	}
+	size := f.size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	if d := f.n.data; d.offset >= 0 {
+		if int64(len(p)) > size-off {
+			p = p[:size-off]
+		}
+		n, err := f.fs.r.ReadAt(p, d.offset+off)
+		if err == nil && off+int64(n) == size {
+			err = io.EOF
+		}
+		return n, err
+	}
+
+	if f.tr == nil || off < f.rpos {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if off > f.rpos {
+		n, err := io.CopyN(ioutil.Discard, f.tr, off-f.rpos)
+		f.rpos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := io.ReadFull(f.tr, p)
+	f.rpos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := f.readAt(p, f.pos)
+	f.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("ReadAt: negative offset")
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	n, err := f.readAt(p, off)
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.pos + offset
+	case io.SeekEnd:
+		abs = f.size() + offset
+	default:
+		return 0, errors.New("Seek: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("Seek: negative position")
+	}
+	f.pos = abs
+	return abs, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: vfs.ErrReadOnly}
+}
+
+func (f *file) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: vfs.ErrReadOnly}
+}
+
+func (f *file) Sync() error { return nil }
+
+func (f *file) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	if f.closer != nil {
+		return f.closer.Close()
+	}
+	return nil
+}
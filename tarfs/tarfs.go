@@ -0,0 +1,370 @@
+// Package tarfs provides a read-only vfs.Filesystem backed by a tar archive,
+// optionally compressed with gzip.
+package tarfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	filepath "path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alexsnet/vfs"
+)
+
+// maxLinks is the maximum number of symlinks followed when resolving a path.
+const maxLinks = 40
+
+// ErrTooManyLinks is returned if resolving a path exceeds maxLinks symlinks.
+var ErrTooManyLinks = errors.New("Too many levels of symbolic links")
+
+// entry is the content of a regular file in the archive.
+type entry struct {
+	hdr *tar.Header
+	// index is the position of the header in the archive.
+	index int
+	// offset of the data in an uncompressed archive, -1 if it must be streamed.
+	offset int64
+}
+
+// node is a file, directory or symlink of the archive.
+type node struct {
+	name   string
+	hdr    *tar.Header
+	data   *entry
+	childs map[string]*node
+	// modTime of synthesized directories.
+	modTime time.Time
+}
+
+func (n *node) isDir() bool { return n.childs != nil }
+
+func (n *node) isLink() bool { return n.hdr != nil && n.hdr.Typeflag == tar.TypeSymlink }
+
+func (n *node) info() os.FileInfo {
+	if n.hdr == nil {
+		return &fileInfo{name: n.name, mode: os.ModeDir | 0555, modTime: n.modTime}
+	}
+	fi := &fileInfo{
+		name:    n.name,
+		mode:    n.hdr.FileInfo().Mode(),
+		modTime: n.hdr.ModTime,
+		sys:     n.hdr,
+	}
+	if n.data != nil {
+		fi.size = n.data.hdr.Size
+		// Hardlinks share the mode of their target.
+		fi.mode = n.data.hdr.FileInfo().Mode()
+	}
+	return fi
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	sys     *tar.Header
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+
+// Sys returns the *tar.Header of the entry, or nil for synthesized directories.
+func (fi *fileInfo) Sys() interface{} {
+	if fi.sys == nil {
+		return nil
+	}
+	return fi.sys
+}
+
+// countingReader counts the bytes read, which locates entry data in uncompressed archives.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// A FS serving the entries of a tar archive read-only.
+//
+// Modes, modification times, symlinks and hardlinks are taken from the headers,
+// missing directories are synthesized from the entry paths.
+// Files of uncompressed archives are read directly with ReadAt,
+// files of compressed archives are decompressed from the start of the
+// archive when opened and on backward seeks.
+type FS struct {
+	r          io.ReaderAt
+	size       int64
+	compressed bool
+	root       *node
+	closer     io.Closer
+}
+
+// Open indexes the archive of the given size read from r.
+// Gzip compression is detected automatically.
+func Open(r io.ReaderAt, size int64) (*FS, error) {
+	fs := &FS{r: r, size: size, root: &node{name: "/", childs: map[string]*node{}}}
+	magic := make([]byte, 2)
+	if _, err := r.ReadAt(magic, 0); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		fs.compressed = true
+	}
+
+	tr, closer, cr, err := fs.reader()
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset := int64(-1)
+		if cr != nil && !hdr.FileInfo().Mode().IsDir() && hdr.Typeflag != tar.TypeGNUSparse {
+			offset = cr.n
+		}
+		if err := fs.add(hdr, i, offset); err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// Load opens the archive name of the filesystem src.
+// The archive stays open until Close is called.
+func Load(src vfs.Filesystem, name string) (*FS, error) {
+	fi, err := src.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := src.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := Open(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	fs.closer = f
+	return fs, nil
+}
+
+// Close closes the archive if it has been opened by Load.
+func (fs *FS) Close() error {
+	if fs.closer != nil {
+		return fs.closer.Close()
+	}
+	return nil
+}
+
+// reader returns a tar reader positioned at the start of the archive.
+// For uncompressed archives the returned countingReader tracks the offset.
+func (fs *FS) reader() (*tar.Reader, io.Closer, *countingReader, error) {
+	sr := io.NewSectionReader(fs.r, 0, fs.size)
+	if !fs.compressed {
+		cr := &countingReader{r: sr}
+		return tar.NewReader(cr), ioutil.NopCloser(nil), cr, nil
+	}
+	zr, err := gzip.NewReader(bufio.NewReader(sr))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return tar.NewReader(zr), zr, nil, nil
+}
+
+func split(name string) []string {
+	p := strings.Trim(filepath.Clean("/"+name), "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// add inserts hdr and its parent directories into the tree.
+// Names are cleaned, so entries can not escape the root.
+func (fs *FS) add(hdr *tar.Header, index int, offset int64) error {
+	segs := split(hdr.Name)
+	if segs == nil {
+		return nil
+	}
+	dir := fs.root
+	for _, seg := range segs[:len(segs)-1] {
+		child, ok := dir.childs[seg]
+		if !ok || !child.isDir() {
+			// Later entries replace earlier ones.
+			child = &node{name: seg, childs: map[string]*node{}, modTime: hdr.ModTime}
+			dir.childs[seg] = child
+		}
+		dir = child
+	}
+
+	base := segs[len(segs)-1]
+	n := &node{name: base, hdr: hdr}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if old, ok := dir.childs[base]; ok && old.isDir() {
+			n.childs = old.childs
+		} else {
+			n.childs = map[string]*node{}
+		}
+	case tar.TypeLink:
+		target, err := fs.lookup("link", hdr.Linkname, false)
+		if err != nil || target.data == nil {
+			// Hardlinks to unknown or non-regular entries are skipped.
+			return nil
+		}
+		n.data = target.data
+	case tar.TypeSymlink:
+	case tar.TypeReg, tar.TypeGNUSparse:
+		n.data = &entry{hdr: hdr, index: index, offset: offset}
+	default:
+		// Devices, fifos and other special files are not exposed.
+		return nil
+	}
+	dir.childs[base] = n
+	return nil
+}
+
+// lookup returns the node of name, following symlinks of the parents
+// and of the last segment if follow is set.
+func (fs *FS) lookup(op, name string, follow bool) (*node, error) {
+	links := 0
+	segs := split(name)
+	n := fs.root
+	p := "/"
+	for i := 0; i < len(segs); i++ {
+		if !n.isDir() {
+			return nil, &os.PathError{Op: op, Path: name, Err: vfs.ErrNotDirectory}
+		}
+		child, ok := n.childs[segs[i]]
+		if !ok {
+			return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+		}
+		if child.isLink() && (follow || i < len(segs)-1) {
+			if links++; links > maxLinks {
+				return nil, &os.PathError{Op: op, Path: name, Err: ErrTooManyLinks}
+			}
+			target := child.hdr.Linkname
+			if !strings.HasPrefix(target, "/") {
+				target = filepath.Join(p, target)
+			}
+			// Restart resolution at the link target followed by the remaining segments.
+			segs = append(split(target), segs[i+1:]...)
+			n, p, i = fs.root, "/", -1
+			continue
+		}
+		n = child
+		p = filepath.Join(p, segs[i])
+	}
+	return n, nil
+}
+
+// Readlink returns the target of the symlink name.
+func (fs *FS) Readlink(name string) (string, error) {
+	n, err := fs.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if !n.isLink() {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrInvalid}
+	}
+	return n.hdr.Linkname, nil
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return '/' }
+
+// OpenFile implements vfs.Filesystem.
+// Only os.O_RDONLY is supported, symlinks are followed.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: vfs.ErrReadOnly}
+	}
+	n, err := fs.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if n.isDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: vfs.ErrIsDirectory}
+	}
+	return &file{fs: fs, name: name, n: n}, nil
+}
+
+// Remove implements vfs.Filesystem.
+func (fs *FS) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: vfs.ErrReadOnly}
+}
+
+// Rename implements vfs.Filesystem.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	return &os.PathError{Op: "rename", Path: oldpath, Err: vfs.ErrReadOnly}
+}
+
+// Mkdir implements vfs.Filesystem.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: vfs.ErrReadOnly}
+}
+
+// Stat implements vfs.Filesystem.
+// Symlinks are followed.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	n, err := fs.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	fi := n.info().(*fileInfo)
+	if segs := split(name); segs != nil {
+		fi.name = segs[len(segs)-1]
+	}
+	return fi, nil
+}
+
+// Lstat implements vfs.Filesystem.
+// Symlinks are described, not followed.
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	n, err := fs.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(), nil
+}
+
+// ReadDir implements vfs.Filesystem.
+// Entries are sorted by name, symlinks are not followed.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	n, err := fs.lookup("readdir", path, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.isDir() {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: vfs.ErrNotDirectory}
+	}
+	names := make([]string, 0, len(n.childs))
+	for name := range n.childs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fis := make([]os.FileInfo, len(names))
+	for i, name := range names {
+		fis[i] = n.childs[name].info()
+	}
+	return fis, nil
+}
@@ -0,0 +1,83 @@
+package throttlefs
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket which refills with rate tokens per second
+// up to burst tokens. A nil Limiter does not limit anything.
+type Limiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a full token bucket.
+// It returns nil if rate is not positive, which means unlimited.
+// If burst is not positive, the amount of one second is used.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if b <= 0 {
+		b = rate
+	}
+	if b < 1 {
+		b = 1
+	}
+	return &Limiter{
+		rate:   rate,
+		burst:  b,
+		tokens: b,
+		last:   time.Now(),
+	}
+}
+
+// Burst returns the bucket size.
+func (l *Limiter) Burst() int {
+	if l == nil {
+		return 0
+	}
+	return int(l.burst)
+}
+
+// Wait blocks until n tokens are available and consumes them.
+// Requests larger than the bucket are served in multiple rounds.
+func (l *Limiter) Wait(n int) {
+	if l == nil {
+		return
+	}
+	remaining := float64(n)
+	for remaining > 0 {
+		take := remaining
+		if take > l.burst {
+			take = l.burst
+		}
+		time.Sleep(l.reserve(take))
+		remaining -= take
+	}
+}
+
+// reserve consumes n tokens and returns the time to wait until they are refilled.
+// The bucket may go negative, so concurrent waiters are queued fairly.
+func (l *Limiter) reserve(n float64) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens -= n
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
@@ -0,0 +1,150 @@
+// Package throttlefs provides a vfs.Filesystem wrapper which limits
+// bandwidth and request rate with token buckets.
+package throttlefs
+
+import (
+	"io"
+	"os"
+
+	"github.com/alexsnet/vfs"
+	"github.com/alexsnet/vfs/mountfs"
+)
+
+// Limits configures a throttled filesystem. Zero values mean unlimited.
+type Limits struct {
+	// ReadBytes is the maximum number of bytes read per second.
+	ReadBytes int64
+	// WriteBytes is the maximum number of bytes written per second.
+	WriteBytes int64
+	// Ops is the maximum number of vfs.Filesystem operations per second.
+	// File operations only count against the byte limits.
+	Ops float64
+}
+
+// A FS that throttles all operations of the wrapped filesystem.
+// The limits are shared across all files opened through it.
+type FS struct {
+	vfs.Filesystem
+
+	read  *Limiter
+	write *Limiter
+	ops   *Limiter
+}
+
+// Create returns a throttled file system forwarding to root.
+func Create(root vfs.Filesystem, limits Limits) *FS {
+	return &FS{
+		Filesystem: root,
+		read:       NewLimiter(float64(limits.ReadBytes), 0),
+		write:      NewLimiter(float64(limits.WriteBytes), 0),
+		ops:        NewLimiter(limits.Ops, 0),
+	}
+}
+
+// ThrottleMounts wraps the filesystems of m mounted on the given paths.
+// The root filesystem is selected by `/`. Paths which are not mounted are ignored.
+func ThrottleMounts(m *mountfs.MountFS, limits map[string]Limits) {
+	for path, mount := range m.Mounts() {
+		if l, ok := limits[path]; ok {
+			m.Mount(Create(mount, l), path)
+		}
+	}
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return fs.Filesystem.PathSeparator() }
+
+// OpenFile implements vfs.Filesystem.
+// Reads and writes of the returned file are throttled as well.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	fs.ops.Wait(1)
+	f, err := fs.Filesystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &file{File: f, fs: fs}, nil
+}
+
+// Remove implements vfs.Filesystem.
+func (fs *FS) Remove(name string) error {
+	fs.ops.Wait(1)
+	return fs.Filesystem.Remove(name)
+}
+
+// Rename implements vfs.Filesystem.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	fs.ops.Wait(1)
+	return fs.Filesystem.Rename(oldpath, newpath)
+}
+
+// Mkdir implements vfs.Filesystem.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	fs.ops.Wait(1)
+	return fs.Filesystem.Mkdir(name, perm)
+}
+
+// Stat implements vfs.Filesystem.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	fs.ops.Wait(1)
+	return fs.Filesystem.Stat(name)
+}
+
+// Lstat implements vfs.Filesystem.
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	fs.ops.Wait(1)
+	return fs.Filesystem.Lstat(name)
+}
+
+// ReadDir implements vfs.Filesystem.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	fs.ops.Wait(1)
+	return fs.Filesystem.ReadDir(path)
+}
+
+// file throttles reads and writes with the limiters of its filesystem.
+type file struct {
+	vfs.File
+	fs *FS
+}
+
+// limit shortens p to the burst size of l.
+func limit(p []byte, l *Limiter) []byte {
+	if b := l.Burst(); b > 0 && len(p) > b {
+		return p[:b]
+	}
+	return p
+}
+
+// Read reads at most one burst and waits for the bytes actually read.
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.File.Read(limit(p, f.fs.read))
+	f.fs.read.Wait(n)
+	return n, err
+}
+
+// ReadAt waits for the bytes actually read.
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	f.fs.read.Wait(n)
+	return n, err
+}
+
+// Write writes p in chunks of one burst and waits before each chunk.
+// A chunk written short without error fails with io.ErrShortWrite.
+func (f *file) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := limit(p, f.fs.write)
+		f.fs.write.Wait(len(chunk))
+		n, err := f.File.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n < len(chunk) {
+			return written, io.ErrShortWrite
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
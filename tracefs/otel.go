@@ -0,0 +1,62 @@
+package tracefs
+
+import (
+	"context"
+)
+
+// OTelTracer is the subset of an OpenTelemetry-style tracer used by OTel.
+//
+// OpenTelemetry spans take typed attributes, so a thin shim is needed, e.g.:
+//
+//	type shim struct{ trace.Tracer }
+//
+//	func (s shim) Start(ctx context.Context, name string) (context.Context, tracefs.OTelSpan) {
+//		ctx, span := s.Tracer.Start(ctx, name)
+//		return ctx, spanShim{span}
+//	}
+type OTelTracer interface {
+	Start(ctx context.Context, name string) (context.Context, OTelSpan)
+}
+
+// OTelSpan is the subset of an OpenTelemetry-style span used by OTel.
+type OTelSpan interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// OTel adapts an OpenTelemetry-style tracer to a Tracer.
+// Spans are started as children of the span carried by the context passed to StartSpan.
+func OTel(t OTelTracer) Tracer {
+	return otelTracer{t}
+}
+
+type otelTracer struct {
+	tracer OTelTracer
+}
+
+func (t otelTracer) StartSpan(ctx context.Context, op string, attrs ...Attr) (context.Context, Span) {
+	ctx, span := t.tracer.Start(ctx, op)
+	s := otelSpan{span}
+	s.SetAttrs(Attr{AttrOp, op})
+	s.SetAttrs(attrs...)
+	return ctx, s
+}
+
+type otelSpan struct {
+	span OTelSpan
+}
+
+func (s otelSpan) SetAttrs(attrs ...Attr) {
+	for _, a := range attrs {
+		s.span.SetAttribute(a.Key, a.Value)
+	}
+}
+
+func (s otelSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetAttribute(AttrError, err.Error())
+	}
+	s.span.End()
+}
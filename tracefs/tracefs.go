@@ -0,0 +1,208 @@
+// Package tracefs provides a vfs.Filesystem wrapper which starts a span
+// for every filesystem and file operation.
+package tracefs
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/alexsnet/vfs"
+)
+
+// A FS that traces every vfs.Filesystem and vfs.File operation.
+type FS struct {
+	vfs.Filesystem
+
+	// Tracer starts the spans.
+	Tracer Tracer
+
+	ctx context.Context
+}
+
+// Create returns a traced file system forwarding to root.
+func Create(root vfs.Filesystem, t Tracer) *FS {
+	return &FS{Filesystem: root, Tracer: t, ctx: context.Background()}
+}
+
+// WithContext returns a file system sharing root and tracer
+// whose spans are children of the span carried by ctx.
+func (fs *FS) WithContext(ctx context.Context) *FS {
+	return &FS{Filesystem: fs.Filesystem, Tracer: fs.Tracer, ctx: ctx}
+}
+
+// SpanFilesystem is implemented by file systems tracing their own operations, like s3fs.S3FS.
+// A FS wrapping one forwards every operation to the file system returned by WithSpan,
+// with ctx carrying the span of the operation, so their spans become its children.
+type SpanFilesystem interface {
+	vfs.Filesystem
+	WithSpan(ctx context.Context) vfs.Filesystem
+}
+
+// WithSpan implements SpanFilesystem.
+func (fs *FS) WithSpan(ctx context.Context) vfs.Filesystem {
+	return fs.WithContext(ctx)
+}
+
+// start starts a span as child of the span carried by the context of the FS.
+// The returned context carries the new span.
+func (fs *FS) start(op string, attrs ...Attr) (context.Context, Span) {
+	ctx := fs.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return Start(ctx, fs.Tracer, op, attrs...)
+}
+
+// inner returns the wrapped file system, which receives ctx if it is a SpanFilesystem.
+func (fs *FS) inner(ctx context.Context) vfs.Filesystem {
+	if sfs, ok := fs.Filesystem.(SpanFilesystem); ok {
+		return sfs.WithSpan(ctx)
+	}
+	return fs.Filesystem
+}
+
+// end finishes the span, io.EOF is not recorded as error.
+func end(span Span, err error) {
+	if err == io.EOF {
+		err = nil
+	}
+	span.End(err)
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return fs.Filesystem.PathSeparator() }
+
+// OpenFile implements vfs.Filesystem.
+// The returned file is traced as well.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	ctx, span := fs.start("open", Attr{AttrPath, name}, Attr{"flag", flag})
+	f, err := fs.inner(ctx).OpenFile(name, flag, perm)
+	end(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &file{File: f, fs: fs, ctx: ctx, name: name}, nil
+}
+
+// Remove implements vfs.Filesystem.
+func (fs *FS) Remove(name string) error {
+	ctx, span := fs.start("remove", Attr{AttrPath, name})
+	err := fs.inner(ctx).Remove(name)
+	end(span, err)
+	return err
+}
+
+// Rename implements vfs.Filesystem.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	ctx, span := fs.start("rename", Attr{AttrPath, oldpath}, Attr{"newpath", newpath})
+	err := fs.inner(ctx).Rename(oldpath, newpath)
+	end(span, err)
+	return err
+}
+
+// Mkdir implements vfs.Filesystem.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	ctx, span := fs.start("mkdir", Attr{AttrPath, name})
+	err := fs.inner(ctx).Mkdir(name, perm)
+	end(span, err)
+	return err
+}
+
+// Stat implements vfs.Filesystem.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	ctx, span := fs.start("stat", Attr{AttrPath, name})
+	fi, err := fs.inner(ctx).Stat(name)
+	end(span, err)
+	return fi, err
+}
+
+// Lstat implements vfs.Filesystem.
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	ctx, span := fs.start("lstat", Attr{AttrPath, name})
+	fi, err := fs.inner(ctx).Lstat(name)
+	end(span, err)
+	return fi, err
+}
+
+// ReadDir implements vfs.Filesystem.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	ctx, span := fs.start("readdir", Attr{AttrPath, path})
+	fis, err := fs.inner(ctx).ReadDir(path)
+	span.SetAttrs(Attr{"entries", len(fis)})
+	end(span, err)
+	return fis, err
+}
+
+// file traces all operations with the Tracer of its filesystem.
+type file struct {
+	vfs.File
+	fs *FS
+	// ctx carries the span of the open operation, the parent of all file operations.
+	ctx  context.Context
+	name string
+}
+
+func (f *file) start(op string, attrs ...Attr) Span {
+	_, span := Start(f.ctx, f.fs.Tracer, op, append([]Attr{{AttrPath, f.name}}, attrs...)...)
+	return span
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	span := f.start("read")
+	n, err := f.File.Read(p)
+	span.SetAttrs(Attr{AttrBytes, n})
+	end(span, err)
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	span := f.start("readat", Attr{"offset", off})
+	n, err := f.File.ReadAt(p, off)
+	span.SetAttrs(Attr{AttrBytes, n})
+	end(span, err)
+	return n, err
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	span := f.start("write")
+	n, err := f.File.Write(p)
+	span.SetAttrs(Attr{AttrBytes, n})
+	end(span, err)
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	span := f.start("seek", Attr{"offset", offset}, Attr{"whence", whence})
+	n, err := f.File.Seek(offset, whence)
+	end(span, err)
+	return n, err
+}
+
+func (f *file) Sync() error {
+	span := f.start("sync")
+	err := f.File.Sync()
+	end(span, err)
+	return err
+}
+
+func (f *file) Truncate(size int64) error {
+	span := f.start("truncate", Attr{"size", size})
+	err := f.File.Truncate(size)
+	end(span, err)
+	return err
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	span := f.start("fstat")
+	fi, err := f.File.Stat()
+	end(span, err)
+	return fi, err
+}
+
+func (f *file) Close() error {
+	span := f.start("close")
+	err := f.File.Close()
+	end(span, err)
+	return err
+}
@@ -0,0 +1,100 @@
+package tracefs
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Attribute keys set on the spans of this package and s3fs.
+const (
+	AttrOp    = "op"
+	AttrPath  = "path"
+	AttrBytes = "bytes"
+	AttrError = "error"
+)
+
+// Attr is a key value pair attached to a Span.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// Tracer starts spans for traced operations.
+// Implementations must be safe for concurrent use.
+type Tracer interface {
+	// StartSpan starts a span named after the operation as child of the span carried by ctx.
+	// The returned context carries the new span, so nested operations become its children.
+	StartSpan(ctx context.Context, op string, attrs ...Attr) (context.Context, Span)
+}
+
+// Span represents a single traced operation.
+type Span interface {
+	// SetAttrs adds attributes to the span.
+	SetAttrs(attrs ...Attr)
+	// End finishes the span. A non-nil err is recorded as error attribute.
+	End(err error)
+}
+
+// Start starts a span on t as child of the span carried by ctx, see Tracer.
+// A nil Tracer returns ctx and a Span without any effect.
+func Start(ctx context.Context, t Tracer, op string, attrs ...Attr) (context.Context, Span) {
+	if t == nil {
+		return ctx, nopSpan{}
+	}
+	return t.StartSpan(ctx, op, attrs...)
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttrs(attrs ...Attr) {}
+func (nopSpan) End(err error)          {}
+
+// Logger returns a Tracer which logs every finished span with its duration.
+// Spans faster than threshold are not logged, spans are not nested.
+func Logger(threshold time.Duration) Tracer {
+	return logTracer{threshold}
+}
+
+type logTracer struct {
+	threshold time.Duration
+}
+
+func (t logTracer) StartSpan(ctx context.Context, op string, attrs ...Attr) (context.Context, Span) {
+	return ctx, &logSpan{
+		threshold: t.threshold,
+		start:     time.Now(),
+		fields:    attrFields(logrus.Fields{AttrOp: op}, attrs),
+	}
+}
+
+type logSpan struct {
+	threshold time.Duration
+	start     time.Time
+	fields    logrus.Fields
+}
+
+func (s *logSpan) SetAttrs(attrs ...Attr) {
+	attrFields(s.fields, attrs)
+}
+
+func (s *logSpan) End(err error) {
+	d := time.Since(s.start)
+	if d < s.threshold {
+		return
+	}
+	entry := logrus.WithFields(s.fields).WithField("duration", d)
+	if err != nil {
+		entry.WithError(err).Warn("span")
+		return
+	}
+	entry.Info("span")
+}
+
+func attrFields(fields logrus.Fields, attrs []Attr) logrus.Fields {
+	for _, a := range attrs {
+		fields[a.Key] = a.Value
+	}
+	return fields
+}
@@ -0,0 +1,329 @@
+// Package trashfs provides a vfs.Filesystem wrapper which moves removed
+// files and directories into a recycle bin instead of deleting them.
+package trashfs
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	filepath "path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexsnet/vfs"
+	"github.com/alexsnet/vfs/internal/move"
+)
+
+// DefaultDir is the directory holding the trash if Options.Dir is empty.
+const DefaultDir = "/.trash"
+
+const (
+	idFormat = "20060102T150405.000000000Z"
+	dataName = "data"
+	infoName = "info.json"
+)
+
+// expireInterval is the minimum time between two automatic expiries.
+const expireInterval = time.Minute
+
+var (
+	// ErrNoItem is returned if an item is not in the trash.
+	ErrNoItem = errors.New("Item is not in the trash")
+	// ErrContainsTrash is returned when removing a directory containing the trash.
+	ErrContainsTrash = errors.New("Directory contains the trash")
+	// ErrTrashDir is returned when accessing paths inside the trash directory
+	// other than by Remove, or renaming the trash directory.
+	ErrTrashDir = errors.New("Operation not permitted on the trash directory")
+)
+
+// Item describes a removed file or directory.
+type Item struct {
+	ID      string
+	Path    string
+	Deleted time.Time
+	IsDir   bool
+}
+
+// Options of a trash filesystem.
+type Options struct {
+	// Dir is the hidden directory holding the trash, DefaultDir is used if empty.
+	Dir string
+	// Expire is the time after which items are purged, 0 means never.
+	Expire time.Duration
+}
+
+// A FS that moves removed files into a trash directory.
+//
+// Every item is stored in its own directory below Options.Dir,
+// next to a JSON file recording the original path and deletion time.
+// Removing a non-empty directory moves it as a whole, so vfs.RemoveAll
+// trashes a directory tree as a single item.
+// Options.Dir is hidden from ReadDir and can not be opened, inspected
+// or renamed, Remove deletes items inside it permanently.
+type FS struct {
+	vfs.Filesystem
+
+	opts       Options
+	mutex      sync.Mutex
+	lastID     time.Time
+	lastExpire time.Time
+}
+
+// Create returns a trash file system forwarding to root.
+func Create(root vfs.Filesystem, opts Options) *FS {
+	if opts.Dir == "" {
+		opts.Dir = DefaultDir
+	}
+	opts.Dir = filepath.Clean("/" + opts.Dir)
+	return &FS{Filesystem: root, opts: opts}
+}
+
+// hidden reports whether name is inside the trash directory.
+func (fs *FS) hidden(name string) bool {
+	name = filepath.Clean("/" + name)
+	return name == fs.opts.Dir || strings.HasPrefix(name, fs.opts.Dir+"/")
+}
+
+// reserved reports whether name is inside or contains the trash directory.
+func (fs *FS) reserved(name string) bool {
+	name = filepath.Clean("/" + name)
+	return fs.hidden(name) || name == "/" || strings.HasPrefix(fs.opts.Dir, name+"/")
+}
+
+// newID returns a unique id for an item removed now.
+func (fs *FS) newID(now time.Time) string {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if !now.After(fs.lastID) {
+		now = fs.lastID.Add(time.Nanosecond)
+	}
+	fs.lastID = now
+	return now.Format(idFormat)
+}
+
+// Remove implements vfs.Filesystem.
+// The file or directory, including its contents, is moved to the trash.
+// Items inside the trash directory are removed permanently.
+func (fs *FS) Remove(name string) error {
+	if fs.hidden(name) {
+		return fs.Filesystem.Remove(name)
+	}
+	clean := filepath.Clean("/" + name)
+	if fs.reserved(clean) {
+		return &os.PathError{Op: "remove", Path: name, Err: ErrContainsTrash}
+	}
+	fi, err := fs.Filesystem.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if err := fs.expire(false); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	item := Item{
+		ID:      fs.newID(now),
+		Path:    clean,
+		Deleted: now,
+		IsDir:   fi.IsDir(),
+	}
+	dir := filepath.Join(fs.opts.Dir, item.ID)
+	if err := vfs.MkdirAll(fs.Filesystem, dir, 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(&item)
+	if err != nil {
+		return err
+	}
+	if err := vfs.WriteFile(fs.Filesystem, filepath.Join(dir, infoName), b, 0600); err != nil {
+		return err
+	}
+	if err := move.Rename(fs.Filesystem, name, filepath.Join(dir, dataName)); err != nil {
+		vfs.RemoveAll(fs.Filesystem, dir)
+		return err
+	}
+	return nil
+}
+
+// item reads the metadata of the item id.
+func (fs *FS) item(id string) (*Item, error) {
+	if _, err := time.Parse(idFormat, id); err != nil {
+		return nil, &os.PathError{Op: "trash", Path: id, Err: ErrNoItem}
+	}
+	b, err := vfs.ReadFile(fs.Filesystem, filepath.Join(fs.opts.Dir, id, infoName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = &os.PathError{Op: "trash", Path: id, Err: ErrNoItem}
+		}
+		return nil, err
+	}
+	item := &Item{}
+	if err := json.Unmarshal(b, item); err != nil {
+		return nil, err
+	}
+	item.ID = id
+	return item, nil
+}
+
+// List returns the items in the trash, oldest first.
+func (fs *FS) List() ([]Item, error) {
+	fis, err := fs.Filesystem.ReadDir(fs.opts.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Item{}, nil
+		}
+		return nil, err
+	}
+	items := make([]Item, 0, len(fis))
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		item, err := fs.item(fi.Name())
+		if err != nil {
+			// Skip incomplete items, e.g. of an interrupted Remove.
+			continue
+		}
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items, nil
+}
+
+// Restore moves the item back to its original path.
+// It fails if the path exists, the parent directories are created as needed.
+func (fs *FS) Restore(id string) error {
+	item, err := fs.item(id)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.Filesystem.Lstat(item.Path); err == nil {
+		return &os.PathError{Op: "restore", Path: item.Path, Err: os.ErrExist}
+	}
+	if err := vfs.MkdirAll(fs.Filesystem, filepath.Dir(item.Path), 0755); err != nil {
+		return err
+	}
+	dir := filepath.Join(fs.opts.Dir, id)
+	if err := move.Rename(fs.Filesystem, filepath.Join(dir, dataName), item.Path); err != nil {
+		return err
+	}
+	return vfs.RemoveAll(fs.Filesystem, dir)
+}
+
+// Purge permanently deletes the item.
+func (fs *FS) Purge(id string) error {
+	if _, err := fs.item(id); err != nil {
+		return err
+	}
+	return vfs.RemoveAll(fs.Filesystem, filepath.Join(fs.opts.Dir, id))
+}
+
+// Empty permanently deletes all items.
+func (fs *FS) Empty() error {
+	return vfs.RemoveAll(fs.Filesystem, fs.opts.Dir)
+}
+
+// Expire permanently deletes the items older than Options.Expire.
+// It is called automatically by Remove at most once a minute.
+func (fs *FS) Expire() error {
+	return fs.expire(true)
+}
+
+func (fs *FS) expire(force bool) error {
+	if fs.opts.Expire <= 0 {
+		return nil
+	}
+	fs.mutex.Lock()
+	if !force && time.Since(fs.lastExpire) < expireInterval {
+		fs.mutex.Unlock()
+		return nil
+	}
+	fs.lastExpire = time.Now()
+	fs.mutex.Unlock()
+
+	items, err := fs.List()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if time.Since(item.Deleted) <= fs.opts.Expire {
+			break
+		}
+		if err := fs.Purge(item.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return fs.Filesystem.PathSeparator() }
+
+// OpenFile implements vfs.Filesystem.
+// Files inside the trash directory can not be opened.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	if fs.hidden(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrTrashDir}
+	}
+	return fs.Filesystem.OpenFile(name, flag, perm)
+}
+
+// Rename implements vfs.Filesystem.
+// Neither path may be inside or contain the trash directory.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	if fs.reserved(oldpath) {
+		return &os.PathError{Op: "rename", Path: oldpath, Err: ErrTrashDir}
+	}
+	if fs.reserved(newpath) {
+		return &os.PathError{Op: "rename", Path: newpath, Err: ErrTrashDir}
+	}
+	return fs.Filesystem.Rename(oldpath, newpath)
+}
+
+// Mkdir implements vfs.Filesystem.
+// Directories can not be made inside the trash directory.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	if fs.hidden(name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: ErrTrashDir}
+	}
+	return fs.Filesystem.Mkdir(name, perm)
+}
+
+// Stat implements vfs.Filesystem.
+// The trash directory can not be inspected.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	if fs.hidden(name) {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: ErrTrashDir}
+	}
+	return fs.Filesystem.Stat(name)
+}
+
+// Lstat implements vfs.Filesystem.
+// The trash directory can not be inspected.
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	if fs.hidden(name) {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: ErrTrashDir}
+	}
+	return fs.Filesystem.Lstat(name)
+}
+
+// ReadDir implements vfs.Filesystem.
+// The trash directory is hidden and can not be listed.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	if fs.hidden(path) {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: ErrTrashDir}
+	}
+	fis, err := fs.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	res := fis[:0]
+	for _, fi := range fis {
+		if !fs.hidden(filepath.Join("/", path, fi.Name())) {
+			res = append(res, fi)
+		}
+	}
+	return res, nil
+}
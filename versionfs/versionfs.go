@@ -0,0 +1,426 @@
+// Package versionfs provides a vfs.Filesystem wrapper which keeps the
+// previous revisions of files that are overwritten, truncated, renamed over or removed.
+package versionfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	filepath "path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexsnet/vfs"
+	"github.com/alexsnet/vfs/s3fs"
+)
+
+// DefaultDir is the directory holding the revisions if Options.Dir is empty.
+const DefaultDir = "/.versions"
+
+// idFormat formats version ids, they sort in chronological order.
+const idFormat = "20060102T150405.000000000Z"
+
+var (
+	// ErrNoVersion is returned if a version does not exist.
+	ErrNoVersion = errors.New("Version does not exist")
+	// ErrVersionDir is returned when modifying the revision directory or accessing paths inside it.
+	ErrVersionDir = errors.New("Operation not permitted on the version directory")
+)
+
+// versioner is implemented by file systems keeping versions themselves, such as s3fs.S3FS.
+type versioner interface {
+	Versioning() (bool, error)
+	ListVersions(name string) ([]s3fs.ObjectVersion, error)
+	OpenVersion(name, versionID string) (vfs.File, error)
+	RemoveVersion(name, versionID string) error
+}
+
+// Version describes a prior revision of a file.
+type Version struct {
+	ID   string
+	Time time.Time
+	Size int64
+}
+
+// Options of a versioning filesystem.
+type Options struct {
+	// Dir is the hidden directory holding the revisions, DefaultDir is used if empty.
+	Dir string
+	// MaxVersions is the maximum number of revisions kept per file, 0 means unlimited.
+	MaxVersions int
+	// MaxAge is the maximum age of kept revisions, 0 means unlimited.
+	MaxAge time.Duration
+}
+
+// A FS that snapshots files before they are modified.
+//
+// Revisions are copies stored below Options.Dir, which is hidden from ReadDir
+// and can not be opened, inspected or modified.
+// On a s3fs.S3FS with bucket versioning enabled no copies are made,
+// the versions of the bucket are used instead.
+type FS struct {
+	vfs.Filesystem
+
+	opts   Options
+	native versioner
+	mutex  sync.Mutex
+	lastID time.Time
+}
+
+// Create returns a versioning file system forwarding to root.
+// It checks whether root keeps versions itself, like a s3fs.S3FS with bucket versioning enabled.
+// Revisions are copied if the versioning status can not be read, e.g. for lack of permission.
+func Create(root vfs.Filesystem, opts Options) (*FS, error) {
+	if opts.Dir == "" {
+		opts.Dir = DefaultDir
+	}
+	opts.Dir = filepath.Clean("/" + opts.Dir)
+	fs := &FS{Filesystem: root, opts: opts}
+	if v, ok := root.(versioner); ok {
+		if enabled, err := v.Versioning(); err == nil && enabled {
+			fs.native = v
+		}
+	}
+	return fs, nil
+}
+
+// versionDir returns the directory holding the revisions of name.
+func (fs *FS) versionDir(name string) string {
+	return filepath.Join(fs.opts.Dir, filepath.Clean("/"+name))
+}
+
+// hidden reports whether name is inside the revision directory.
+func (fs *FS) hidden(name string) bool {
+	name = filepath.Clean("/" + name)
+	return name == fs.opts.Dir || strings.HasPrefix(name, fs.opts.Dir+"/")
+}
+
+// reserved reports whether name is inside or contains the revision directory.
+func (fs *FS) reserved(name string) bool {
+	name = filepath.Clean("/" + name)
+	return fs.hidden(name) || name == "/" || strings.HasPrefix(fs.opts.Dir, name+"/")
+}
+
+// newID returns a unique id for a revision made now.
+func (fs *FS) newID() string {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	now := time.Now().UTC()
+	if !now.After(fs.lastID) {
+		now = fs.lastID.Add(time.Nanosecond)
+	}
+	fs.lastID = now
+	return now.Format(idFormat)
+}
+
+// snapshot copies the current contents of name to a new revision.
+// It does nothing if name does not exist or is a directory.
+// With native versioning it only prunes the versions of name,
+// the new version is made by the backend.
+func (fs *FS) snapshot(name string) error {
+	fi, err := fs.Filesystem.Stat(name)
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+	if fs.native != nil {
+		return fs.prune(name)
+	}
+	dir := fs.versionDir(name)
+	if err := vfs.MkdirAll(fs.Filesystem, dir, 0755); err != nil {
+		return err
+	}
+	if err := copyFile(fs.Filesystem, name, filepath.Join(dir, fs.newID())); err != nil {
+		return err
+	}
+	return fs.prune(name)
+}
+
+// snapshotTree snapshots name and, if it is a directory, every file below it.
+func (fs *FS) snapshotTree(name string) error {
+	fi, err := fs.Filesystem.Stat(name)
+	if err != nil || !fi.IsDir() {
+		return fs.snapshot(name)
+	}
+	fis, err := fs.Filesystem.ReadDir(name)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if err := fs.snapshotTree(filepath.Join(name, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(fs vfs.Filesystem, src, dst string) error {
+	in, err := fs.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := fs.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// ListVersions returns the revisions of name, oldest first.
+// The current contents are not included.
+func (fs *FS) ListVersions(name string) ([]Version, error) {
+	if fs.native != nil {
+		return fs.nativeVersions(name)
+	}
+	fis, err := fs.Filesystem.ReadDir(fs.versionDir(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Version{}, nil
+		}
+		return nil, err
+	}
+	versions := make([]Version, 0, len(fis))
+	for _, fi := range fis {
+		t, err := time.Parse(idFormat, fi.Name())
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		versions = append(versions, Version{ID: fi.Name(), Time: t, Size: fi.Size()})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].ID < versions[j].ID })
+	return versions, nil
+}
+
+// nativeVersions returns the noncurrent versions of the bucket, oldest first.
+func (fs *FS) nativeVersions(name string) ([]Version, error) {
+	objs, err := fs.native.ListVersions(name)
+	if err != nil {
+		return nil, err
+	}
+	versions := []Version{}
+	for i := len(objs) - 1; i >= 0; i-- {
+		o := objs[i]
+		if o.IsLatest || o.DeleteMarker {
+			continue
+		}
+		t, _ := time.Parse(time.RFC3339Nano, o.LastModified)
+		versions = append(versions, Version{ID: o.VersionID, Time: t, Size: o.Size})
+	}
+	return versions, nil
+}
+
+// OpenVersion opens a revision of name for reading.
+func (fs *FS) OpenVersion(name, id string) (vfs.File, error) {
+	if fs.native != nil {
+		return fs.native.OpenVersion(name, id)
+	}
+	if _, err := time.Parse(idFormat, id); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrNoVersion}
+	}
+	f, err := fs.Filesystem.OpenFile(filepath.Join(fs.versionDir(name), id), os.O_RDONLY, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = &os.PathError{Op: "open", Path: name, Err: ErrNoVersion}
+		}
+		return nil, err
+	}
+	return vfs.ReadOnlyFile(f), nil
+}
+
+// Restore replaces the contents of name with a revision.
+// The current contents become a revision themselves.
+func (fs *FS) Restore(name, id string) error {
+	in, err := fs.OpenVersion(name, id)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// prune removes the revisions of name exceeding the retention limits.
+func (fs *FS) prune(name string) error {
+	if fs.opts.MaxVersions <= 0 && fs.opts.MaxAge <= 0 {
+		return nil
+	}
+	versions, err := fs.ListVersions(name)
+	if err != nil {
+		return err
+	}
+	for i, v := range versions {
+		expired := fs.opts.MaxAge > 0 && time.Since(v.Time) > fs.opts.MaxAge
+		excess := fs.opts.MaxVersions > 0 && len(versions)-i > fs.opts.MaxVersions
+		if !expired && !excess {
+			continue
+		}
+		if fs.native != nil {
+			err = fs.native.RemoveVersion(name, v.ID)
+		} else {
+			err = fs.Filesystem.Remove(filepath.Join(fs.versionDir(name), v.ID))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune enforces the retention limits on the revisions of all files.
+// With native versioning all existing files are walked,
+// the versions of removed files are left to the lifecycle rules of the bucket.
+func (fs *FS) Prune() error {
+	if fs.native != nil {
+		return fs.pruneNative("/")
+	}
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		fis, err := fs.Filesystem.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			if !fi.IsDir() {
+				continue
+			}
+			p := filepath.Join(dir, fi.Name())
+			if err := walk(p); err != nil {
+				return err
+			}
+			if err := fs.prune(strings.TrimPrefix(p, fs.opts.Dir)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := walk(fs.opts.Dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// pruneNative prunes the versions of every file below dir.
+func (fs *FS) pruneNative(dir string) error {
+	fis, err := fs.Filesystem.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		p := filepath.Join(dir, fi.Name())
+		if fi.IsDir() {
+			err = fs.pruneNative(p)
+		} else {
+			err = fs.prune(p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return fs.Filesystem.PathSeparator() }
+
+// OpenFile implements vfs.Filesystem.
+// Opening an existing file for writing snapshots it first.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	if fs.hidden(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrVersionDir}
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_TRUNC) != 0 {
+		if err := fs.snapshot(name); err != nil {
+			return nil, err
+		}
+	}
+	return fs.Filesystem.OpenFile(name, flag, perm)
+}
+
+// Remove implements vfs.Filesystem.
+// A file is snapshot first, as is every file below a directory,
+// since some backends remove directories with their content.
+func (fs *FS) Remove(name string) error {
+	if fs.reserved(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: ErrVersionDir}
+	}
+	if err := fs.snapshotTree(name); err != nil {
+		return err
+	}
+	return fs.Filesystem.Remove(name)
+}
+
+// Rename implements vfs.Filesystem.
+// An existing newpath is snapshot first.
+// The revisions of oldpath stay with oldpath.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	if fs.reserved(oldpath) {
+		return &os.PathError{Op: "rename", Path: oldpath, Err: ErrVersionDir}
+	}
+	if fs.reserved(newpath) {
+		return &os.PathError{Op: "rename", Path: newpath, Err: ErrVersionDir}
+	}
+	if err := fs.snapshot(newpath); err != nil {
+		return err
+	}
+	return fs.Filesystem.Rename(oldpath, newpath)
+}
+
+// Mkdir implements vfs.Filesystem.
+// Directories can not be made inside the revision directory.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	if fs.hidden(name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: ErrVersionDir}
+	}
+	return fs.Filesystem.Mkdir(name, perm)
+}
+
+// Stat implements vfs.Filesystem.
+// The revision directory can not be inspected.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	if fs.hidden(name) {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: ErrVersionDir}
+	}
+	return fs.Filesystem.Stat(name)
+}
+
+// Lstat implements vfs.Filesystem.
+// The revision directory can not be inspected.
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	if fs.hidden(name) {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: ErrVersionDir}
+	}
+	return fs.Filesystem.Lstat(name)
+}
+
+// ReadDir implements vfs.Filesystem.
+// The revision directory is hidden and can not be listed.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	if fs.hidden(path) {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: ErrVersionDir}
+	}
+	fis, err := fs.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	res := fis[:0]
+	for _, fi := range fis {
+		if !fs.hidden(filepath.Join("/", path, fi.Name())) {
+			res = append(res, fi)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,218 @@
+package vfshttp
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	filepath "path"
+	"sort"
+	"strings"
+
+	"github.com/alexsnet/vfs"
+	"github.com/alexsnet/vfs/s3fs"
+)
+
+// Options configure a Handler.
+type Options struct {
+	// Writable enables uploads with PUT and removal with DELETE.
+	Writable bool
+	// ETag returns the entity tag of a file including the quotes, DefaultETag if nil.
+	// No ETag header is sent if it returns "".
+	ETag func(fi os.FileInfo) string
+}
+
+// DefaultETag returns the ETag stored by s3fs in fi.Sys() if available,
+// otherwise a weak ETag derived from the size and modification time.
+func DefaultETag(fi os.FileInfo) string {
+	if st, ok := fi.Sys().(*s3fs.Stat); ok && st != nil && st.ETag != "" {
+		return `"` + st.ETag + `"`
+	}
+	if fi.ModTime().IsZero() {
+		return ""
+	}
+	return fmt.Sprintf(`W/"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
+}
+
+// A Handler serves the files of a filesystem.
+//
+// GET and HEAD requests of files support ranges and conditional requests
+// using Last-Modified and ETag, Content-Type is taken from s3fs.TypeByExtension.
+// Directories are listed as HTML.
+// If enabled, PUT stores the request body, creating parent directories,
+// and DELETE removes a file or an empty directory,
+// a non-empty directory fails with 409 Conflict.
+type Handler struct {
+	fs   vfs.Filesystem
+	opts Options
+}
+
+// NewHandler returns a Handler serving fs.
+func NewHandler(fs vfs.Filesystem, opts Options) *Handler {
+	if opts.ETag == nil {
+		opts.ETag = DefaultETag
+	}
+	return &Handler{fs: fs, opts: opts}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := filepath.Clean("/" + r.URL.Path)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.get(w, r, name)
+	case http.MethodPut:
+		if h.opts.Writable {
+			h.put(w, r, name)
+			return
+		}
+		h.notAllowed(w)
+	case http.MethodDelete:
+		if h.opts.Writable {
+			h.delete(w, r, name)
+			return
+		}
+		h.notAllowed(w)
+	default:
+		h.notAllowed(w)
+	}
+}
+
+func (h *Handler) notAllowed(w http.ResponseWriter) {
+	allow := "GET, HEAD"
+	if h.opts.Writable {
+		allow += ", PUT, DELETE"
+	}
+	w.Header().Set("Allow", allow)
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+func (h *Handler) error(w http.ResponseWriter, err error) {
+	code := errorStatus(err)
+	http.Error(w, http.StatusText(code), code)
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, name string) {
+	fi, err := h.fs.Stat(name)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	if fi.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, filepath.Base(name)+"/", http.StatusMovedPermanently)
+			return
+		}
+		h.list(w, r, name)
+		return
+	}
+
+	f, err := h.fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	defer f.Close()
+	if etag := h.opts.ETag(fi); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if ctype := s3fs.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	// ServeContent evaluates Range, If-Match, If-None-Match, If-Modified-Since and If-Range.
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request, name string) {
+	fis, err := h.fs.ReadDir(name)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	sort.Slice(fis, func(i, j int) bool { return strings.Compare(fis[i].Name(), fis[j].Name()) < 0 })
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	fmt.Fprintf(w, "<pre>\n")
+	for _, fi := range fis {
+		n := filepath.Base(fi.Name())
+		if fi.IsDir() {
+			n += "/"
+		}
+		u := url.URL{Path: n}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", u.String(), html.EscapeString(n))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, name string) {
+	if name == "/" || strings.HasSuffix(r.URL.Path, "/") {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	created := false
+	if fi, err := h.fs.Stat(name); err == nil {
+		if fi.IsDir() {
+			http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+			return
+		}
+	} else if os.IsNotExist(err) {
+		created = true
+	} else {
+		h.error(w, err)
+		return
+	}
+	if err := vfs.MkdirAll(h.fs, filepath.Dir(name), 0755); err != nil {
+		h.error(w, err)
+		return
+	}
+
+	f, err := h.fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	if _, err := io.Copy(f, r.Body); err != nil {
+		f.Close()
+		h.error(w, err)
+		return
+	}
+	if err := f.Close(); err != nil {
+		h.error(w, err)
+		return
+	}
+	if created {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request, name string) {
+	if name == "/" {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	fi, err := h.fs.Stat(name)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	// Some backends remove directories with their content.
+	if fi.IsDir() {
+		if fis, err := h.fs.ReadDir(name); err != nil {
+			h.error(w, err)
+			return
+		} else if len(fis) > 0 {
+			http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+			return
+		}
+	}
+	if err := h.fs.Remove(name); err != nil {
+		h.error(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,120 @@
+// Package vfshttp serves a vfs.Filesystem over HTTP, either through the
+// http.FileSystem adapter returned by FileSystem or with a Handler.
+package vfshttp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	filepath "path"
+	"sort"
+	"strings"
+
+	"github.com/alexsnet/vfs"
+)
+
+// FileSystem returns an http.FileSystem serving fs, e.g. for http.FileServer.
+// Files must support Seek, directories are listed with ReadDir.
+func FileSystem(fs vfs.Filesystem) http.FileSystem {
+	return &fileSystem{fs: fs}
+}
+
+type fileSystem struct {
+	fs vfs.Filesystem
+}
+
+// Open implements http.FileSystem.
+func (hfs *fileSystem) Open(name string) (http.File, error) {
+	p := filepath.Clean("/" + name)
+	fi, err := hfs.fs.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{fs: hfs.fs, name: p, fi: fi}
+	if fi.IsDir() {
+		return f, nil
+	}
+	f.f, err = hfs.fs.OpenFile(p, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// file is an http.File, directories are not opened on the underlying filesystem.
+type file struct {
+	fs   vfs.Filesystem
+	name string
+	fi   os.FileInfo
+	f    vfs.File
+	// entries of a directory not returned by Readdir yet, nil until the first call.
+	entries []os.FileInfo
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.f == nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: vfs.ErrIsDirectory}
+	}
+	return f.f.Read(p)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.f == nil {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: vfs.ErrIsDirectory}
+	}
+	return f.f.Seek(offset, whence)
+}
+
+// Stat returns the FileInfo of the filesystem, as vfs.File.Stat is optional.
+func (f *file) Stat() (os.FileInfo, error) {
+	return f.fi, nil
+}
+
+// Readdir reads the directory like os.File.Readdir, sorted by name.
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if f.f != nil {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: vfs.ErrNotDirectory}
+	}
+	if f.entries == nil {
+		fis, err := f.fs.ReadDir(f.name)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(fis, func(i, j int) bool { return strings.Compare(fis[i].Name(), fis[j].Name()) < 0 })
+		f.entries = append([]os.FileInfo{}, fis...)
+	}
+	if count <= 0 {
+		fis := f.entries
+		f.entries = f.entries[len(f.entries):]
+		return fis, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+	fis := f.entries[:count]
+	f.entries = f.entries[count:]
+	return fis, nil
+}
+
+func (f *file) Close() error {
+	if f.f == nil {
+		return nil
+	}
+	return f.f.Close()
+}
+
+// errorStatus maps err to an HTTP status code.
+func errorStatus(err error) int {
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	case os.IsPermission(err), errors.Is(err, vfs.ErrReadOnly):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,184 @@
+package vfshttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/alexsnet/vfs"
+	"github.com/alexsnet/vfs/memfs"
+	"github.com/alexsnet/vfs/s3fs"
+)
+
+// s3Info reports the ETag of an object like s3fs.
+type s3Info struct {
+	os.FileInfo
+}
+
+func (fi s3Info) Sys() interface{} { return &s3fs.Stat{ETag: "abc"} }
+
+// s3Like returns the FileInfo of files like s3fs.
+type s3Like struct {
+	vfs.Filesystem
+}
+
+func (fs s3Like) Stat(name string) (os.FileInfo, error) {
+	fi, err := fs.Filesystem.Stat(name)
+	if err != nil || fi.IsDir() {
+		return fi, err
+	}
+	return s3Info{fi}, nil
+}
+
+func newServer(t *testing.T, fs vfs.Filesystem, opts Options) *httptest.Server {
+	srv := httptest.NewServer(NewHandler(fs, opts))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func do(t *testing.T, method, url string, header http.Header, body string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func TestRange(t *testing.T) {
+	fs := memfs.Create()
+	vfs.WriteFile(fs, "/a.txt", []byte("0123456789"), 0644)
+	srv := newServer(t, fs, Options{})
+
+	resp, body := do(t, "GET", srv.URL+"/a.txt", http.Header{"Range": {"bytes=2-4"}}, "")
+	if resp.StatusCode != http.StatusPartialContent || body != "234" {
+		t.Fatalf("Range = %d %q", resp.StatusCode, body)
+	}
+	if cr := resp.Header.Get("Content-Range"); cr != "bytes 2-4/10" {
+		t.Errorf("Content-Range = %q", cr)
+	}
+	etag := resp.Header.Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("ETag = %q", etag)
+	}
+
+	// If-Range only applies to strong validators, the weak ETag sends the whole file.
+	resp, body = do(t, "GET", srv.URL+"/a.txt", http.Header{"Range": {"bytes=2-4"}, "If-Range": {etag}}, "")
+	if resp.StatusCode != http.StatusOK || body != "0123456789" {
+		t.Fatalf("If-Range with weak ETag = %d %q", resp.StatusCode, body)
+	}
+	lastModified := resp.Header.Get("Last-Modified")
+	resp, body = do(t, "GET", srv.URL+"/a.txt", http.Header{"Range": {"bytes=2-4"}, "If-Range": {lastModified}}, "")
+	if resp.StatusCode != http.StatusPartialContent || body != "234" {
+		t.Fatalf("If-Range with Last-Modified = %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestETag(t *testing.T) {
+	fs := memfs.Create()
+	vfs.WriteFile(fs, "/a.txt", []byte("0123456789"), 0644)
+	srv := newServer(t, s3Like{fs}, Options{})
+
+	resp, _ := do(t, "GET", srv.URL+"/a.txt", nil, "")
+	if etag := resp.Header.Get("ETag"); etag != `"abc"` {
+		t.Fatalf("ETag = %q", etag)
+	}
+	resp, body := do(t, "GET", srv.URL+"/a.txt", http.Header{"If-None-Match": {`"abc"`}}, "")
+	if resp.StatusCode != http.StatusNotModified || body != "" {
+		t.Fatalf("If-None-Match = %d %q", resp.StatusCode, body)
+	}
+	resp, body = do(t, "GET", srv.URL+"/a.txt", http.Header{"If-None-Match": {`"other"`}}, "")
+	if resp.StatusCode != http.StatusOK || body != "0123456789" {
+		t.Fatalf("If-None-Match with other ETag = %d %q", resp.StatusCode, body)
+	}
+	resp, body = do(t, "GET", srv.URL+"/a.txt", http.Header{"Range": {"bytes=0-1"}, "If-Range": {`"abc"`}}, "")
+	if resp.StatusCode != http.StatusPartialContent || body != "01" {
+		t.Fatalf("If-Range = %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestList(t *testing.T) {
+	fs := memfs.Create()
+	vfs.MkdirAll(fs, "/dir/sub", 0755)
+	vfs.WriteFile(fs, "/dir/<b>&x y.txt", nil, 0644)
+	srv := newServer(t, fs, Options{})
+
+	resp, _ := do(t, "GET", srv.URL+"/dir", nil, "")
+	if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != "/dir/" {
+		t.Fatalf("directory without slash = %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	resp, body := do(t, "GET", srv.URL+"/dir/", nil, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("listing = %d", resp.StatusCode)
+	}
+	for _, want := range []string{
+		`<a href="%3Cb%3E&x%20y.txt">&lt;b&gt;&amp;x y.txt</a>`,
+		`<a href="sub/">sub/</a>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("listing %q does not contain %q", body, want)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	fs := memfs.Create()
+	srv := newServer(t, fs, Options{Writable: true})
+
+	if resp, _ := do(t, "PUT", srv.URL+"/dir/a", nil, "one"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT of new file = %d", resp.StatusCode)
+	}
+	if resp, _ := do(t, "PUT", srv.URL+"/dir/a", nil, "two"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT of existing file = %d", resp.StatusCode)
+	}
+	if data, err := vfs.ReadFile(fs, "/dir/a"); err != nil || string(data) != "two" {
+		t.Fatalf("file = %q, %v", data, err)
+	}
+
+	// Directories are only removed if empty.
+	if resp, _ := do(t, "DELETE", srv.URL+"/dir", nil, ""); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("DELETE of non-empty directory = %d", resp.StatusCode)
+	}
+	if _, err := fs.Stat("/dir/a"); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := do(t, "DELETE", srv.URL+"/dir/a", nil, ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE of file = %d", resp.StatusCode)
+	}
+	if resp, _ := do(t, "DELETE", srv.URL+"/dir", nil, ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE of empty directory = %d", resp.StatusCode)
+	}
+	if resp, _ := do(t, "DELETE", srv.URL+"/dir", nil, ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("DELETE of missing directory = %d", resp.StatusCode)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	fs := memfs.Create()
+	vfs.WriteFile(fs, "/a", []byte("a"), 0644)
+	srv := newServer(t, fs, Options{})
+
+	for _, method := range []string{"PUT", "DELETE"} {
+		resp, _ := do(t, method, srv.URL+"/a", nil, "b")
+		if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, HEAD" {
+			t.Errorf("%s = %d, Allow %q", method, resp.StatusCode, resp.Header.Get("Allow"))
+		}
+	}
+	if data, err := vfs.ReadFile(fs, "/a"); err != nil || string(data) != "a" {
+		t.Fatalf("file = %q, %v", data, err)
+	}
+}
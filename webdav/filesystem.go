@@ -0,0 +1,231 @@
+package webdav
+
+import (
+	"context"
+	"io"
+	"mime"
+	"os"
+	filepath "path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexsnet/vfs"
+	"github.com/alexsnet/vfs/internal/move"
+	"github.com/alexsnet/vfs/s3fs"
+	dav "golang.org/x/net/webdav"
+)
+
+// FileSystem returns a webdav.FileSystem of golang.org/x/net/webdav backed by fs.
+//
+// Directories are not opened on fs but read with ReadDir.
+// Renames are verified and fall back to copying, as s3fs
+// accepts a rename without performing it.
+func FileSystem(fs vfs.Filesystem) dav.FileSystem {
+	return &fileSystem{fs: fs}
+}
+
+type fileSystem struct {
+	fs vfs.Filesystem
+}
+
+func clean(name string) string {
+	return filepath.Clean("/" + name)
+}
+
+// Mkdir implements webdav.FileSystem.
+func (dfs *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	p := clean(name)
+	if _, err := dfs.fs.Stat(p); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	return dfs.fs.Mkdir(p, perm)
+}
+
+// OpenFile implements webdav.FileSystem.
+func (dfs *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (dav.File, error) {
+	p := clean(name)
+	writing := flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0
+	fi, err := dfs.fs.Stat(p)
+	if err == nil && fi.IsDir() {
+		if writing {
+			return nil, &os.PathError{Op: "open", Path: name, Err: vfs.ErrIsDirectory}
+		}
+		return &file{fs: dfs.fs, name: p}, nil
+	}
+	if err != nil && (!os.IsNotExist(err) || flag&os.O_CREATE == 0) {
+		return nil, err
+	}
+	f, err := dfs.fs.OpenFile(p, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &file{fs: dfs.fs, name: p, f: f, writing: writing}, nil
+}
+
+// RemoveAll implements webdav.FileSystem.
+func (dfs *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	p := clean(name)
+	if p == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	if _, err := dfs.fs.Lstat(p); err != nil {
+		return err
+	}
+	return vfs.RemoveAll(dfs.fs, p)
+}
+
+// Rename implements webdav.FileSystem.
+func (dfs *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldpath, newpath := clean(oldName), clean(newName)
+	if oldpath == "/" || newpath == "/" {
+		return &os.PathError{Op: "rename", Path: oldName, Err: os.ErrPermission}
+	}
+	return move.Rename(dfs.fs, oldpath, newpath)
+}
+
+// Stat implements webdav.FileSystem.
+func (dfs *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	fi, err := dfs.fs.Stat(clean(name))
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{FileInfo: fi, name: clean(name)}, nil
+}
+
+// fileInfo maps the metadata of a file to the getetag and getcontenttype properties.
+type fileInfo struct {
+	os.FileInfo
+	name string
+}
+
+// ETag implements webdav.ETager with the ETag stored by s3fs.
+func (fi *fileInfo) ETag(ctx context.Context) (string, error) {
+	if st, ok := fi.Sys().(*s3fs.Stat); ok && st != nil && st.ETag != "" {
+		return `"` + st.ETag + `"`, nil
+	}
+	return "", dav.ErrNotImplemented
+}
+
+// ContentType implements webdav.ContentTyper by the file extension,
+// which avoids reading the file to detect it.
+func (fi *fileInfo) ContentType(ctx context.Context) (string, error) {
+	ext := filepath.Ext(fi.name)
+	if ctype := s3fs.TypeByExtension(ext); ctype != "" {
+		return ctype, nil
+	}
+	if ctype := mime.TypeByExtension(ext); ctype != "" {
+		return ctype, nil
+	}
+	return "", dav.ErrNotImplemented
+}
+
+// pendingInfo describes a file which has not been stored by the backend yet,
+// e.g. an s3fs object before it is closed.
+type pendingInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi *pendingInfo) Name() string       { return fi.name }
+func (fi *pendingInfo) Size() int64        { return fi.size }
+func (fi *pendingInfo) Mode() os.FileMode  { return 0644 }
+func (fi *pendingInfo) ModTime() time.Time { return fi.modTime }
+func (fi *pendingInfo) IsDir() bool        { return false }
+func (fi *pendingInfo) Sys() interface{}   { return nil }
+
+// file is a webdav.File, directories are not opened on the underlying filesystem.
+type file struct {
+	fs      vfs.Filesystem
+	name    string
+	f       vfs.File
+	writing bool
+
+	mutex   sync.Mutex
+	written int64
+	// entries of a directory not returned by Readdir yet, nil until the first call.
+	entries []os.FileInfo
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.f == nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: vfs.ErrIsDirectory}
+	}
+	return f.f.Read(p)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.f == nil {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: vfs.ErrIsDirectory}
+	}
+	n, err := f.f.Write(p)
+	f.mutex.Lock()
+	f.written += int64(n)
+	f.mutex.Unlock()
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.f == nil {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: vfs.ErrIsDirectory}
+	}
+	return f.f.Seek(offset, whence)
+}
+
+// Stat returns the FileInfo of the filesystem, as vfs.File.Stat is optional.
+// Files being written which are not visible yet are described by the bytes written.
+func (f *file) Stat() (os.FileInfo, error) {
+	fi, err := f.fs.Stat(f.name)
+	if err != nil {
+		if f.writing && os.IsNotExist(err) {
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+			return &pendingInfo{name: filepath.Base(f.name), size: f.written, modTime: time.Now()}, nil
+		}
+		return nil, err
+	}
+	return &fileInfo{FileInfo: fi, name: f.name}, nil
+}
+
+// Readdir reads the directory like os.File.Readdir, sorted by name.
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if f.f != nil {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: vfs.ErrNotDirectory}
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.entries == nil {
+		fis, err := f.fs.ReadDir(f.name)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(fis, func(i, j int) bool { return strings.Compare(fis[i].Name(), fis[j].Name()) < 0 })
+		f.entries = make([]os.FileInfo, len(fis))
+		for i, fi := range fis {
+			f.entries[i] = &fileInfo{FileInfo: fi, name: filepath.Join(f.name, filepath.Base(fi.Name()))}
+		}
+	}
+	if count <= 0 {
+		fis := f.entries
+		f.entries = f.entries[len(f.entries):]
+		return fis, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+	fis := f.entries[:count]
+	f.entries = f.entries[count:]
+	return fis, nil
+}
+
+func (f *file) Close() error {
+	if f.f == nil {
+		return nil
+	}
+	return f.f.Close()
+}
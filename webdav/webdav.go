@@ -0,0 +1,49 @@
+// Package webdav serves a vfs.Filesystem over WebDAV (RFC 4918),
+// so it can be mounted by desktop clients without FUSE.
+//
+// The protocol is implemented by golang.org/x/net/webdav, including
+// PROPFIND, PROPPATCH, MKCOL, COPY, MOVE, LOCK and UNLOCK.
+// Live properties are mapped to file metadata: getcontentlength and
+// getlastmodified to the FileInfo, getetag to the ETag stored by s3fs
+// and getcontenttype to s3fs.TypeByExtension.
+package webdav
+
+import (
+	"net/http"
+
+	"github.com/alexsnet/vfs"
+	dav "golang.org/x/net/webdav"
+)
+
+// Options configure a Handler.
+type Options struct {
+	// Prefix is the URL path prefix stripped from request paths, e.g. "/dav".
+	Prefix string
+	// LockSystem holds the locks of LOCK and UNLOCK, an in-memory lock system if nil.
+	// Handlers serving the same filesystem should share it.
+	LockSystem dav.LockSystem
+	// Logger is called after every request with its error, if any.
+	Logger func(r *http.Request, err error)
+}
+
+// A Handler serves a filesystem over WebDAV.
+type Handler struct {
+	h *dav.Handler
+}
+
+// NewHandler returns a Handler serving fs.
+func NewHandler(fs vfs.Filesystem, opts Options) *Handler {
+	if opts.LockSystem == nil {
+		opts.LockSystem = dav.NewMemLS()
+	}
+	return &Handler{h: &dav.Handler{
+		Prefix:     opts.Prefix,
+		FileSystem: FileSystem(fs),
+		LockSystem: opts.LockSystem,
+		Logger:     opts.Logger,
+	}}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.h.ServeHTTP(w, r)
+}
@@ -0,0 +1,232 @@
+package webdav
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/alexsnet/vfs"
+	"github.com/alexsnet/vfs/memfs"
+)
+
+func newServer(t *testing.T) (*memfs.MemFS, *httptest.Server) {
+	fs := memfs.Create()
+	srv := httptest.NewServer(NewHandler(fs, Options{}))
+	t.Cleanup(srv.Close)
+	return fs, srv
+}
+
+func request(t *testing.T, srv *httptest.Server, method, path, body string, header map[string]string) *http.Response {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func expect(t *testing.T, resp *http.Response, code int) string {
+	t.Helper()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != code {
+		t.Fatalf("%s %s: status %d, want %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, code, body)
+	}
+	return string(body)
+}
+
+func TestPutGet(t *testing.T) {
+	fs, srv := newServer(t)
+	expect(t, request(t, srv, "PUT", "/a.txt", "hello", nil), http.StatusCreated)
+	data, err := vfs.ReadFile(fs, "/a.txt")
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("ReadFile = %q, %v", data, err)
+	}
+	if body := expect(t, request(t, srv, "GET", "/a.txt", "", nil), http.StatusOK); body != "hello" {
+		t.Fatalf("GET = %q", body)
+	}
+	// Parents must exist.
+	expect(t, request(t, srv, "PUT", "/missing/a.txt", "x", nil), http.StatusConflict)
+}
+
+func TestMkcolPropfind(t *testing.T) {
+	fs, srv := newServer(t)
+	expect(t, request(t, srv, "MKCOL", "/dir", "", nil), http.StatusCreated)
+	expect(t, request(t, srv, "MKCOL", "/dir", "", nil), http.StatusMethodNotAllowed)
+	if fi, err := fs.Stat("/dir"); err != nil || !fi.IsDir() {
+		t.Fatalf("Stat = %v, %v", fi, err)
+	}
+	expect(t, request(t, srv, "PUT", "/dir/b.json", "{}", nil), http.StatusCreated)
+
+	body := expect(t, request(t, srv, "PROPFIND", "/dir/", "", map[string]string{"Depth": "1"}), http.StatusMultiStatus)
+	for _, want := range []string{"/dir/b.json", "<D:getcontentlength>2</D:getcontentlength>", "application/json", "<D:collection"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("PROPFIND response lacks %q:\n%s", want, body)
+		}
+	}
+	expect(t, request(t, srv, "PROPFIND", "/nope", "", map[string]string{"Depth": "0"}), http.StatusNotFound)
+}
+
+func TestCopyMove(t *testing.T) {
+	fs, srv := newServer(t)
+	expect(t, request(t, srv, "MKCOL", "/src", "", nil), http.StatusCreated)
+	expect(t, request(t, srv, "PUT", "/src/f", "data", nil), http.StatusCreated)
+
+	expect(t, request(t, srv, "COPY", "/src/", "", map[string]string{"Destination": srv.URL + "/copy/"}), http.StatusCreated)
+	if data, err := vfs.ReadFile(fs, "/copy/f"); err != nil || string(data) != "data" {
+		t.Fatalf("copied file = %q, %v", data, err)
+	}
+	expect(t, request(t, srv, "MOVE", "/copy/", "", map[string]string{"Destination": srv.URL + "/moved/"}), http.StatusCreated)
+	if _, err := fs.Stat("/copy"); !os.IsNotExist(err) {
+		t.Fatalf("source still exists: %v", err)
+	}
+	if data, err := vfs.ReadFile(fs, "/moved/f"); err != nil || string(data) != "data" {
+		t.Fatalf("moved file = %q, %v", data, err)
+	}
+	expect(t, request(t, srv, "MOVE", "/src/f", "", map[string]string{"Destination": srv.URL + "/moved/f", "Overwrite": "F"}), http.StatusPreconditionFailed)
+}
+
+func TestLock(t *testing.T) {
+	_, srv := newServer(t)
+	expect(t, request(t, srv, "PUT", "/f", "v1", nil), http.StatusCreated)
+	lockBody := `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	resp := request(t, srv, "LOCK", "/f", lockBody, map[string]string{"Timeout": "Second-60"})
+	expect(t, resp, http.StatusOK)
+	token := strings.Trim(resp.Header.Get("Lock-Token"), "<>")
+	if token == "" {
+		t.Fatal("no Lock-Token header")
+	}
+
+	expect(t, request(t, srv, "PUT", "/f", "v2", nil), http.StatusLocked)
+	expect(t, request(t, srv, "PUT", "/f", "v2", map[string]string{"If": "(<" + token + ">)"}), http.StatusCreated)
+	expect(t, request(t, srv, "UNLOCK", "/f", "", map[string]string{"Lock-Token": "<" + token + ">"}), http.StatusNoContent)
+	expect(t, request(t, srv, "PUT", "/f", "v3", nil), http.StatusCreated)
+}
+
+// hiddenFS hides files until they are closed, like s3fs objects being uploaded.
+type hiddenFS struct {
+	*memfs.MemFS
+	open map[string]bool
+}
+
+type hiddenFile struct {
+	vfs.File
+	fs   *hiddenFS
+	name string
+}
+
+func (f *hiddenFile) Close() error {
+	delete(f.fs.open, f.name)
+	return f.File.Close()
+}
+
+func (fs *hiddenFS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	f, err := fs.MemFS.OpenFile(name, flag, perm)
+	if err != nil || flag&os.O_CREATE == 0 {
+		return f, err
+	}
+	fs.open[name] = true
+	return &hiddenFile{File: f, fs: fs, name: name}, nil
+}
+
+func (fs *hiddenFS) Stat(name string) (os.FileInfo, error) {
+	if fs.open[name] {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return fs.MemFS.Stat(name)
+}
+
+func TestPendingStat(t *testing.T) {
+	fs := &hiddenFS{MemFS: memfs.Create(), open: map[string]bool{}}
+	dfs := FileSystem(fs)
+	ctx := context.Background()
+	f, err := dfs.OpenFile(ctx, "/new.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("12345")); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fi.(*pendingInfo); !ok || fi.Size() != 5 || fi.Name() != "new.txt" {
+		t.Fatalf("Stat = %T %v %d", fi, fi.Name(), fi.Size())
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err = f.Stat(); err != nil || fi.Size() != 5 {
+		t.Fatalf("Stat after Close = %v, %v", fi, err)
+	}
+	if _, ok := fi.(*pendingInfo); ok {
+		t.Fatal("Stat after Close is still pending")
+	}
+}
+
+// lazyFS accepts renames without performing them.
+type lazyFS struct {
+	*memfs.MemFS
+}
+
+func (fs lazyFS) Rename(oldpath, newpath string) error { return nil }
+
+func TestRenameCopyFallback(t *testing.T) {
+	fs := lazyFS{memfs.Create()}
+	if err := vfs.MkdirAll(fs, "/a/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := vfs.WriteFile(fs, "/a/b/f", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := FileSystem(fs).Rename(context.Background(), "/a", "/c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/a"); !os.IsNotExist(err) {
+		t.Fatalf("source still exists: %v", err)
+	}
+	if data, err := vfs.ReadFile(fs, "/c/b/f"); err != nil || string(data) != "data" {
+		t.Fatalf("ReadFile = %q, %v", data, err)
+	}
+	if err := FileSystem(fs).Rename(context.Background(), "/nope", "/x"); !os.IsNotExist(err) {
+		t.Fatalf("Rename of missing file = %v", err)
+	}
+}
+
+// deniedFS refuses all renames.
+type deniedFS struct {
+	*memfs.MemFS
+}
+
+func (fs deniedFS) Rename(oldpath, newpath string) error {
+	return &os.PathError{Op: "rename", Path: oldpath, Err: os.ErrPermission}
+}
+
+func TestRenameError(t *testing.T) {
+	fs := deniedFS{memfs.Create()}
+	if err := vfs.WriteFile(fs, "/a", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A failed rename is not replaced by copying.
+	if err := FileSystem(fs).Rename(context.Background(), "/a", "/b"); !os.IsPermission(err) {
+		t.Fatalf("Rename = %v", err)
+	}
+	if _, err := fs.Stat("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/b"); !os.IsNotExist(err) {
+		t.Fatalf("target exists: %v", err)
+	}
+}
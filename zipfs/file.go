@@ -0,0 +1,148 @@
+package zipfs
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/alexsnet/vfs"
+)
+
+// file reads an entry of the archive.
+// Stored entries are read directly from the archive,
+// compressed entries through a decompressor positioned at rpos.
+type file struct {
+	name string
+	zf   *zip.File
+
+	mutex  sync.Mutex
+	pos    int64
+	raw    *io.SectionReader
+	rc     io.ReadCloser
+	rpos   int64
+	closed bool
+}
+
+func newFile(fs *FS, name string, zf *zip.File) (*file, error) {
+	f := &file{name: name, zf: zf}
+	if zf.Method == zip.Store {
+		off, err := zf.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		f.raw = io.NewSectionReader(fs.r, off, int64(zf.UncompressedSize64))
+	}
+	return f, nil
+}
+
+func (f *file) Name() string { return f.name }
+
+func (f *file) Stat() (os.FileInfo, error) { return f.zf.FileInfo(), nil }
+
+func (f *file) size() int64 { return int64(f.zf.UncompressedSize64) }
+
+// readAt reads from the entry at off, the mutex must be held.
+func (f *file) readAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrClosed}
+	}
+	if f.raw != nil {
+		return f.raw.ReadAt(p, off)
+	}
+	if off >= f.size() {
+		return 0, io.EOF
+	}
+	if f.rc == nil || off < f.rpos {
+		if f.rc != nil {
+			f.rc.Close()
+		}
+		rc, err := f.zf.Open()
+		if err != nil {
+			return 0, err
+		}
+		f.rc, f.rpos = rc, 0
+	}
+	if off > f.rpos {
+		n, err := io.CopyN(ioutil.Discard, f.rc, off-f.rpos)
+		f.rpos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := io.ReadFull(f.rc, p)
+	f.rpos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := f.readAt(p, f.pos)
+	f.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("ReadAt: negative offset")
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.readAt(p, off)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.pos + offset
+	case io.SeekEnd:
+		abs = f.size() + offset
+	default:
+		return 0, errors.New("Seek: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("Seek: negative position")
+	}
+	f.pos = abs
+	return abs, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: vfs.ErrReadOnly}
+}
+
+func (f *file) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: vfs.ErrReadOnly}
+}
+
+func (f *file) Sync() error { return nil }
+
+func (f *file) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	if f.rc != nil {
+		return f.rc.Close()
+	}
+	return nil
+}
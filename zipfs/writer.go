@@ -0,0 +1,277 @@
+package zipfs
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	filepath "path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexsnet/vfs"
+)
+
+// ErrEntryClosed is returned when writing to an entry after the next one has been opened.
+var ErrEntryClosed = errors.New("Zip entry has been superseded by a later entry")
+
+// A Writer is a write-only vfs.Filesystem building a zip archive.
+//
+// Entries are written sequentially: opening a new file finishes the
+// previous one, which can not be written afterwards.
+// Files can be created only once, parent directories are added implicitly.
+// Close finalizes the archive by writing the central directory.
+type Writer struct {
+	// Method is the compression method of new entries, zip.Deflate by default.
+	Method uint16
+
+	mutex   sync.Mutex
+	zw      *zip.Writer
+	entries map[string]os.FileInfo
+	current *entry
+	closed  bool
+}
+
+// NewWriter returns a Writer writing the archive to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		Method:  zip.Deflate,
+		zw:      zip.NewWriter(w),
+		entries: map[string]os.FileInfo{"/": &dirInfo{name: "/", modTime: time.Now()}},
+	}
+}
+
+// Close finishes the current entry and writes the central directory.
+// It does not close the underlying writer.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.finish()
+	return w.zw.Close()
+}
+
+// finish marks the current entry as superseded, the mutex must be held.
+func (w *Writer) finish() {
+	if w.current != nil {
+		w.current.done = true
+		w.current = nil
+	}
+}
+
+func clean(name string) string {
+	return filepath.Clean("/" + name)
+}
+
+// mkdirs records the parent directories of name, the mutex must be held.
+func (w *Writer) mkdirs(name string) error {
+	for dir := filepath.Dir(name); dir != "/"; dir = filepath.Dir(dir) {
+		if fi, ok := w.entries[dir]; ok {
+			if !fi.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: dir, Err: vfs.ErrNotDirectory}
+			}
+			continue
+		}
+		w.entries[dir] = &dirInfo{name: filepath.Base(dir), modTime: time.Now()}
+	}
+	return nil
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (w *Writer) PathSeparator() uint8 { return '/' }
+
+// OpenFile implements vfs.Filesystem.
+// Only new files can be opened, with os.O_CREATE and os.O_WRONLY or os.O_RDWR.
+// Reading them is not supported.
+func (w *Writer) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	if flag&os.O_CREATE == 0 || flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrNotSupported}
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrClosed}
+	}
+	p := clean(name)
+	if _, ok := w.entries[p]; ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+	if err := w.mkdirs(p); err != nil {
+		return nil, err
+	}
+
+	hdr := &zip.FileHeader{
+		Name:     p[1:],
+		Method:   w.Method,
+		Modified: time.Now(),
+	}
+	hdr.SetMode(perm)
+	w.finish()
+	zw, err := w.zw.CreateHeader(hdr)
+	if err != nil {
+		return nil, err
+	}
+	e := &entry{w: w, name: name, zw: zw, fi: &entryInfo{FileInfo: hdr.FileInfo()}}
+	w.current = e
+	w.entries[p] = e.fi
+	return e, nil
+}
+
+// Remove implements vfs.Filesystem.
+func (w *Writer) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: ErrNotSupported}
+}
+
+// Rename implements vfs.Filesystem.
+func (w *Writer) Rename(oldpath, newpath string) error {
+	return &os.PathError{Op: "rename", Path: oldpath, Err: ErrNotSupported}
+}
+
+// Mkdir implements vfs.Filesystem.
+// The directory is stored as an entry of the archive.
+func (w *Writer) Mkdir(name string, perm os.FileMode) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrClosed}
+	}
+	p := clean(name)
+	if _, ok := w.entries[p]; ok {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	if _, ok := w.entries[filepath.Dir(p)]; !ok {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrNotExist}
+	}
+	hdr := &zip.FileHeader{Name: p[1:] + "/", Modified: time.Now()}
+	hdr.SetMode(os.ModeDir | perm)
+	w.finish()
+	if _, err := w.zw.CreateHeader(hdr); err != nil {
+		return err
+	}
+	w.entries[p] = hdr.FileInfo()
+	return nil
+}
+
+// Stat implements vfs.Filesystem.
+// The size of a file is the number of bytes written so far.
+func (w *Writer) Stat(name string) (os.FileInfo, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	fi, ok := w.entries[clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return copyInfo(fi), nil
+}
+
+// Lstat implements vfs.Filesystem.
+func (w *Writer) Lstat(name string) (os.FileInfo, error) {
+	return w.Stat(name)
+}
+
+// ReadDir implements vfs.Filesystem.
+func (w *Writer) ReadDir(path string) ([]os.FileInfo, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	dir := clean(path)
+	fi, ok := w.entries[dir]
+	if !ok {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: os.ErrNotExist}
+	}
+	if !fi.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: vfs.ErrNotDirectory}
+	}
+	fis := []os.FileInfo{}
+	for p, fi := range w.entries {
+		if p != "/" && filepath.Dir(p) == dir {
+			fis = append(fis, copyInfo(fi))
+		}
+	}
+	sort.Slice(fis, func(i, j int) bool { return strings.Compare(fis[i].Name(), fis[j].Name()) < 0 })
+	return fis, nil
+}
+
+// entryInfo reports the number of bytes written to an entry as its size,
+// as the header must not be modified while the entry is written.
+type entryInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (fi *entryInfo) Size() int64 { return fi.size }
+
+// copyInfo returns a copy of fi not changed by later writes, the mutex must be held.
+func copyInfo(fi os.FileInfo) os.FileInfo {
+	if e, ok := fi.(*entryInfo); ok {
+		return &entryInfo{FileInfo: e.FileInfo, size: e.size}
+	}
+	return fi
+}
+
+// entry is the file currently written to the archive.
+type entry struct {
+	w    *Writer
+	name string
+	zw   io.Writer
+	fi   *entryInfo
+	done bool
+}
+
+func (e *entry) Name() string { return e.name }
+
+func (e *entry) Write(p []byte) (int, error) {
+	e.w.mutex.Lock()
+	defer e.w.mutex.Unlock()
+	if e.done {
+		return 0, &os.PathError{Op: "write", Path: e.name, Err: ErrEntryClosed}
+	}
+	n, err := e.zw.Write(p)
+	e.fi.size += int64(n)
+	return n, err
+}
+
+func (e *entry) Stat() (os.FileInfo, error) {
+	e.w.mutex.Lock()
+	defer e.w.mutex.Unlock()
+	return copyInfo(e.fi), nil
+}
+
+func (e *entry) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: e.name, Err: ErrNotSupported}
+}
+
+func (e *entry) ReadAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: e.name, Err: ErrNotSupported}
+}
+
+// Seek only reports the current offset, as entries are written sequentially.
+func (e *entry) Seek(offset int64, whence int) (int64, error) {
+	e.w.mutex.Lock()
+	defer e.w.mutex.Unlock()
+	if (whence == io.SeekCurrent && offset == 0) || (whence != io.SeekCurrent && offset == e.fi.size) {
+		return e.fi.size, nil
+	}
+	return 0, &os.PathError{Op: "seek", Path: e.name, Err: ErrNotSupported}
+}
+
+func (e *entry) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: e.name, Err: ErrNotSupported}
+}
+
+func (e *entry) Sync() error { return nil }
+
+// Close finishes the entry, the next entry may be opened without closing it.
+func (e *entry) Close() error {
+	e.w.mutex.Lock()
+	defer e.w.mutex.Unlock()
+	if e.w.current == e {
+		e.w.finish()
+	}
+	e.done = true
+	return nil
+}
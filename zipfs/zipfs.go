@@ -0,0 +1,234 @@
+// Package zipfs provides a read-only vfs.Filesystem backed by a zip archive
+// and a Writer building a new archive from vfs.Filesystem operations.
+package zipfs
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	filepath "path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alexsnet/vfs"
+)
+
+// ErrNotSupported is returned by operations not supported by a zip archive.
+var ErrNotSupported = errors.New("Operation not supported by zip archives")
+
+// node is a file or directory of the archive.
+type node struct {
+	name   string
+	file   *zip.File
+	childs map[string]*node
+	// modTime of synthesized directories.
+	modTime time.Time
+}
+
+func (n *node) isDir() bool { return n.childs != nil }
+
+func (n *node) info() os.FileInfo {
+	if n.file != nil {
+		return n.file.FileInfo()
+	}
+	return &dirInfo{name: n.name, modTime: n.modTime}
+}
+
+// dirInfo describes a directory not stored in the archive.
+type dirInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (fi *dirInfo) Name() string       { return fi.name }
+func (fi *dirInfo) Size() int64        { return 0 }
+func (fi *dirInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (fi *dirInfo) ModTime() time.Time { return fi.modTime }
+func (fi *dirInfo) IsDir() bool        { return true }
+func (fi *dirInfo) Sys() interface{}   { return nil }
+
+// A FS serving the entries of a zip archive read-only.
+//
+// Directories missing in the archive are synthesized from the entry paths.
+// Files stored without compression support efficient ReadAt and Seek,
+// compressed files are decompressed again on backward seeks.
+type FS struct {
+	r      io.ReaderAt
+	root   *node
+	closer io.Closer
+}
+
+// Open reads the central directory of the archive of the given size from r.
+func Open(r io.ReaderAt, size int64) (*FS, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	fs := &FS{r: r, root: &node{name: "/", childs: map[string]*node{}}}
+	for _, f := range zr.File {
+		fs.add(f)
+	}
+	return fs, nil
+}
+
+// Load opens the archive name of the filesystem src.
+// The archive stays open until Close is called.
+func Load(src vfs.Filesystem, name string) (*FS, error) {
+	fi, err := src.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := src.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := Open(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	fs.closer = f
+	return fs, nil
+}
+
+// Close closes the archive if it has been opened by Load.
+func (fs *FS) Close() error {
+	if fs.closer != nil {
+		return fs.closer.Close()
+	}
+	return nil
+}
+
+// add inserts f and its parent directories into the tree.
+// Names are cleaned, so entries can not escape the root.
+func (fs *FS) add(f *zip.File) {
+	name := strings.Trim(filepath.Clean("/"+f.Name), "/")
+	if name == "" {
+		return
+	}
+	segs := strings.Split(name, "/")
+	dir := fs.root
+	for _, seg := range segs[:len(segs)-1] {
+		child, ok := dir.childs[seg]
+		if !ok {
+			child = &node{name: seg, childs: map[string]*node{}, modTime: f.Modified}
+			dir.childs[seg] = child
+		} else if !child.isDir() {
+			// A file shadowed by a directory of the same name.
+			child.childs = map[string]*node{}
+			child.file = nil
+		}
+		dir = child
+	}
+
+	base := segs[len(segs)-1]
+	n, ok := dir.childs[base]
+	if !ok {
+		n = &node{name: base}
+		dir.childs[base] = n
+	}
+	if f.FileInfo().IsDir() {
+		if n.childs == nil {
+			n.childs = map[string]*node{}
+		}
+		n.file = f
+	} else if n.childs == nil {
+		n.file = f
+	}
+}
+
+// lookup returns the node of name.
+func (fs *FS) lookup(op, name string) (*node, error) {
+	n := fs.root
+	p := strings.Trim(filepath.Clean("/"+name), "/")
+	if p == "" {
+		return n, nil
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if !n.isDir() {
+			return nil, &os.PathError{Op: op, Path: name, Err: vfs.ErrNotDirectory}
+		}
+		child, ok := n.childs[seg]
+		if !ok {
+			return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+		}
+		n = child
+	}
+	return n, nil
+}
+
+// PathSeparator implements vfs.Filesystem.
+func (fs *FS) PathSeparator() uint8 { return '/' }
+
+// OpenFile implements vfs.Filesystem.
+// Only os.O_RDONLY is supported.
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: vfs.ErrReadOnly}
+	}
+	n, err := fs.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.isDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: vfs.ErrIsDirectory}
+	}
+	return newFile(fs, name, n.file)
+}
+
+// Remove implements vfs.Filesystem.
+func (fs *FS) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: vfs.ErrReadOnly}
+}
+
+// Rename implements vfs.Filesystem.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	return &os.PathError{Op: "rename", Path: oldpath, Err: vfs.ErrReadOnly}
+}
+
+// Mkdir implements vfs.Filesystem.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: vfs.ErrReadOnly}
+}
+
+// Stat implements vfs.Filesystem.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	n, err := fs.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(), nil
+}
+
+// Lstat implements vfs.Filesystem.
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	n, err := fs.lookup("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(), nil
+}
+
+// ReadDir implements vfs.Filesystem.
+// Entries are sorted by name.
+func (fs *FS) ReadDir(path string) ([]os.FileInfo, error) {
+	n, err := fs.lookup("readdir", path)
+	if err != nil {
+		return nil, err
+	}
+	if !n.isDir() {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: vfs.ErrNotDirectory}
+	}
+	names := make([]string, 0, len(n.childs))
+	for name := range n.childs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fis := make([]os.FileInfo, len(names))
+	for i, name := range names {
+		fis[i] = n.childs[name].info()
+	}
+	return fis, nil
+}